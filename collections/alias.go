@@ -0,0 +1,142 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ImageAliasCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewImageAliasCollection(client *mongo.Client) *ImageAliasCollection {
+	return &ImageAliasCollection{Collection: configs.OpenCollection(client, "image_alias")}
+}
+
+// SetAlias points an alias at a tag, creating the alias when it is new
+func (t ImageAliasCollection) SetAlias(alias *models.ImageAlias) (int, error) {
+	if alias.CreatorName == "" || alias.ImageName == "" || alias.Alias == "" || alias.ImageTag == "" {
+		return http.StatusBadRequest, errors.New("creatorName, image name, alias and tag cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alias.UpdatedAt = time.Now().UTC()
+	filter := bson.D{
+		{Key: "creatorName", Value: alias.CreatorName},
+		{Key: "imageName", Value: alias.ImageName},
+		{Key: "alias", Value: alias.Alias},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "imageTag", Value: alias.ImageTag},
+		{Key: "updatedAt", Value: alias.UpdatedAt},
+	}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(alias)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// GetAliasesByImage lists the aliases of an image
+func (t ImageAliasCollection) GetAliasesByImage(creatorName string, imageName string) (*[]models.ImageAlias, int, error) {
+	if creatorName == "" || imageName == "" {
+		return nil, http.StatusBadRequest, errors.New("creator and image name cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "creatorName", Value: creatorName},
+		{Key: "imageName", Value: imageName},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "alias", Value: 1}})
+	cursor, err := t.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	aliases := []models.ImageAlias{}
+	err = cursor.All(ctx, &aliases)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &aliases, http.StatusOK, nil
+}
+
+// ResolveTag returns the tag an alias points at, or the tag itself when it
+// is not an alias
+func (t ImageAliasCollection) ResolveTag(creatorName string, imageName string, imageTag string) (string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var alias models.ImageAlias
+	filter := bson.D{
+		{Key: "creatorName", Value: creatorName},
+		{Key: "imageName", Value: imageName},
+		{Key: "alias", Value: imageTag},
+	}
+	err := t.Collection.FindOne(ctx, filter).Decode(&alias)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return imageTag, http.StatusOK, nil
+		}
+		return "", http.StatusInternalServerError, err
+	}
+
+	return alias.ImageTag, http.StatusOK, nil
+}
+
+// DeleteAlias removes an alias
+func (t ImageAliasCollection) DeleteAlias(creatorName string, imageName string, alias string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "creatorName", Value: creatorName},
+		{Key: "imageName", Value: imageName},
+		{Key: "alias", Value: alias},
+	}
+	res, err := t.Collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if res.DeletedCount == 0 {
+		return http.StatusNotFound, errors.New("alias is not found")
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// DeleteAliasesToTag removes the aliases pointing at a tag that is going away
+func (t ImageAliasCollection) DeleteAliasesToTag(creatorName string, imageName string, imageTag string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "creatorName", Value: creatorName},
+		{Key: "imageName", Value: imageName},
+		{Key: "imageTag", Value: imageTag},
+	}
+	_, err := t.Collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
@@ -10,6 +10,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type AttemptCollection struct {
@@ -110,4 +111,36 @@ func (t AttemptCollection) GetAllAttemptsByParticipant(participant string) (*[]m
 	}
 
 	return &attempts, http.StatusOK, nil
-}
\ No newline at end of file
+}
+// UpsertAttempt creates or updates the attempt identified by its token.
+// The submitted result is left untouched so a late reply cannot reset it.
+func (t AttemptCollection) UpsertAttempt(attempt *models.Attempt) (int, error) {
+	if attempt.Token == "" {
+		return http.StatusBadRequest, errors.New("invalid token parameter")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "token", Value: attempt.Token}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "challengeName", Value: attempt.ChallengeName},
+		{Key: "creatorName", Value: attempt.CreatorName},
+		{Key: "participant", Value: attempt.Participant},
+		{Key: "imageRegistryLink", Value: attempt.ImageRegistryLink},
+		{Key: "sshkey", Value: attempt.SSHkey},
+		{Key: "ipaddress", Value: attempt.IpAddress},
+		{Key: "port", Value: attempt.Port},
+	}}}
+	opts := options.Update().SetUpsert(true)
+	_, err := t.Collection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		// the participant already has an attempt with another token
+		if mongo.IsDuplicateKeyError(err) {
+			return http.StatusConflict, errors.New("participant already has an attempt")
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
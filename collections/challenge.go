@@ -120,4 +120,54 @@ func (t ChallengeCollection) CheckChallengeByChallengeAndCreatorName(challengeNa
 	}
 
 	return http.StatusOK, nil
-}
\ No newline at end of file
+}
+
+// GetChallengesByImage returns the challenges of a creator that run the image
+func (t ChallengeCollection) GetChallengesByImage(imageName string, imageTag string, creatorName string) (*[]models.Challenge, int, error) {
+	if imageName == "" || imageTag == "" || creatorName == "" {
+		return nil, http.StatusBadRequest, errors.New("image name, tag, and creatorName cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "imageName", Value: imageName},
+		{Key: "imageTag", Value: imageTag},
+		{Key: "creatorName", Value: creatorName},
+	}
+	cursor, err := t.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	challenges := []models.Challenge{}
+	err = cursor.All(ctx, &challenges)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &challenges, http.StatusOK, nil
+}
+
+// UpsertChallenge creates or replaces the challenge record identified by its corId
+func (t ChallengeCollection) UpsertChallenge(challenge *models.Challenge) (int, error) {
+	if challenge.CorID == "" {
+		return http.StatusBadRequest, errors.New("corId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "corId", Value: challenge.CorID}}
+	update := bson.D{{Key: "$set", Value: challenge}}
+	opts := options.Update().SetUpsert(true)
+	_, err := t.Collection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
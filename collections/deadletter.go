@@ -0,0 +1,145 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeadLetterCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewDeadLetterCollection(client *mongo.Client) *DeadLetterCollection {
+	return &DeadLetterCollection{Collection: configs.OpenCollection(client, "dead_letter")}
+}
+
+// InsertDeadLetter archives a message taken off the parking-lot queue
+func (t DeadLetterCollection) InsertDeadLetter(dl *models.DeadLetter) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dl.Status = models.DeadLetterStatusParked
+	res, err := t.Collection.InsertOne(ctx, dl)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	dl.Id = res.InsertedID.(primitive.ObjectID)
+
+	return http.StatusCreated, nil
+}
+
+// GetAllDeadLetters lists dead letters, newest first, optionally by status
+func (t DeadLetterCollection) GetAllDeadLetters(status string) (*[]models.DeadLetter, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{}
+	if status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "deadLetteredAt", Value: -1}})
+	cursor, err := t.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	deadLetters := []models.DeadLetter{}
+	err = cursor.All(ctx, &deadLetters)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &deadLetters, http.StatusOK, nil
+}
+
+func (t DeadLetterCollection) GetDeadLetterById(id string) (*models.DeadLetter, int, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid dead letter id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var dl models.DeadLetter
+	filter := bson.D{{Key: "_id", Value: objectId}}
+	err = t.Collection.FindOne(ctx, filter).Decode(&dl)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("dead letter not found")
+		} else {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	return &dl, http.StatusOK, nil
+}
+
+// ClaimDeadLetter marks a parked dead letter as re-driven before it is
+// published, so that concurrent re-drives cannot both publish it
+func (t DeadLetterCollection) ClaimDeadLetter(id string) (*models.DeadLetter, int, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid dead letter id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: objectId},
+		{Key: "status", Value: models.DeadLetterStatusParked},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: models.DeadLetterStatusRedriven},
+		{Key: "redrivenAt", Value: time.Now().UTC()},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var dl models.DeadLetter
+	err = t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&dl)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		// tell a missing dead letter from one that is not parked
+		_, statusCode, err := t.GetDeadLetterById(id)
+		if err != nil {
+			return nil, statusCode, err
+		}
+		return nil, http.StatusConflict, errors.New("dead letter was already re-driven")
+	}
+
+	return &dl, http.StatusOK, nil
+}
+
+// ReleaseDeadLetter parks a claimed dead letter again after its re-drive failed
+func (t DeadLetterCollection) ReleaseDeadLetter(id primitive.ObjectID) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: id}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "status", Value: models.DeadLetterStatusParked}}},
+		{Key: "$unset", Value: bson.D{{Key: "redrivenAt", Value: ""}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
@@ -15,10 +15,14 @@ import (
 
 type ImageCollection struct {
 	Collection *mongo.Collection
+	Aliases    ImageAliasCollection
 }
 
 func NewImageCollection(client *mongo.Client) *ImageCollection {
-	return &ImageCollection{Collection: configs.OpenCollection(client, "image_builder")}
+	return &ImageCollection{
+		Collection: configs.OpenCollection(client, "image_builder"),
+		Aliases:    *NewImageAliasCollection(client),
+	}
 }
 
 func (t ImageCollection) GetAllImages() (*[]models.Image, int, error) {
@@ -96,39 +100,88 @@ func (t ImageCollection) GetImageByCreatorName(creatorName string) (*[]models.Im
 	return &images, http.StatusOK, nil
 }
 
-func (t ImageCollection) CheckImageByImageAndCreatorName(imageName string, creatorName string) (int, error) {
-	if creatorName == "" || imageName == "" {
-		return http.StatusBadRequest, errors.New("creator and image name cannot be empty")
+// CheckImageByImageAndCreatorName checks that a tag of an image is free to
+// upload, it must be neither an existing tag nor an alias
+func (t ImageCollection) CheckImageByImageAndCreatorName(imageName string, imageTag string, creatorName string) (int, error) {
+	if creatorName == "" || imageName == "" || imageTag == "" {
+		return http.StatusBadRequest, errors.New("creator, image name and tag cannot be empty")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var challenge models.Challenge
+	var image models.Image
 	filter := bson.D{{
 		Key:   "imageName",
 		Value: imageName,
 	}, {
 		Key:   "creatorName",
 		Value: creatorName,
+	}, {
+		Key:   "imageTag",
+		Value: imageTag,
 	}}
 
-	err := t.Collection.FindOne(ctx, filter).Decode(&challenge)
+	err := t.Collection.FindOne(ctx, filter).Decode(&image)
 	if err == nil {
-		return http.StatusBadRequest, errors.New("challenge name already exists")
+		return http.StatusBadRequest, errors.New("image tag already exists")
 	}
 
 	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 		return http.StatusInternalServerError, err
 	}
 
+	resolved, statusCode, err := t.Aliases.ResolveTag(creatorName, imageName, imageTag)
+	if err != nil {
+		return statusCode, err
+	}
+	if resolved != imageTag {
+		return http.StatusBadRequest, errors.New("image tag is an alias")
+	}
+
 	return http.StatusOK, nil
 }
 
+// GetImagesByName returns the built tags of an image
+func (t ImageCollection) GetImagesByName(creatorName string, imageName string) (*[]models.Image, int, error) {
+	if creatorName == "" || imageName == "" {
+		return nil, http.StatusBadRequest, errors.New("creator and image name cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "creatorName", Value: creatorName},
+		{Key: "imageName", Value: imageName},
+	}
+	cursor, err := t.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	images := []models.Image{}
+	err = cursor.All(ctx, &images)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &images, http.StatusOK, nil
+}
+
 // ------- FOR CHALLENGE CONTROLLER ---------
-func (t ImageCollection) CheckImageExists(imageName string, imageTag string, creatorName string) (int, error) {
+// CheckImageExists checks that a tag of an image was built and returns the
+// tag, or the tag an alias points at
+func (t ImageCollection) CheckImageExists(imageName string, imageTag string, creatorName string) (string, int, error) {
 	if imageName == "" || imageTag == "" || creatorName == "" {
-		return http.StatusBadRequest, errors.New("image name, tag, and creatorName cannot be empty")
+		return "", http.StatusBadRequest, errors.New("image name, tag, and creatorName cannot be empty")
+	}
+
+	resolved, statusCode, err := t.Aliases.ResolveTag(creatorName, imageName, imageTag)
+	if err != nil {
+		return "", statusCode, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -138,15 +191,59 @@ func (t ImageCollection) CheckImageExists(imageName string, imageTag string, cre
 	filter := bson.D{
 		{Key: "imageName", Value: imageName},
 		{Key: "creatorName", Value: creatorName},
-		{Key: "imageTag", Value: imageTag},
+		{Key: "imageTag", Value: resolved},
 	}
-	err := t.Collection.FindOne(ctx, filter).Decode(&image)
+	err = t.Collection.FindOne(ctx, filter).Decode(&image)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return http.StatusNotFound, errors.New("image is not found given image name, tag, and creatorName")
+			return "", http.StatusNotFound, errors.New("image is not found given image name, tag, and creatorName")
 		} else {
-			return http.StatusInternalServerError, err
+			return "", http.StatusInternalServerError, err
+		}
+	}
+
+	return resolved, http.StatusOK, nil
+}
+
+// UpsertImage creates or replaces the image record identified by its corId
+func (t ImageCollection) UpsertImage(image *models.Image) (int, error) {
+	if image.CorId == "" {
+		return http.StatusBadRequest, errors.New("corId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "corId", Value: image.CorId}}
+	update := bson.D{{Key: "$set", Value: image}}
+	opts := options.Update().SetUpsert(true)
+	_, err := t.Collection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		// another process already created the same image tag
+		if mongo.IsDuplicateKeyError(err) {
+			return http.StatusConflict, errors.New("image tag already exists")
 		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// DeleteImageByCorId removes the image record identified by its corId
+func (t ImageCollection) DeleteImageByCorId(corId string) (int, error) {
+	if corId == "" {
+		return http.StatusBadRequest, errors.New("corId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := t.Collection.DeleteOne(ctx, bson.D{{Key: "corId", Value: corId}})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if res.DeletedCount == 0 {
+		return http.StatusNotFound, errors.New("no image found with given corId")
 	}
 
 	return http.StatusOK, nil
@@ -0,0 +1,98 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MigrationCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewMigrationCollection(client *mongo.Client) *MigrationCollection {
+	return &MigrationCollection{Collection: configs.OpenCollection(client, "migration")}
+}
+
+// ClaimMigration records that a migration started. A migration runs once,
+// so claiming one that is running or completed returns 409, while a failed
+// one can be run again.
+func (t MigrationCollection) ClaimMigration(name string) (*models.Migration, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	migration := models.Migration{
+		Name:      name,
+		Status:    models.MigrationStatusRunning,
+		StartedAt: time.Now().UTC(),
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: name},
+		{Key: "status", Value: models.MigrationStatusFailed},
+	}
+	res, err := t.Collection.ReplaceOne(ctx, filter, migration)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if res.MatchedCount > 0 {
+		return &migration, http.StatusOK, nil
+	}
+
+	_, err = t.Collection.InsertOne(ctx, migration)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, http.StatusConflict, errors.New("migration is running or completed")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &migration, http.StatusOK, nil
+}
+
+// FinishMigration records the outcome of a claimed migration
+func (t MigrationCollection) FinishMigration(name string, result models.MigrationResult, cause error) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set := bson.D{
+		{Key: "status", Value: models.MigrationStatusCompleted},
+		{Key: "migrated", Value: result.Migrated},
+		{Key: "skipped", Value: result.Skipped},
+		{Key: "finishedAt", Value: time.Now().UTC()},
+	}
+	if cause != nil {
+		set[0].Value = models.MigrationStatusFailed
+		set = append(set, bson.E{Key: "error", Value: cause.Error()})
+	}
+
+	_, err := t.Collection.UpdateByID(ctx, name, bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// GetMigration returns the state of a migration
+func (t MigrationCollection) GetMigration(name string) (*models.Migration, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var migration models.Migration
+	err := t.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: name}}).Decode(&migration)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("migration has not been started")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &migration, http.StatusOK, nil
+}
@@ -0,0 +1,223 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// how long a claimed message is hidden from other relays
+const outboxLease = 30 * time.Second
+
+type OutboxCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewOutboxCollection(client *mongo.Client) *OutboxCollection {
+	return &OutboxCollection{Collection: configs.OpenCollection(client, "outbox")}
+}
+
+// InsertMessage records a message to be published to the MQ
+func (t OutboxCollection) InsertMessage(msg *models.OutboxMessage) (int, error) {
+	if msg.CorId == "" || msg.MessageId == "" {
+		return http.StatusBadRequest, errors.New("corId and messageId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	msg.Status = models.OutboxStatusPending
+	msg.CreatedAt = now
+	msg.NextAttemptAt = now.Add(outboxLease)
+
+	res, err := t.Collection.InsertOne(ctx, msg)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	msg.Id = res.InsertedID.(primitive.ObjectID)
+
+	return http.StatusCreated, nil
+}
+
+// ClaimNextPending leases the oldest pending message that is due for an attempt
+func (t OutboxCollection) ClaimNextPending() (*models.OutboxMessage, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	filter := bson.D{
+		{Key: "status", Value: models.OutboxStatusPending},
+		{Key: "nextAttemptAt", Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "nextAttemptAt", Value: now.Add(outboxLease)},
+	}}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var msg models.OutboxMessage
+	err := t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&msg)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("no pending outbox message")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &msg, http.StatusOK, nil
+}
+
+// MarkDispatched records that the message reached the MQ
+func (t OutboxCollection) MarkDispatched(msg *models.OutboxMessage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: msg.Id}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.OutboxStatusDispatched},
+			{Key: "dispatchedAt", Value: time.Now().UTC()},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		{Key: "$unset", Value: bson.D{{Key: "lastError", Value: ""}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// MarkReplayed records that a spooled message reached the MQ. The attempt
+// was already counted when the message was spooled.
+func (t OutboxCollection) MarkReplayed(messageId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "messageId", Value: messageId},
+		{Key: "status", Value: models.OutboxStatusPending},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.OutboxStatusDispatched},
+			{Key: "dispatchedAt", Value: time.Now().UTC()},
+		}},
+		{Key: "$unset", Value: bson.D{{Key: "lastError", Value: ""}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// MarkReplayRefused records that the broker refused a spooled message
+func (t OutboxCollection) MarkReplayRefused(messageId string, cause error) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "messageId", Value: messageId},
+		{Key: "status", Value: models.OutboxStatusPending},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: models.OutboxStatusRefused},
+		{Key: "lastError", Value: cause.Error()},
+	}}}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// MarkFailed records a failed attempt and schedules the next one
+func (t OutboxCollection) MarkFailed(msg *models.OutboxMessage, cause error, retryAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: msg.Id}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "lastError", Value: cause.Error()},
+			{Key: "nextAttemptAt", Value: retryAt.UTC()},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// MarkRefused records that the broker refused the message, it is not retried
+func (t OutboxCollection) MarkRefused(msg *models.OutboxMessage, cause error) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: msg.Id}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.OutboxStatusRefused},
+			{Key: "lastError", Value: cause.Error()},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// GetStats returns the depth and age of the pending backlog
+func (t OutboxCollection) GetStats() (*models.OutboxStats, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "status", Value: models.OutboxStatusPending}}
+	pending, err := t.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	refused, err := t.Collection.CountDocuments(ctx, bson.D{{Key: "status", Value: models.OutboxStatusRefused}})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	stats := models.OutboxStats{Pending: pending, Refused: refused}
+	if pending == 0 {
+		return &stats, http.StatusOK, nil
+	}
+
+	var oldest models.OutboxMessage
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	err = t.Collection.FindOne(ctx, filter, opts).Decode(&oldest)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err == nil {
+		stats.OldestPendingAt = &oldest.CreatedAt
+		stats.OldestPendingAge = time.Since(oldest.CreatedAt).Seconds()
+	}
+
+	return &stats, http.StatusOK, nil
+}
@@ -1,18 +1,30 @@
 package collections
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"log"
+	"math"
 	"net/http"
 	"platform_api/configs"
 	"platform_api/models"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// recordedAt is the time a record was written, from its timestamp when there
+// is one and from its id otherwise
+var recordedAt = bson.D{{Key: "$ifNull", Value: bson.A{
+	"$timestamp",
+	bson.D{{Key: "$toDate", Value: "$_id"}},
+}}}
+
 type ProcessCollection struct {
 	Collection *mongo.Collection
 }
@@ -21,27 +33,95 @@ func NewProcessCollection(client *mongo.Client) *ProcessCollection {
 	return &ProcessCollection{Collection: configs.OpenCollection(client, "process_engine")}
 }
 
-func (t ProcessCollection) GetAllProcesses() (*[]models.Process, int, error) {
+// SearchProcesses returns a page of the records matching the search, ordered
+// by id, and whether more records follow
+func (t ProcessCollection) SearchProcesses(search models.ProcessSearch) (*[]models.ProcessEvent, bool, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	opts := options.Find()
-	cursor, err := t.Collection.Find(ctx, bson.M{}, opts)
+	filter := bson.D{}
+	for _, field := range []bson.E{
+		{Key: "event", Value: search.Event},
+		{Key: "eventStatus", Value: search.EventStatus},
+		{Key: "creatorName", Value: search.CreatorName},
+		{Key: "challengeName", Value: search.ChallengeName},
+	} {
+		if field.Value != "" {
+			filter = append(filter, field)
+		}
+	}
+	if search.Participant != "" {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "participant", Value: search.Participant}},
+			bson.D{{Key: "participants", Value: search.Participant}},
+		}})
+	}
+
+	// records are ordered by id, which starts with their creation time
+	id := bson.D{}
+	if search.From != nil {
+		id = append(id, bson.E{Key: "$gte", Value: primitive.NewObjectIDFromTimestamp(*search.From)})
+	}
+	if search.To != nil {
+		id = append(id, bson.E{Key: "$lt", Value: primitive.NewObjectIDFromTimestamp(*search.To)})
+	}
+	if search.Cursor != nil {
+		op := "$gt"
+		if search.Descending {
+			op = "$lt"
+		}
+		id = append(id, bson.E{Key: op, Value: *search.Cursor})
+	}
+	if len(id) > 0 {
+		filter = append(filter, bson.E{Key: "_id", Value: id})
+	}
+	if timestamp := timestampRange(search.Since, search.Until); len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
+
+	order := 1
+	if search.Descending {
+		order = -1
+	}
+
+	// one more than asked tells whether there is a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: order}}).
+		SetLimit(search.Limit + 1)
+	cursor, err := t.Collection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return nil, false, http.StatusInternalServerError, err
 	}
 
-	var process []models.Process
-	err = cursor.All(ctx, &process)
+	defer cursor.Close(ctx)
 
+	events := []models.ProcessEvent{}
+	err = cursor.All(ctx, &events)
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return nil, false, http.StatusInternalServerError, err
 	}
 
-	return &process, http.StatusOK, nil
+	more := int64(len(events)) > search.Limit
+	if more {
+		events = events[:search.Limit]
+	}
+
+	return &events, more, http.StatusOK, nil
 }
 
-func (t ProcessCollection) GetAllProcessByCorID(corId string) (*[]models.Process, int, error) {
+// timestampRange matches the timestamps in [since, until), either bound may be nil
+func timestampRange(since *time.Time, until *time.Time) bson.D {
+	timestamp := bson.D{}
+	if since != nil {
+		timestamp = append(timestamp, bson.E{Key: "$gte", Value: since.UTC()})
+	}
+	if until != nil {
+		timestamp = append(timestamp, bson.E{Key: "$lt", Value: until.UTC()})
+	}
+	return timestamp
+}
+
+func (t ProcessCollection) GetAllProcessByCorID(corId string, since *time.Time, until *time.Time) (*[]models.Process, int, error) {
 	if corId == "" {
 		return nil, http.StatusBadRequest, errors.New("corId cannot be empty")
 	}
@@ -50,6 +130,9 @@ func (t ProcessCollection) GetAllProcessByCorID(corId string) (*[]models.Process
 	defer cancel()
 
 	filter := bson.D{{Key: "corId", Value: corId}}
+	if timestamp := timestampRange(since, until); len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
 	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}) // -1 for descending order
 	cursor, err := t.Collection.Find(ctx, filter, opts)
 	if err != nil {
@@ -71,7 +154,7 @@ func (t ProcessCollection) GetAllProcessByCorID(corId string) (*[]models.Process
 	return &process, http.StatusOK, nil
 }
 
-func (t ProcessCollection) GetProcessByCreatorName(creatorName string) (*[]models.Process, int , error) {
+func (t ProcessCollection) GetProcessByCreatorName(creatorName string, since *time.Time, until *time.Time) (*[]models.Process, int , error) {
 	if creatorName == "" {
 		return nil, http.StatusBadRequest, errors.New("creatorName cannot be empty")
 	}
@@ -80,6 +163,9 @@ func (t ProcessCollection) GetProcessByCreatorName(creatorName string) (*[]model
 	defer cancel()
 
 	filter := bson.D{{Key: "creatorName", Value: creatorName}}
+	if timestamp := timestampRange(since, until); len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
 	cursor, err := t.Collection.Find(ctx, filter)
 	if err != nil {
 		panic(err)
@@ -124,4 +210,644 @@ func (t ProcessCollection) GetLatestStatusByCorId(corId string) (*models.Process
 	}
 
 	return &process, http.StatusOK, nil
-}
\ No newline at end of file
+}
+
+// GetProcessEventsByCorId returns every record of a process with its id
+func (t ProcessCollection) GetProcessEventsByCorId(corId string) (*[]models.ProcessEvent, int, error) {
+	if corId == "" {
+		return nil, http.StatusBadRequest, errors.New("corId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "corId", Value: corId}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := t.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	var events []models.ProcessEvent
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if len(events) == 0 {
+		return nil, http.StatusNotFound, errors.New("process is not found by the corId")
+	}
+
+	return &events, http.StatusOK, nil
+}
+
+// GetStaleProcesses returns the latest record of every process whose status
+// is pending in its state machine and has not changed since before the cutoff.
+// Processes the sweeper cannot time out are left out, so they never take the
+// place of those it can: records outside the state machines and processes
+// already claimed in swept_process.
+func (t ProcessCollection) GetStaleProcesses(cutoff time.Time, limit int) (*[]models.ProcessEvent, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pending := bson.A{}
+	for _, machine := range models.PROCESS_STATE_MACHINES {
+		pending = append(pending, bson.D{
+			{Key: "event", Value: machine.Event},
+			{Key: "eventStatus", Value: bson.D{{Key: "$in", Value: machine.PendingStatuses()}}},
+		})
+	}
+
+	// the stale pending records are found by index, then only the latest
+	// record of each process is kept
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "$or", Value: pending},
+			{Key: "_id", Value: bson.D{{Key: "$lt", Value: primitive.NewObjectIDFromTimestamp(cutoff)}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: t.Collection.Name()},
+			{Key: "let", Value: bson.D{{Key: "corId", Value: "$corId"}, {Key: "id", Value: "$_id"}}},
+			{Key: "pipeline", Value: mongo.Pipeline{
+				bson.D{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{{Key: "$and", Value: bson.A{
+					bson.D{{Key: "$eq", Value: bson.A{"$corId", "$$corId"}}},
+					bson.D{{Key: "$gt", Value: bson.A{"$_id", "$$id"}}},
+				}}}}}}},
+				bson.D{{Key: "$limit", Value: 1}},
+				bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+			}},
+			{Key: "as", Value: "later"},
+		}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "later", Value: bson.D{{Key: "$size", Value: 0}}}}}},
+		bson.D{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "swept_process"},
+			{Key: "localField", Value: "corId"},
+			{Key: "foreignField", Value: "corId"},
+			{Key: "as", Value: "claims"},
+		}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "claims", Value: bson.D{{Key: "$size", Value: 0}}}}}},
+		bson.D{{Key: "$project", Value: bson.D{{Key: "later", Value: 0}, {Key: "claims", Value: 0}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := t.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	events := []models.ProcessEvent{}
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &events, http.StatusOK, nil
+}
+
+// GetPendingChallengesByImage returns the latest record of every challenge
+// creation still in flight for the image
+func (t ProcessCollection) GetPendingChallengesByImage(imageName string, imageTag string, creatorName string) (*[]models.ProcessEvent, int, error) {
+	if imageName == "" || imageTag == "" || creatorName == "" {
+		return nil, http.StatusBadRequest, errors.New("image name, tag, and creatorName cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// replies do not always repeat the image, so the processes are found
+	// first and their latest status afterwards
+	corIds, err := t.Collection.Distinct(ctx, "corId", bson.D{
+		{Key: "event", Value: models.EventChallengeCreate},
+		{Key: "imageName", Value: imageName},
+		{Key: "imageTag", Value: imageTag},
+		{Key: "creatorName", Value: creatorName},
+	})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	events := []models.ProcessEvent{}
+	if len(corIds) == 0 {
+		return &events, http.StatusOK, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "corId", Value: bson.D{{Key: "$in", Value: corIds}}}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "corId", Value: 1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$corId"},
+			{Key: "latest", Value: bson.D{{Key: "$last", Value: "$$ROOT"}}},
+		}}},
+		bson.D{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$latest"}}}},
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "eventStatus", Value: bson.D{{Key: "$in", Value: models.PendingStatuses()}}},
+		}}},
+	}
+	cursor, err := t.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &events, http.StatusOK, nil
+}
+
+// latencies are counted in buckets 1% wide rather than collected, so the
+// stats of any number of processes fit in one aggregation result
+var latencyBucketWidth = math.Log(1.01)
+
+// GetProcessStats aggregates the processes of a creator, or of everyone,
+// started in the time range
+func (t ProcessCollection) GetProcessStats(creatorName string, from *time.Time, to *time.Time) (*models.ProcessStats, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{}
+	if creatorName != "" {
+		// replies and sweeper records do not always carry the creator, the
+		// processes are found by it and then read with all their records
+		pipeline = append(pipeline,
+			bson.D{{Key: "$match", Value: bson.D{{Key: "creatorName", Value: creatorName}}}},
+			bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$corId"}}}},
+			bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: t.Collection.Name()},
+				{Key: "localField", Value: "_id"},
+				{Key: "foreignField", Value: "corId"},
+				{Key: "as", Value: "records"},
+			}}},
+			bson.D{{Key: "$unwind", Value: "$records"}},
+			bson.D{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$records"}}}},
+		)
+	}
+
+	// one document per process with its first and latest record
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "corId", Value: 1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$corId"},
+			{Key: "creatorName", Value: bson.D{{Key: "$first", Value: "$creatorName"}}},
+			{Key: "event", Value: bson.D{{Key: "$first", Value: "$event"}}},
+			{Key: "eventStatus", Value: bson.D{{Key: "$last", Value: "$eventStatus"}}},
+			{Key: "startedAt", Value: bson.D{{Key: "$first", Value: recordedAt}}},
+			{Key: "updatedAt", Value: bson.D{{Key: "$last", Value: recordedAt}}},
+		}}},
+	)
+	if creatorName != "" {
+		// a process belongs to whoever started it
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "creatorName", Value: creatorName}}}})
+	}
+
+	startedAt := bson.D{}
+	if from != nil {
+		startedAt = append(startedAt, bson.E{Key: "$gte", Value: from.UTC()})
+	}
+	if to != nil {
+		startedAt = append(startedAt, bson.E{Key: "$lt", Value: to.UTC()})
+	}
+	if len(startedAt) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "startedAt", Value: startedAt}}}})
+	}
+
+	countIn := func(statuses []string) bson.D {
+		return bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$in", Value: bson.A{"$eventStatus", statuses}}}, 1, 0,
+		}}}}}
+	}
+
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: bson.D{
+		{Key: "counts", Value: bson.A{
+			bson.D{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{{Key: "event", Value: "$event"}, {Key: "eventStatus", Value: "$eventStatus"}}},
+				{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			}}},
+		}},
+		{Key: "latencies", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{{Key: "eventStatus", Value: bson.D{{Key: "$in", Value: models.TerminalStatuses()}}}}}},
+			bson.D{{Key: "$project", Value: bson.D{
+				{Key: "event", Value: 1},
+				{Key: "durationMs", Value: bson.D{{Key: "$max", Value: bson.A{
+					0, bson.D{{Key: "$subtract", Value: bson.A{"$updatedAt", "$startedAt"}}},
+				}}}},
+			}}},
+			bson.D{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{
+					{Key: "event", Value: "$event"},
+					{Key: "bucket", Value: bson.D{{Key: "$floor", Value: bson.D{{Key: "$divide", Value: bson.A{
+						bson.D{{Key: "$ln", Value: bson.D{{Key: "$add", Value: bson.A{"$durationMs", 1}}}}},
+						latencyBucketWidth,
+					}}}}}},
+				}},
+				{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "maxMs", Value: bson.D{{Key: "$max", Value: "$durationMs"}}},
+			}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "_id.event", Value: 1}, {Key: "_id.bucket", Value: 1}}}},
+		}},
+		{Key: "daily", Value: bson.A{
+			bson.D{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{
+					{Key: "day", Value: bson.D{{Key: "$dateToString", Value: bson.D{
+						{Key: "format", Value: "%Y-%m-%d"},
+						{Key: "date", Value: "$startedAt"},
+					}}}},
+					{Key: "event", Value: "$event"},
+				}},
+				{Key: "started", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "succeeded", Value: countIn(models.StatusesWithOutcome(models.ProcessOutcomeSucceeded))},
+				{Key: "failed", Value: countIn(models.StatusesWithOutcome(models.ProcessOutcomeFailed))},
+			}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "_id.day", Value: 1}, {Key: "_id.event", Value: 1}}}},
+		}},
+	}}})
+
+	cursor, err := t.Collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	var facets []struct {
+		Counts []struct {
+			Id struct {
+				Event       *string `bson:"event"`
+				EventStatus *string `bson:"eventStatus"`
+			} `bson:"_id"`
+			Count int64 `bson:"count"`
+		} `bson:"counts"`
+		Latencies []struct {
+			Id struct {
+				Event *string `bson:"event"`
+			} `bson:"_id"`
+			models.LatencyBucket `bson:",inline"`
+		} `bson:"latencies"`
+		Daily []struct {
+			Id struct {
+				Day   string  `bson:"day"`
+				Event *string `bson:"event"`
+			} `bson:"_id"`
+			Started   int64 `bson:"started"`
+			Succeeded int64 `bson:"succeeded"`
+			Failed    int64 `bson:"failed"`
+		} `bson:"daily"`
+	}
+	err = cursor.All(ctx, &facets)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	stats := models.ProcessStats{
+		CreatorName: creatorName,
+		From:        from,
+		To:          to,
+		Events:      []models.EventStats{},
+		Daily:       []models.DailyProcessStats{},
+	}
+	if len(facets) == 0 {
+		return &stats, http.StatusOK, nil
+	}
+
+	events := map[string]*models.EventStats{}
+	eventStats := func(event *string) *models.EventStats {
+		name := ""
+		if event != nil {
+			name = *event
+		}
+		if _, ok := events[name]; !ok {
+			events[name] = &models.EventStats{Event: name, ByStatus: map[string]int64{}}
+		}
+		return events[name]
+	}
+
+	for _, count := range facets[0].Counts {
+		eventStatus := ""
+		if count.Id.EventStatus != nil {
+			eventStatus = *count.Id.EventStatus
+		}
+		eventStats(count.Id.Event).Add(eventStatus, count.Count)
+	}
+	histograms := map[*models.EventStats][]models.LatencyBucket{}
+	for _, latency := range facets[0].Latencies {
+		event := eventStats(latency.Id.Event)
+		histograms[event] = append(histograms[event], latency.LatencyBucket)
+	}
+	for event, buckets := range histograms {
+		event.LatencyMs = models.NewLatencyPercentiles(buckets)
+	}
+	for _, day := range facets[0].Daily {
+		event := ""
+		if day.Id.Event != nil {
+			event = *day.Id.Event
+		}
+		stats.Daily = append(stats.Daily, models.DailyProcessStats{
+			Day:       day.Id.Day,
+			Event:     event,
+			Started:   day.Started,
+			Succeeded: day.Succeeded,
+			Failed:    day.Failed,
+		})
+	}
+
+	for _, event := range events {
+		stats.Events = append(stats.Events, *event)
+	}
+	sort.Slice(stats.Events, func(i, j int) bool {
+		return stats.Events[i].Event < stats.Events[j].Event
+	})
+
+	return &stats, http.StatusOK, nil
+}
+
+// GetLatestEventByCorId returns the most recently inserted record of a process
+func (t ProcessCollection) GetLatestEventByCorId(corId string) (*models.ProcessEvent, int, error) {
+	if corId == "" {
+		return nil, http.StatusBadRequest, errors.New("corId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var event models.ProcessEvent
+	filter := bson.D{{Key: "corId", Value: corId}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+	err := t.Collection.FindOne(ctx, filter, opts).Decode(&event)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("no process found with corId")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &event, http.StatusOK, nil
+}
+
+// InsertProcess appends a new record to the process_engine timeline
+func (t ProcessCollection) InsertProcess(process *models.Process) (int, error) {
+	if process.CorId == nil || *process.CorId == "" {
+		return http.StatusBadRequest, errors.New("corId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := t.Collection.InsertOne(ctx, process)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return http.StatusConflict, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusCreated, nil
+}
+
+// records written by other replicas or services can get an id that sorts
+// before one already delivered, polling looks back this far from the newest
+// delivered record and skips the ids it has seen
+const followOverlap = 10 * time.Second
+
+// Follow delivers the process_engine records matching filter that come after
+// the given id, in id order, and keeps delivering new ones as they are
+// inserted until ctx is cancelled. It listens on a change stream and falls
+// back to polling every interval when change streams are not available,
+// which is the case on a standalone mongod.
+func (t ProcessCollection) Follow(ctx context.Context, filter bson.M, after primitive.ObjectID, interval time.Duration) <-chan models.ProcessEvent {
+	events := make(chan models.ProcessEvent)
+
+	go func() {
+		defer close(events)
+
+		// last is the newest record handed out, seen the ids handed out
+		// since the overlap before it
+		last := after
+		seen := map[primitive.ObjectID]bool{}
+		emit := func(event models.ProcessEvent) bool {
+			if seen[event.Id] {
+				return true
+			}
+			select {
+			case events <- event:
+				seen[event.Id] = true
+				if bytes.Compare(event.Id[:], last[:]) > 0 {
+					last = event.Id
+				}
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// polling resumes the overlap before last, but never before after
+		resume := func() primitive.ObjectID {
+			from := primitive.NewObjectIDFromTimestamp(last.Timestamp().Add(-followOverlap))
+			if bytes.Compare(from[:], after[:]) < 0 {
+				from = after
+			}
+			for id := range seen {
+				if bytes.Compare(id[:], from[:]) <= 0 {
+					delete(seen, id)
+				}
+			}
+			return from
+		}
+
+		// open the stream before reading the backlog so nothing inserted in
+		// between is lost, seen drops the duplicates
+		stream, err := t.watch(ctx, filter)
+		if err != nil {
+			log.Printf("Change streams unavailable, polling process_engine: %s\n", err)
+		}
+
+		ok := t.emitAfter(ctx, filter, after, emit)
+		if !ok {
+			if stream != nil {
+				stream.Close(context.Background())
+			}
+			return
+		}
+
+		if stream != nil {
+			for stream.Next(ctx) {
+				var change struct {
+					FullDocument models.ProcessEvent `bson:"fullDocument"`
+				}
+				if err := stream.Decode(&change); err != nil {
+					log.Printf("Failed to decode process change: %s\n", err)
+					continue
+				}
+				if bytes.Compare(change.FullDocument.Id[:], after[:]) <= 0 {
+					continue
+				}
+				if !emit(change.FullDocument) {
+					break
+				}
+				resume()
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Process change stream closed, polling process_engine: %s\n", err)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !t.emitAfter(ctx, filter, resume(), emit) {
+					return
+				}
+			}
+		}
+	}()
+
+	return events
+}
+
+// watch opens a change stream on inserts of records matching filter
+func (t ProcessCollection) watch(ctx context.Context, filter bson.M) (*mongo.ChangeStream, error) {
+	match := bson.M{"operationType": "insert"}
+	for key, value := range filter {
+		match["fullDocument."+key] = value
+	}
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: match}}}
+
+	return t.Collection.Watch(ctx, pipeline)
+}
+
+// emitAfter hands every record matching filter after the given id to emit,
+// it reports false once emit gives up
+func (t ProcessCollection) emitAfter(ctx context.Context, filter bson.M, after primitive.ObjectID, emit func(models.ProcessEvent) bool) bool {
+	query := bson.M{"_id": bson.M{"$gt": after}}
+	for key, value := range filter {
+		query[key] = value
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := t.Collection.Find(ctx, query, opts)
+	if err != nil {
+		log.Printf("Failed to poll process_engine: %s\n", err)
+		return ctx.Err() == nil
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var event models.ProcessEvent
+		if err := cursor.Decode(&event); err != nil {
+			log.Printf("Failed to decode process: %s\n", err)
+			continue
+		}
+		if !emit(event) {
+			return false
+		}
+	}
+
+	return ctx.Err() == nil
+}
+
+// MigrateTimestamps rewrites the timestamps stored in older shapes, such as
+// {"unixNano": ...}, as dates. Records without a readable timestamp take the
+// creation time of their id. It returns how many records were rewritten, and
+// how many were skipped because another record of the process has the same
+// time under a unique index left by an older version.
+func (t ProcessCollection) MigrateTimestamps() (models.MigrationResult, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	filter := bson.D{{Key: "timestamp", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$type", Value: "date"}}}}}}
+	cursor, err := t.Collection.Find(ctx, filter)
+	if err != nil {
+		return models.MigrationResult{}, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	result := models.MigrationResult{}
+	for cursor.Next(ctx) {
+		var record struct {
+			Id        primitive.ObjectID `bson:"_id"`
+			Timestamp bson.RawValue      `bson:"timestamp"`
+		}
+		err = cursor.Decode(&record)
+		if err != nil {
+			return result, http.StatusInternalServerError, err
+		}
+
+		timestamp := models.NewProcessTimestamp(record.Id.Timestamp())
+		var stored models.ProcessTime
+		if record.Timestamp.Type != 0 && record.Timestamp.Unmarshal(&stored) == nil && !stored.IsZero() {
+			timestamp = models.NewProcessTimestamp(stored.Time)
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "timestamp", Value: timestamp}}}}
+		_, err = t.Collection.UpdateByID(ctx, record.Id, update)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				log.Printf("Skipped timestamp migration of %s: %s", record.Id.Hex(), err)
+				result.Skipped++
+				continue
+			}
+			return result, http.StatusInternalServerError, err
+		}
+		result.Migrated++
+	}
+	if err = cursor.Err(); err != nil {
+		return result, http.StatusInternalServerError, err
+	}
+
+	return result, http.StatusOK, nil
+}
+
+// GetImageBuilds returns every upload of an image with the latest status of
+// its build, newest first
+func (t ProcessCollection) GetImageBuilds(creatorName string, imageName string) (*[]models.ImageBuild, int, error) {
+	if creatorName == "" || imageName == "" {
+		return nil, http.StatusBadRequest, errors.New("creator and image name cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "event", Value: models.EventImageCreate},
+			{Key: "creatorName", Value: creatorName},
+			{Key: "imageName", Value: imageName},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "corId", Value: 1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$corId"},
+			{Key: "imageTag", Value: bson.D{{Key: "$max", Value: "$imageTag"}}},
+			{Key: "uploadedAt", Value: bson.D{{Key: "$first", Value: recordedAt}}},
+			{Key: "eventStatus", Value: bson.D{{Key: "$last", Value: "$eventStatus"}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "uploadedAt", Value: -1}}}},
+	}
+	cursor, err := t.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	builds := []models.ImageBuild{}
+	err = cursor.All(ctx, &builds)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &builds, http.StatusOK, nil
+}
@@ -0,0 +1,79 @@
+package collections
+
+import (
+	"context"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SweptProcessCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewSweptProcessCollection(client *mongo.Client) *SweptProcessCollection {
+	return &SweptProcessCollection{Collection: configs.OpenCollection(client, "swept_process")}
+}
+
+// InsertSweptProcess records a timed out process. A process is swept once,
+// so sweeping it again, possibly from another replica, returns 409.
+func (t SweptProcessCollection) InsertSweptProcess(swept *models.SweptProcess) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	swept.SweptAt = time.Now().UTC()
+	res, err := t.Collection.InsertOne(ctx, swept)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return http.StatusConflict, err
+		}
+		return http.StatusInternalServerError, err
+	}
+	swept.Id = res.InsertedID.(primitive.ObjectID)
+
+	return http.StatusCreated, nil
+}
+
+// DeleteSweptProcess releases the claim on a process that could not be
+// timed out, so a later sweep tries again
+func (t SweptProcessCollection) DeleteSweptProcess(id primitive.ObjectID) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := t.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// GetSweptProcesses lists timed out processes, newest first
+func (t SweptProcessCollection) GetSweptProcesses(limit int64) (*[]models.SweptProcess, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sweptAt", Value: -1}}).
+		SetLimit(limit)
+	cursor, err := t.Collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	swept := []models.SweptProcess{}
+	err = cursor.All(ctx, &swept)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &swept, http.StatusOK, nil
+}
@@ -0,0 +1,331 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type UploadSessionCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewUploadSessionCollection(client *mongo.Client) *UploadSessionCollection {
+	return &UploadSessionCollection{Collection: configs.OpenCollection(client, "upload_session")}
+}
+
+// InsertSession starts an upload session
+func (t UploadSessionCollection) InsertSession(session *models.UploadSession) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session.Offset = 0
+	session.Parts = []string{}
+	session.Status = models.UploadStatusUploading
+	session.CreatedAt = time.Now().UTC()
+
+	res, err := t.Collection.InsertOne(ctx, session)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	session.Id = res.InsertedID.(primitive.ObjectID)
+
+	return http.StatusCreated, nil
+}
+
+// GetSessionById returns an upload session, expired sessions are gone
+func (t UploadSessionCollection) GetSessionById(id string) (*models.UploadSession, int, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("upload is not found by the id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session models.UploadSession
+	err = t.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: objectId}}).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("upload is not found by the id")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if session.Status == models.UploadStatusUploading && time.Now().After(session.ExpiresAt) {
+		return nil, http.StatusGone, errors.New("upload has expired")
+	}
+
+	return &session, http.StatusOK, nil
+}
+
+// AppendPart records a stored chunk that starts at offset. It returns 409
+// when another request moved the offset first, the chunk is then not part
+// of the upload.
+func (t UploadSessionCollection) AppendPart(session *models.UploadSession, offset int64, part string, size int64, expiresAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: session.Id},
+		{Key: "status", Value: models.UploadStatusUploading},
+		{Key: "offset", Value: offset},
+	}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "offset", Value: size}}},
+		{Key: "$push", Value: bson.D{{Key: "parts", Value: part}}},
+		{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: expiresAt.UTC()}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return http.StatusConflict, errors.New("upload offset has changed")
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// ClaimSession marks a complete upload as being completed by one request
+// until claimedUntil, so that its archive is assembled and built once. It
+// returns 409 when another request holds the upload or finished it. A claim
+// that was never released nor finished can be taken once it ran out.
+func (t UploadSessionCollection) ClaimSession(session *models.UploadSession, claimedUntil time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: session.Id},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "status", Value: models.UploadStatusUploading}},
+			bson.D{
+				{Key: "status", Value: models.UploadStatusCompleting},
+				{Key: "claimedUntil", Value: bson.D{{Key: "$lt", Value: time.Now().UTC()}}},
+			},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: models.UploadStatusCompleting},
+		{Key: "claimedUntil", Value: claimedUntil.UTC()},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return http.StatusConflict, errors.New("upload is being completed or is finished")
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// ReleaseSession gives up the claim on an upload that could not be
+// completed, so that it can be tried again
+func (t UploadSessionCollection) ReleaseSession(session *models.UploadSession) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: session.Id},
+		{Key: "status", Value: models.UploadStatusCompleting},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "status", Value: models.UploadStatusUploading}}},
+		{Key: "$unset", Value: bson.D{{Key: "claimedUntil", Value: ""}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	session.Status = models.UploadStatusUploading
+	session.ClaimedUntil = nil
+	return http.StatusOK, nil
+}
+
+// FinishSession records the outcome of a claimed upload. An upload is
+// finished once, finishing it again returns 409.
+func (t UploadSessionCollection) FinishSession(session *models.UploadSession, status string, violations []models.ArchiveViolation) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set := bson.D{{Key: "status", Value: status}}
+	if len(violations) > 0 {
+		set = append(set, bson.E{Key: "violations", Value: violations})
+	}
+	filter := bson.D{
+		{Key: "_id", Value: session.Id},
+		{Key: "status", Value: models.UploadStatusCompleting},
+	}
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$unset", Value: bson.D{{Key: "claimedUntil", Value: ""}}},
+	}
+	res, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if res.ModifiedCount == 0 {
+		return http.StatusConflict, errors.New("upload is already finished")
+	}
+
+	session.Status = status
+	session.Violations = violations
+	session.ClaimedUntil = nil
+	return http.StatusOK, nil
+}
+
+// DeleteSession removes an upload session
+func (t UploadSessionCollection) DeleteSession(session *models.UploadSession) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := t.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: session.Id}})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusNoContent, nil
+}
+
+type SignedUploadCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewSignedUploadCollection(client *mongo.Client) *SignedUploadCollection {
+	return &SignedUploadCollection{Collection: configs.OpenCollection(client, "signed_upload")}
+}
+
+// InsertSignedUpload records a signed URL handed out for an upload
+func (t SignedUploadCollection) InsertSignedUpload(upload *models.SignedUpload) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload.Status = models.SignedUploadStatusPending
+	upload.CreatedAt = time.Now().UTC()
+
+	res, err := t.Collection.InsertOne(ctx, upload)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	upload.Id = res.InsertedID.(primitive.ObjectID)
+
+	return http.StatusCreated, nil
+}
+
+// GetSignedUploadByCorId returns the signed upload of an image
+func (t SignedUploadCollection) GetSignedUploadByCorId(corId string) (*models.SignedUpload, int, error) {
+	if corId == "" {
+		return nil, http.StatusBadRequest, errors.New("corId cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var upload models.SignedUpload
+	err := t.Collection.FindOne(ctx, bson.D{{Key: "corId", Value: corId}}).Decode(&upload)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("upload is not found by the corId")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &upload, http.StatusOK, nil
+}
+
+// ClaimSignedUpload marks a pending upload as being confirmed by one request
+// until claimedUntil, so that its image is built once. It returns 409 when
+// another request holds the upload or confirmed it. A claim that was never
+// released nor finished can be taken once it ran out.
+func (t SignedUploadCollection) ClaimSignedUpload(upload *models.SignedUpload, claimedUntil time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: upload.Id},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "status", Value: models.SignedUploadStatusPending}},
+			bson.D{
+				{Key: "status", Value: models.SignedUploadStatusConfirming},
+				{Key: "claimedUntil", Value: bson.D{{Key: "$lt", Value: time.Now().UTC()}}},
+			},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: models.SignedUploadStatusConfirming},
+		{Key: "claimedUntil", Value: claimedUntil.UTC()},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(upload)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return http.StatusConflict, errors.New("upload is being confirmed or is already confirmed")
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// ReleaseSignedUpload gives up the claim on an upload that could not be
+// confirmed, so that it can be confirmed again
+func (t SignedUploadCollection) ReleaseSignedUpload(upload *models.SignedUpload) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: upload.Id},
+		{Key: "status", Value: models.SignedUploadStatusConfirming},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "status", Value: models.SignedUploadStatusPending}}},
+		{Key: "$unset", Value: bson.D{{Key: "claimedUntil", Value: ""}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	upload.Status = models.SignedUploadStatusPending
+	upload.ClaimedUntil = nil
+	return http.StatusOK, nil
+}
+
+// FinishSignedUpload records the outcome of a claimed confirmation. An
+// upload is confirmed once, confirming it again returns 409.
+func (t SignedUploadCollection) FinishSignedUpload(upload *models.SignedUpload, status string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: upload.Id},
+		{Key: "status", Value: models.SignedUploadStatusConfirming},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "status", Value: status}}},
+		{Key: "$unset", Value: bson.D{{Key: "claimedUntil", Value: ""}}},
+	}
+	res, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if res.ModifiedCount == 0 {
+		return http.StatusConflict, errors.New("upload is already confirmed")
+	}
+
+	upload.Status = status
+	upload.ClaimedUntil = nil
+	return http.StatusOK, nil
+}
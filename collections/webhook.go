@@ -0,0 +1,319 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// how long a claimed delivery is hidden from other workers
+const webhookLease = 30 * time.Second
+
+type WebhookSubscriptionCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewWebhookSubscriptionCollection(client *mongo.Client) *WebhookSubscriptionCollection {
+	return &WebhookSubscriptionCollection{Collection: configs.OpenCollection(client, "webhook_subscription")}
+}
+
+// InsertSubscription registers a webhook
+func (t WebhookSubscriptionCollection) InsertSubscription(sub *models.WebhookSubscription) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	sub.CreatedAt = time.Now().UTC()
+
+	res, err := t.Collection.InsertOne(ctx, sub)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	sub.Id = res.InsertedID.(primitive.ObjectID)
+
+	return http.StatusCreated, nil
+}
+
+// GetSubscriptionsByCreatorName lists the webhooks of a creator
+func (t WebhookSubscriptionCollection) GetSubscriptionsByCreatorName(creatorName string) (*[]models.WebhookSubscription, int, error) {
+	if creatorName == "" {
+		return nil, http.StatusBadRequest, errors.New("creatorName cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}}
+	cursor, err := t.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	subs := []models.WebhookSubscription{}
+	err = cursor.All(ctx, &subs)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &subs, http.StatusOK, nil
+}
+
+// GetMatchingSubscriptions returns the webhooks of a creator that want the event
+func (t WebhookSubscriptionCollection) GetMatchingSubscriptions(creatorName string, event string) (*[]models.WebhookSubscription, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "creatorName", Value: creatorName},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "events", Value: bson.D{{Key: "$size", Value: 0}}}},
+			bson.D{{Key: "events", Value: event}},
+		}},
+	}
+	cursor, err := t.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	subs := []models.WebhookSubscription{}
+	err = cursor.All(ctx, &subs)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &subs, http.StatusOK, nil
+}
+
+// GetSubscriptionById returns a single webhook
+func (t WebhookSubscriptionCollection) GetSubscriptionById(id string) (*models.WebhookSubscription, int, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid webhook id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var sub models.WebhookSubscription
+	err = t.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: objectId}}).Decode(&sub)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("webhook is not found by the id")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &sub, http.StatusOK, nil
+}
+
+// DeleteSubscription removes a webhook, pending deliveries fail on their next attempt
+func (t WebhookSubscriptionCollection) DeleteSubscription(id string) (int, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return http.StatusBadRequest, errors.New("invalid webhook id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := t.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: objectId}})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if res.DeletedCount == 0 {
+		return http.StatusNotFound, errors.New("webhook is not found by the id")
+	}
+
+	return http.StatusNoContent, nil
+}
+
+type WebhookDeliveryCollection struct {
+	Collection *mongo.Collection
+}
+
+func NewWebhookDeliveryCollection(client *mongo.Client) *WebhookDeliveryCollection {
+	return &WebhookDeliveryCollection{Collection: configs.OpenCollection(client, "webhook_delivery")}
+}
+
+// InsertDelivery schedules a delivery, a process record is delivered to a
+// subscription at most once so scheduling it again returns 409
+func (t WebhookDeliveryCollection) InsertDelivery(delivery *models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	delivery.Status = models.WebhookDeliveryStatusPending
+	delivery.Log = []models.WebhookAttempt{}
+	delivery.CreatedAt = now
+	delivery.NextAttemptAt = now
+
+	res, err := t.Collection.InsertOne(ctx, delivery)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return http.StatusConflict, err
+		}
+		return http.StatusInternalServerError, err
+	}
+	delivery.Id = res.InsertedID.(primitive.ObjectID)
+
+	return http.StatusCreated, nil
+}
+
+// ClaimNextPending leases the oldest pending delivery that is due for an attempt
+func (t WebhookDeliveryCollection) ClaimNextPending() (*models.WebhookDelivery, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	filter := bson.D{
+		{Key: "status", Value: models.WebhookDeliveryStatusPending},
+		{Key: "nextAttemptAt", Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "nextAttemptAt", Value: now.Add(webhookLease)},
+	}}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var delivery models.WebhookDelivery
+	err := t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusNotFound, errors.New("no pending webhook delivery")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &delivery, http.StatusOK, nil
+}
+
+// RecordAttempt logs an attempt. A nil retryAt with a failed attempt gives
+// up on the delivery.
+func (t WebhookDeliveryCollection) RecordAttempt(delivery *models.WebhookDelivery, attempt models.WebhookAttempt, delivered bool, retryAt *time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set := bson.D{}
+	switch {
+	case delivered:
+		set = append(set,
+			bson.E{Key: "status", Value: models.WebhookDeliveryStatusDelivered},
+			bson.E{Key: "deliveredAt", Value: attempt.At},
+		)
+	case retryAt != nil:
+		set = append(set, bson.E{Key: "nextAttemptAt", Value: retryAt.UTC()})
+	default:
+		set = append(set, bson.E{Key: "status", Value: models.WebhookDeliveryStatusFailed})
+	}
+
+	filter := bson.D{{Key: "_id", Value: delivery.Id}}
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		{Key: "$push", Value: bson.D{{Key: "log", Value: attempt}}},
+	}
+	_, err := t.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// GetDeliveriesBySubscriptionId lists the deliveries of a webhook, newest first
+func (t WebhookDeliveryCollection) GetDeliveriesBySubscriptionId(id string, status string) (*[]models.WebhookDelivery, int, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid webhook id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "subscriptionId", Value: objectId}}
+	if status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := t.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	err = cursor.All(ctx, &deliveries)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &deliveries, http.StatusOK, nil
+}
+
+// Redeliver schedules another attempt of a delivery of the given webhook
+func (t WebhookDeliveryCollection) Redeliver(subscriptionId string, id string) (*models.WebhookDelivery, int, error) {
+	subObjectId, err := primitive.ObjectIDFromHex(subscriptionId)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid webhook id")
+	}
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid delivery id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: objectId},
+		{Key: "subscriptionId", Value: subObjectId},
+		{Key: "status", Value: bson.D{{Key: "$ne", Value: models.WebhookDeliveryStatusPending}}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.WebhookDeliveryStatusPending},
+			{Key: "nextAttemptAt", Value: time.Now().UTC()},
+		}},
+		{Key: "$unset", Value: bson.D{{Key: "deliveredAt", Value: ""}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var delivery models.WebhookDelivery
+	err = t.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err == nil {
+		return &delivery, http.StatusAccepted, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	// tell a missing delivery apart from one that is still being attempted
+	count, err := t.Collection.CountDocuments(ctx, bson.D{
+		{Key: "_id", Value: objectId},
+		{Key: "subscriptionId", Value: subObjectId},
+	})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if count == 0 {
+		return nil, http.StatusNotFound, errors.New("delivery is not found by the id")
+	}
+	return nil, http.StatusConflict, errors.New("delivery is still pending")
+}
@@ -0,0 +1,79 @@
+package configs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ArchiveLimits bound the challenge archives creators upload
+type ArchiveLimits struct {
+	MaxSize             int64
+	MaxUncompressedSize int64
+	MaxFiles            int
+}
+
+// ARCHIVE_LIMITS are the limits in use, the defaults until InitEnv reads
+// ARCHIVE_MAX_SIZE, ARCHIVE_MAX_UNCOMPRESSED_SIZE and ARCHIVE_MAX_FILES
+var ARCHIVE_LIMITS = ArchiveLimits{
+	MaxSize:             100 << 20,
+	MaxUncompressedSize: 500 << 20,
+	MaxFiles:            10000,
+}
+
+// ParseArchiveLimits reads the limits over the defaults, empty values keep
+// the default
+func ParseArchiveLimits(maxSize string, maxUncompressedSize string, maxFiles string) (ArchiveLimits, error) {
+	limits := ARCHIVE_LIMITS
+
+	var err error
+	if maxSize != "" {
+		limits.MaxSize, err = ParseByteSize(maxSize)
+		if err != nil {
+			return limits, fmt.Errorf("invalid maximum archive size: %w", err)
+		}
+	}
+	if maxUncompressedSize != "" {
+		limits.MaxUncompressedSize, err = ParseByteSize(maxUncompressedSize)
+		if err != nil {
+			return limits, fmt.Errorf("invalid maximum uncompressed size: %w", err)
+		}
+	}
+	if maxFiles != "" {
+		limits.MaxFiles, err = strconv.Atoi(maxFiles)
+		if err != nil || limits.MaxFiles <= 0 {
+			return limits, fmt.Errorf("invalid maximum file count %q", maxFiles)
+		}
+	}
+
+	return limits, nil
+}
+
+// ParseByteSize reads sizes such as "512", "64KB", "100MB" or "1GB", where a
+// KB is 1024 bytes
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("expected a positive size such as 100MB, got %q", s)
+	}
+	return size * multiplier, nil
+}
@@ -88,15 +88,45 @@ func InitIndexes(client *mongo.Client) {
     // Index for `process_engine` collection
 	processCollection := OpenCollection(client, "process_engine")
 
-	processUniqueIndex := mongo.IndexModel{
+	// dates are stored to the millisecond, two statuses of a process can
+	// share one, so the timeline is not unique and replies are deduped on the
+	// id of their message instead
+	dropUniqueIndex(processCollection, "corId_1_timestamp_1")
+	processTimelineIndex := mongo.IndexModel{
 		Keys: bson.D{
 			{Key: "corId", Value: 1},
-            {Key: "timestamp", Value: 1},
+			{Key: "timestamp", Value: 1},
 		},
-		Options: options.Index().SetUnique(true),
+	}
+	processMessageIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "messageId", Value: 1},
+		},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{
+			{Key: "messageId", Value: bson.D{{Key: "$type", Value: "string"}}},
+		}),
 	}
 
-	processIndexCreated, err := processCollection.Indexes().CreateOne(context.Background(), processUniqueIndex)
+	// latest record per process, for the sweeper
+	processLatestIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "corId", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+	}
+
+	// filters of the process search, sorted by id
+	processSearchIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "creatorName", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "challengeName", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "participant", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "participants", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "event", Value: 1}, {Key: "eventStatus", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "eventStatus", Value: 1}, {Key: "_id", Value: 1}}},
+	}
+
+	processIndexModels := append([]mongo.IndexModel{processTimelineIndex, processMessageIndex, processLatestIndex}, processSearchIndexes...)
+	processIndexCreated, err := processCollection.Indexes().CreateMany(context.Background(), processIndexModels)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -125,13 +155,197 @@ func InitIndexes(client *mongo.Client) {
 	}
 
 
+	// Index for `outbox` collection
+	outboxCollection := OpenCollection(client, "outbox")
+
+	outboxIndexModels := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "nextAttemptAt", Value: 1},
+				{Key: "createdAt", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "corId", Value: 1},
+			},
+		},
+	}
+	outboxIndexCreated, err := outboxCollection.Indexes().CreateMany(context.Background(), outboxIndexModels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index for `dead_letter` collection
+	deadLetterCollection := OpenCollection(client, "dead_letter")
+
+	deadLetterIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "deadLetteredAt", Value: -1},
+		},
+	}
+	deadLetterIndexCreated, err := deadLetterCollection.Indexes().CreateOne(context.Background(), deadLetterIndexModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index for `webhook_subscription` collection
+	webhookSubscriptionCollection := OpenCollection(client, "webhook_subscription")
+
+	webhookSubscriptionIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "creatorName", Value: 1},
+		},
+	}
+	webhookSubscriptionIndexCreated, err := webhookSubscriptionCollection.Indexes().CreateOne(context.Background(), webhookSubscriptionIndexModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index for `webhook_delivery` collection
+	webhookDeliveryCollection := OpenCollection(client, "webhook_delivery")
+
+	webhookDeliveryIndexModels := []mongo.IndexModel{
+		{
+			// a process record is delivered to a subscription once
+			Keys: bson.D{
+				{Key: "subscriptionId", Value: 1},
+				{Key: "processId", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "nextAttemptAt", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "subscriptionId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+	}
+	webhookDeliveryIndexCreated, err := webhookDeliveryCollection.Indexes().CreateMany(context.Background(), webhookDeliveryIndexModels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index for `swept_process` collection
+	sweptProcessCollection := OpenCollection(client, "swept_process")
+
+	sweptProcessIndexModels := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "corId", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "sweptAt", Value: -1},
+			},
+		},
+	}
+	sweptProcessIndexCreated, err := sweptProcessCollection.Indexes().CreateMany(context.Background(), sweptProcessIndexModels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index for `image_alias` collection
+	imageAliasCollection := OpenCollection(client, "image_alias")
+
+	imageAliasIndexModels := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "creatorName", Value: 1},
+				{Key: "imageName", Value: 1},
+				{Key: "alias", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "creatorName", Value: 1},
+				{Key: "imageName", Value: 1},
+				{Key: "imageTag", Value: 1},
+			},
+		},
+	}
+	imageAliasIndexCreated, err := imageAliasCollection.Indexes().CreateMany(context.Background(), imageAliasIndexModels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index for `upload_session` collection
+	uploadSessionCollection := OpenCollection(client, "upload_session")
+
+	// sessions are dropped a week after they expired, the parts of abandoned
+	// uploads are left to the lifecycle rules of the bucket
+	uploadSessionIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "expiresAt", Value: 1},
+		},
+		Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60),
+	}
+	uploadSessionIndexCreated, err := uploadSessionCollection.Indexes().CreateOne(context.Background(), uploadSessionIndexModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index for `signed_upload` collection
+	signedUploadCollection := OpenCollection(client, "signed_upload")
+
+	signedUploadIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "corId", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	signedUploadIndexCreated, err := signedUploadCollection.Indexes().CreateOne(context.Background(), signedUploadIndexModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	fmt.Printf("Created Image Index %s\n", imageIndexCreated)
 	fmt.Printf("Created Challenge Index %s\n", challengeIndexCreated)
 	fmt.Printf("Created Engine Index %s\n", processIndexCreated)
 	fmt.Printf("Created Engine Index %s\n", attemptIndexCreated)
+	fmt.Printf("Created Outbox Index %s\n", outboxIndexCreated)
+	fmt.Printf("Created Dead Letter Index %s\n", deadLetterIndexCreated)
+	fmt.Printf("Created Webhook Subscription Index %s\n", webhookSubscriptionIndexCreated)
+	fmt.Printf("Created Webhook Delivery Index %s\n", webhookDeliveryIndexCreated)
+	fmt.Printf("Created Swept Process Index %s\n", sweptProcessIndexCreated)
+	fmt.Printf("Created Image Alias Index %s\n", imageAliasIndexCreated)
+	fmt.Printf("Created Upload Session Index %s\n", uploadSessionIndexCreated)
+	fmt.Printf("Created Signed Upload Index %s\n", signedUploadIndexCreated)
 }
 
 func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
 	var collection *mongo.Collection = client.Database("cob").Collection(collectionName)
 	return collection
 }
+
+// dropUniqueIndex drops an index created unique by an older version, so that
+// it can be created again without the constraint
+func dropUniqueIndex(collection *mongo.Collection, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, spec := range specs {
+		if spec.Name == name && spec.Unique != nil && *spec.Unique {
+			_, err = collection.Indexes().DropOne(ctx, name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Dropped unique index %s\n", name)
+		}
+	}
+}
@@ -16,7 +16,47 @@ var (
 	AMQP_USERNAME string
 	AMQP_PASSWORD string
 
+	AMQP_CHANNEL_POOL_SIZE string
+
+	// port of the RabbitMQ management API, the topology policies are set through it
+	AMQP_MANAGEMENT_PORT string
+
+	// "amqp" or "memory"
+	MQ_DRIVER string
+
+	// optional path to a YAML or JSON MQ topology
+	MQ_TOPOLOGY_FILE string
+
+	// where publishes are spooled while RabbitMQ is unreachable
+	MQ_SPOOL_DIR string
+
 	MONGO_URI string
+
+	OUTBOX_RELAY_INTERVAL string
+
+	// how often process streams poll when change streams are unavailable
+	PROCESS_STREAM_POLL_INTERVAL string
+
+	// origins allowed to open process feeds besides the API's own host,
+	// "https://a.example,https://b.example"
+	FEED_ALLOWED_ORIGINS string
+
+	WEBHOOK_DELIVERY_INTERVAL string
+
+	// per event overrides of the stuck thresholds, "imageCreate=20m,..."
+	PROCESS_STUCK_THRESHOLDS string
+
+	// processes pending for longer than PROCESS_TIMEOUT are timed out
+	PROCESS_TIMEOUT        string
+	PROCESS_SWEEP_INTERVAL string
+
+	// limits on uploaded archives, sizes such as "100MB"
+	ARCHIVE_MAX_SIZE              string
+	ARCHIVE_MAX_UNCOMPRESSED_SIZE string
+	ARCHIVE_MAX_FILES             string
+
+	// how long a signed upload URL can be used
+	UPLOAD_URL_EXPIRY string
 )
 
 func InitEnv() {
@@ -35,9 +75,49 @@ func InitEnv() {
 	AMQP_HOSTNAME = getEnv("AMQP_HOSTNAME", "rabbitmq.default.svc.cluster.local")
 	AMQP_USERNAME = getEnv("AMQP_USERNAME", "rabbit")
 	AMQP_PASSWORD = getEnv("AMQP_PASSWORD", "rabbit")
+	AMQP_CHANNEL_POOL_SIZE = getEnv("AMQP_CHANNEL_POOL_SIZE", "8")
+	AMQP_MANAGEMENT_PORT = getEnv("AMQP_MANAGEMENT_PORT", "15672")
+	MQ_DRIVER = getEnv("MQ_DRIVER", "amqp")
+	MQ_TOPOLOGY_FILE = getEnv("MQ_TOPOLOGY_FILE", "")
+	MQ_SPOOL_DIR = getEnv("MQ_SPOOL_DIR", "/app/spool")
+	if MQ_TOPOLOGY_FILE != "" {
+		TOPOLOGY, err = LoadTopology(MQ_TOPOLOGY_FILE)
+		if err != nil {
+			panic(fmt.Sprintf("Error loading MQ topology: %s", err))
+		}
+	}
 
 	MONGO_URI = GetMongoURI()
 
+	// outbox
+	OUTBOX_RELAY_INTERVAL = getEnv("OUTBOX_RELAY_INTERVAL", "2s")
+
+	// process streams
+	PROCESS_STREAM_POLL_INTERVAL = getEnv("PROCESS_STREAM_POLL_INTERVAL", "1s")
+	FEED_ALLOWED_ORIGINS = getEnv("FEED_ALLOWED_ORIGINS", "")
+
+	PROCESS_STUCK_THRESHOLDS = getEnv("PROCESS_STUCK_THRESHOLDS", "")
+	STUCK_THRESHOLDS, err = ParseStuckThresholds(PROCESS_STUCK_THRESHOLDS)
+	if err != nil {
+		panic(fmt.Sprintf("Error loading PROCESS_STUCK_THRESHOLDS: %s", err))
+	}
+
+	PROCESS_TIMEOUT = getEnv("PROCESS_TIMEOUT", "30m")
+	PROCESS_SWEEP_INTERVAL = getEnv("PROCESS_SWEEP_INTERVAL", "1m")
+
+	// webhooks
+	WEBHOOK_DELIVERY_INTERVAL = getEnv("WEBHOOK_DELIVERY_INTERVAL", "2s")
+
+	// uploads
+	ARCHIVE_MAX_SIZE = getEnv("ARCHIVE_MAX_SIZE", "")
+	ARCHIVE_MAX_UNCOMPRESSED_SIZE = getEnv("ARCHIVE_MAX_UNCOMPRESSED_SIZE", "")
+	ARCHIVE_MAX_FILES = getEnv("ARCHIVE_MAX_FILES", "")
+	ARCHIVE_LIMITS, err = ParseArchiveLimits(ARCHIVE_MAX_SIZE, ARCHIVE_MAX_UNCOMPRESSED_SIZE, ARCHIVE_MAX_FILES)
+	if err != nil {
+		panic(fmt.Sprintf("Error loading archive limits: %s", err))
+	}
+	UPLOAD_URL_EXPIRY = getEnv("UPLOAD_URL_EXPIRY", "15m")
+
 }
 
 func GetMongoURI() string {
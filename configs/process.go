@@ -0,0 +1,52 @@
+package configs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// how long a process may stay in one status before it counts as stuck, per
+// event type, DEFAULT_STUCK_THRESHOLD applies to the rest
+var STUCK_THRESHOLDS = map[string]time.Duration{
+	"imageCreate":     15 * time.Minute,
+	"challengeCreate": 10 * time.Minute,
+	"challengeStart":  5 * time.Minute,
+}
+
+const DEFAULT_STUCK_THRESHOLD = 10 * time.Minute
+
+// StuckThreshold returns the stuck threshold of an event type
+func StuckThreshold(event string) time.Duration {
+	if threshold, ok := STUCK_THRESHOLDS[event]; ok {
+		return threshold
+	}
+	return DEFAULT_STUCK_THRESHOLD
+}
+
+// ParseStuckThresholds reads thresholds such as
+// "imageCreate=20m,challengeStart=2m" over the defaults
+func ParseStuckThresholds(s string) (map[string]time.Duration, error) {
+	thresholds := map[string]time.Duration{}
+	for event, threshold := range STUCK_THRESHOLDS {
+		thresholds[event] = threshold
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		event, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected event=duration, got %q", pair)
+		}
+		threshold, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid threshold for %s: %q", event, value)
+		}
+		thresholds[strings.TrimSpace(event)] = threshold
+	}
+
+	return thresholds, nil
+}
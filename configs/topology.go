@@ -0,0 +1,294 @@
+package configs
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed topology.yaml
+var defaultTopology []byte
+
+// event types every topology has to route
+var requiredEvents = []string{"imageCreate", "challengeCreate", "challengeStart", "processCancel"}
+
+// event types published without expecting a reply
+var notificationEvents = []string{"processTimeout", "imageDelete"}
+
+type ExchangeConfig struct {
+	Name       string                 `yaml:"name"`
+	Type       string                 `yaml:"type"`
+	Durable    bool                   `yaml:"durable"`
+	AutoDelete bool                   `yaml:"autoDelete"`
+	Internal   bool                   `yaml:"internal"`
+	Arguments  map[string]interface{} `yaml:"arguments"`
+}
+
+type QueueConfig struct {
+	Name       string                 `yaml:"name"`
+	Durable    bool                   `yaml:"durable"`
+	AutoDelete bool                   `yaml:"autoDelete"`
+	Exclusive  bool                   `yaml:"exclusive"`
+	Arguments  map[string]interface{} `yaml:"arguments"`
+}
+
+type BindingConfig struct {
+	Queue      string                 `yaml:"queue"`
+	Exchange   string                 `yaml:"exchange"`
+	RoutingKey string                 `yaml:"routingKey"`
+	Arguments  map[string]interface{} `yaml:"arguments"`
+}
+
+// PolicyConfig is a RabbitMQ policy. Unlike queue arguments, a policy can
+// change queues that already exist.
+type PolicyConfig struct {
+	Name       string                 `yaml:"name"`
+	Pattern    string                 `yaml:"pattern"`
+	ApplyTo    string                 `yaml:"applyTo"`
+	Priority   int                    `yaml:"priority"`
+	Definition map[string]interface{} `yaml:"definition"`
+}
+
+// RolesConfig names the exchanges and queues the platform itself relies on
+type RolesConfig struct {
+	Router          string `yaml:"router"`
+	DeadLetter      string `yaml:"deadLetter"`
+	Retry           string `yaml:"retry"`
+	ReplyQueue      string `yaml:"replyQueue"`
+	ParkingLotQueue string `yaml:"parkingLotQueue"`
+}
+
+// EventRoutes holds the routing keys of an event type
+type EventRoutes struct {
+	Request string `yaml:"request"`
+	Reply   string `yaml:"reply"`
+}
+
+type TopologyConfig struct {
+	Exchanges   []ExchangeConfig       `yaml:"exchanges"`
+	Queues      []QueueConfig          `yaml:"queues"`
+	Bindings    []BindingConfig        `yaml:"bindings"`
+	Policies    []PolicyConfig         `yaml:"policies"`
+	Roles       RolesConfig            `yaml:"roles"`
+	RetryDelays []string               `yaml:"retryDelays"`
+	Events      map[string]EventRoutes `yaml:"events"`
+
+	// parsed from RetryDelays by Validate
+	Delays []time.Duration `yaml:"-"`
+}
+
+// TOPOLOGY is the topology in use, the built-in one until InitEnv loads
+// MQ_TOPOLOGY_FILE
+var TOPOLOGY = mustParseTopology(defaultTopology)
+
+// LoadTopology reads and validates a YAML (or JSON) topology file
+func LoadTopology(path string) (*TopologyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTopology(data)
+}
+
+func ParseTopology(data []byte) (*TopologyConfig, error) {
+	var topology TopologyConfig
+	err := yaml.Unmarshal(data, &topology)
+	if err != nil {
+		return nil, err
+	}
+
+	err = topology.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &topology, nil
+}
+
+func mustParseTopology(data []byte) *TopologyConfig {
+	topology, err := ParseTopology(data)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid built-in topology: %s", err))
+	}
+	return topology
+}
+
+// Validate checks that every reference in the topology resolves
+func (t *TopologyConfig) Validate() error {
+	var errs []string
+
+	exchanges := map[string]bool{"": true}
+	for _, ex := range t.Exchanges {
+		if ex.Name == "" {
+			errs = append(errs, "exchange without a name")
+		}
+		switch ex.Type {
+		case "direct", "fanout", "topic", "headers":
+		default:
+			errs = append(errs, fmt.Sprintf("exchange %q has invalid type %q", ex.Name, ex.Type))
+		}
+		if exchanges[ex.Name] && ex.Name != "" {
+			errs = append(errs, fmt.Sprintf("exchange %q declared twice", ex.Name))
+		}
+		exchanges[ex.Name] = true
+	}
+
+	queues := map[string]bool{}
+	for _, q := range t.Queues {
+		if q.Name == "" {
+			errs = append(errs, "queue without a name")
+		}
+		if queues[q.Name] {
+			errs = append(errs, fmt.Sprintf("queue %q declared twice", q.Name))
+		}
+		queues[q.Name] = true
+
+		if dlx, ok := q.Arguments["x-dead-letter-exchange"].(string); ok && !exchanges[dlx] {
+			errs = append(errs, fmt.Sprintf("queue %q dead-letters to undeclared exchange %q", q.Name, dlx))
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if !queues[b.Queue] {
+			errs = append(errs, fmt.Sprintf("binding to undeclared queue %q", b.Queue))
+		}
+		if b.Exchange == "" || !exchanges[b.Exchange] {
+			errs = append(errs, fmt.Sprintf("binding to undeclared exchange %q", b.Exchange))
+		}
+	}
+
+	policies := map[string]bool{}
+	for _, p := range t.Policies {
+		if p.Name == "" {
+			errs = append(errs, "policy without a name")
+		}
+		if policies[p.Name] {
+			errs = append(errs, fmt.Sprintf("policy %q declared twice", p.Name))
+		}
+		policies[p.Name] = true
+
+		if _, err := regexp.Compile(p.Pattern); err != nil || p.Pattern == "" {
+			errs = append(errs, fmt.Sprintf("policy %q has invalid pattern %q", p.Name, p.Pattern))
+		}
+		switch p.ApplyTo {
+		case "", "queues", "exchanges", "all":
+		default:
+			errs = append(errs, fmt.Sprintf("policy %q applies to invalid %q", p.Name, p.ApplyTo))
+		}
+		if len(p.Definition) == 0 {
+			errs = append(errs, fmt.Sprintf("policy %q has an empty definition", p.Name))
+		}
+		if dlx, ok := p.Definition["dead-letter-exchange"].(string); ok && !exchanges[dlx] {
+			errs = append(errs, fmt.Sprintf("policy %q dead-letters to undeclared exchange %q", p.Name, dlx))
+		}
+	}
+
+	for _, role := range [][2]string{
+		{"router", t.Roles.Router},
+		{"deadLetter", t.Roles.DeadLetter},
+		{"retry", t.Roles.Retry},
+	} {
+		if role[1] == "" || !exchanges[role[1]] {
+			errs = append(errs, fmt.Sprintf("role %s refers to undeclared exchange %q", role[0], role[1]))
+		}
+	}
+	for _, role := range [][2]string{
+		{"replyQueue", t.Roles.ReplyQueue},
+		{"parkingLotQueue", t.Roles.ParkingLotQueue},
+	} {
+		if !queues[role[1]] {
+			errs = append(errs, fmt.Sprintf("role %s refers to undeclared queue %q", role[0], role[1]))
+		}
+	}
+
+	t.Delays = nil
+	for _, raw := range t.RetryDelays {
+		delay, err := time.ParseDuration(raw)
+		if err != nil || delay <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid retry delay %q", raw))
+			continue
+		}
+		t.Delays = append(t.Delays, delay)
+	}
+
+	for _, event := range requiredEvents {
+		routes, ok := t.Events[event]
+		if !ok || routes.Request == "" || routes.Reply == "" {
+			errs = append(errs, fmt.Sprintf("event %s needs a request and a reply routing key", event))
+			continue
+		}
+		if !t.routes(t.Roles.Router, routes.Request) {
+			errs = append(errs, fmt.Sprintf("request routing key %q of %s is not bound to any queue", routes.Request, event))
+		}
+		if !t.routesTo(t.Roles.Router, routes.Reply, t.Roles.ReplyQueue) {
+			errs = append(errs, fmt.Sprintf("reply routing key %q of %s is not bound to %s", routes.Reply, event, t.Roles.ReplyQueue))
+		}
+	}
+
+	for _, event := range notificationEvents {
+		routes, ok := t.Events[event]
+		if !ok || routes.Request == "" {
+			errs = append(errs, fmt.Sprintf("event %s needs a request routing key", event))
+			continue
+		}
+		if !t.routes(t.Roles.Router, routes.Request) {
+			errs = append(errs, fmt.Sprintf("request routing key %q of %s is not bound to any queue", routes.Request, event))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid MQ topology: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// routes reports whether key published to exchange reaches any queue
+func (t *TopologyConfig) routes(exchange string, key string) bool {
+	for _, b := range t.Bindings {
+		if b.Exchange == exchange && TopicMatches(b.RoutingKey, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// routesTo reports whether key published to exchange reaches queue
+func (t *TopologyConfig) routesTo(exchange string, key string, queue string) bool {
+	for _, b := range t.Bindings {
+		if b.Exchange == exchange && b.Queue == queue && TopicMatches(b.RoutingKey, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// TopicMatches reports whether key matches an AMQP topic binding pattern,
+// where `*` matches one word and `#` zero or more
+func TopicMatches(pattern string, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern []string, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && pattern[0] == key[0] && matchWords(pattern[1:], key[1:])
+	}
+}
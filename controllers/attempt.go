@@ -16,10 +16,16 @@ import (
 
 type AttemptController struct{
 	AttemptCollection collections.AttemptCollection
+	OutboxCollection  collections.OutboxCollection
+	Publisher         mq.Publisher
 }
 
-func NewAttemptController(client *mongo.Client) *AttemptController {
-	return &AttemptController{AttemptCollection: *collections.NewAttemptCollection(client)}
+func NewAttemptController(client *mongo.Client, publisher mq.Publisher) *AttemptController {
+	return &AttemptController{
+		AttemptCollection: *collections.NewAttemptCollection(client),
+		OutboxCollection:  *collections.NewOutboxCollection(client),
+		Publisher:         publisher,
+	}
 }
 
 // var attemptCollection *mongo.Collection = configs.OpenCollection(configs.Client, "attempt")
@@ -77,8 +83,10 @@ func (t AttemptController) GetOneAttemptByToken(c *gin.Context) {
 //	@Produce		json
 //	@Param			AttemptBody	body		AttemptBody				true	"Start Attempt Request Body"
 //	@Success		200			{object}	models.SuccessResponse	"Successfully started the attempt with corId"
+//	@Success		202			{object}	models.SuccessResponse	"Accepted, message will be published once the MQ is reachable"
 //	@Failure		400			"Bad request when the body is not as per AttemptBody structure"
 //	@Failure		500			"Internal server error"
+//	@Failure		503			"Message was nacked or could not be routed"
 //	@Router			/platform/attempt [post]
 func (t AttemptController) StartAttempt(c *gin.Context) {
 
@@ -145,9 +153,10 @@ func (t AttemptController) StartAttempt(c *gin.Context) {
 	}
 
 	// set eventStatus
-	req.EventStatus = "challengeStarting"
+	req.EventStatus = models.EventStatusChallengeStarting
 
-	jsonReq, err := json.Marshal(req)
+	// wrap in envelope
+	env, err := mq.NewEnvelope(mq.EVENT_CHALLENGE_START, req.CorId, req)
 	if err != nil {
 		handleError(
 			c,
@@ -158,20 +167,20 @@ func (t AttemptController) StartAttempt(c *gin.Context) {
 		return
 	}
 
-	// publish to mq
-	err = mq.Pub(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_START, jsonReq)
+	// record in outbox and publish to mq
+	statusCode, err = enqueue(t.Publisher, t.OutboxCollection, mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_START, env)
 	if err != nil {
 		handleError(
 			c,
-			http.StatusInternalServerError,
-			"Failed to publish message",
+			statusCode,
+			"Failed to queue message",
 			err,
 		)
 		return
 	}
 
 	c.JSON(
-		http.StatusOK,
+		statusCode,
 		gin.H{"corId": req.CorId},
 	)
 }
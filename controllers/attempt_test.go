@@ -5,11 +5,13 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"platform_api/configs"
 	"platform_api/models"
+	"platform_api/mq"
 	"testing"
 	"time"
 
@@ -17,7 +19,7 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-var attemptController = NewAttemptController(configs.Client)
+var attemptController = NewAttemptController(configs.Client, testPublisher)
 
 func seed_attempts() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -167,4 +169,36 @@ func TestSubmitAttemptByToken_BadRequest(t *testing.T) {
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
-}
\ No newline at end of file
+}
+
+func TestStartAttempt(t *testing.T) {
+	seed_attempts()
+	testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/attempt", attemptController.StartAttempt)
+
+	bodyContent := []byte(`{"token": "t2"}`)
+
+	req, _ := http.NewRequest("POST", "/attempt", bytes.NewBuffer(bodyContent))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Check the message that was published
+	messages := testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_START)
+	assert.Len(t, messages, 1)
+
+	assert.Equal(t, mq.EVENT_CHALLENGE_START, messages[0].Type)
+
+	var published models.AttemptBody
+	json.Unmarshal(mq.UnwrapPayload(messages[0].Body), &published)
+	assert.Equal(t, "t2", published.Token)
+	assert.Equal(t, "Bop", published.Participant)
+	assert.Equal(t, "challengeStarting", published.EventStatus)
+}
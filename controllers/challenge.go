@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"platform_api/collections"
+	"platform_api/models"
 	"platform_api/mq"
 
 	"github.com/gin-gonic/gin"
@@ -16,12 +17,16 @@ import (
 type ChallengeController struct {
 	ChallengeCollection collections.ChallengeCollection
 	ImageCollection     collections.ImageCollection
+	OutboxCollection    collections.OutboxCollection
+	Publisher           mq.Publisher
 }
 
-func NewChallengeController(client *mongo.Client) *ChallengeController {
+func NewChallengeController(client *mongo.Client, publisher mq.Publisher) *ChallengeController {
 	return &ChallengeController{
 		ChallengeCollection: *collections.NewChallengeCollection(client),
 		ImageCollection:     *collections.NewImageCollection(client),
+		OutboxCollection:    *collections.NewOutboxCollection(client),
+		Publisher:           publisher,
 	}
 }
 
@@ -120,11 +125,13 @@ type CreateChallengeMessage struct {
 // @Produce		json
 // @Param			challenge	body		CreateChallengeMessage	true	"Create Challenge Content"
 // @Success		200			{object}	models.SuccessResponse
+// @Success		202			{object}	models.SuccessResponse	"Accepted, message will be published once the MQ is reachable"
 // @Failure		400			{object}	models.HTTPError	"Invalid request body"
 // @Failure		400			{object}	models.HTTPError	"Challenge name already exists"
 // @Failure		404			{object}	models.HTTPError	"No such image"
-// @Failure		500			{object}	models.HTTPError	"Failed to marshal JSON or Failed to publish message"
+// @Failure		500			{object}	models.HTTPError	"Failed to marshal JSON or Failed to queue message"
 // @Failure		500			{object}	models.HTTPError	"Error occured while retrieving image"
+// @Failure		503			{object}	models.HTTPError	"Message was nacked or could not be routed"
 // @Router			/challenge [post]
 func (t ChallengeController) CreateChallenge(c *gin.Context) {
 
@@ -156,8 +163,8 @@ func (t ChallengeController) CreateChallenge(c *gin.Context) {
 		return
 	}
 
-	// check if image exists
-	statusCode, err := t.ImageCollection.CheckImageExists(req.ImageName, req.ImageTag, req.CreatorName)
+	// check if image exists, challenges keep the tag an alias points at now
+	imageTag, statusCode, err := t.ImageCollection.CheckImageExists(req.ImageName, req.ImageTag, req.CreatorName)
 	if err != nil {
 		handleError(
 			c,
@@ -167,6 +174,7 @@ func (t ChallengeController) CreateChallenge(c *gin.Context) {
 		)
 		return
 	}
+	req.ImageTag = imageTag
 
 	// check if the challenge name already exists
 	statusCode, err = t.ChallengeCollection.CheckChallengeByChallengeAndCreatorName(req.CreatorName, req.ChallengeName)
@@ -185,10 +193,10 @@ func (t ChallengeController) CreateChallenge(c *gin.Context) {
 	req.CorID = corId
 
 	// set eventStatus
-	req.EventStatus = "challengeCreating"
+	req.EventStatus = models.EventStatusChallengeCreating
 
-	// marshall data
-	jsonReq, err := json.Marshal(req)
+	// wrap in envelope
+	env, err := mq.NewEnvelope(mq.EVENT_CHALLENGE_CREATE, corId, req)
 	if err != nil {
 		handleError(
 			c,
@@ -199,13 +207,13 @@ func (t ChallengeController) CreateChallenge(c *gin.Context) {
 		return
 	}
 
-	// publish to mq
-	err = mq.Pub(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_CREATE, jsonReq)
+	// record in outbox and publish to mq
+	statusCode, err = enqueue(t.Publisher, t.OutboxCollection, mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_CREATE, env)
 	if err != nil {
 		handleError(
 			c,
-			http.StatusInternalServerError,
-			"Failed to publish message",
+			statusCode,
+			"Failed to queue message",
 			err,
 		)
 		return
@@ -213,5 +221,5 @@ func (t ChallengeController) CreateChallenge(c *gin.Context) {
 
 	// response
 	resp := map[string]interface{}{"corId": corId}
-	c.JSON(http.StatusOK, resp)
+	c.JSON(statusCode, resp)
 }
@@ -3,7 +3,9 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +13,7 @@ import (
 
 	"platform_api/configs"
 	"platform_api/models"
+	"platform_api/mq"
 
 	"github.com/gin-gonic/gin"
 
@@ -22,7 +25,9 @@ import (
 
 // Seed test data
 
-var challengeController = NewChallengeController(configs.Client)
+var testPublisher = mq.NewMemoryPublisher()
+
+var challengeController = NewChallengeController(configs.Client, testPublisher)
 
 func seed_challenges() {
 	// Create a context with a timeout
@@ -142,3 +147,87 @@ func TestGetChallengeByCreatorName(t *testing.T) {
 	expectedResponse := `[{"corId":"2b","challengeName":"ChallengeTwo","creatorName":"Alice","imageName":"image2","imageTag":"v1.1-Alice","imageRegistryLink":"registry.com/alice","duration":60,"participants":["ben@smu.com.sg"]}]`
 	assert.Equal(t, expectedResponse, w.Body.String())
 }
+
+func TestCreateChallenge(t *testing.T) {
+	seed_images()
+	testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/challenge", challengeController.CreateChallenge)
+
+	bodyContent := []byte(`{"imageName": "image2", "imageTag": "v1.1-Alice", "challengeName": "ChallengeThree", "creatorName": "Alice", "duration": 30, "participants": ["ben@smu.com.sg"]}`)
+
+	req, _ := http.NewRequest("POST", "/challenge", bytes.NewBuffer(bodyContent))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp models.SuccessResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	// Check the message that was published
+	messages := testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_CREATE)
+	assert.Len(t, messages, 1)
+
+	assert.Equal(t, resp.CorId, messages[0].CorrelationId)
+	assert.Equal(t, mq.EVENT_CHALLENGE_CREATE, messages[0].Type)
+
+	var published CreateChallengeMessage
+	json.Unmarshal(mq.UnwrapPayload(messages[0].Body), &published)
+	assert.Equal(t, resp.CorId, published.CorID)
+	assert.Equal(t, "ChallengeThree", published.ChallengeName)
+	assert.Equal(t, "challengeCreating", published.EventStatus)
+}
+
+func TestCreateChallenge_PublishFailed(t *testing.T) {
+	seed_images()
+	testPublisher.Reset()
+	testPublisher.FailRoute(mq.ROUTE_CHALLENGE_CREATE, mq.ErrConfirmTimeout)
+	defer testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/challenge", challengeController.CreateChallenge)
+
+	bodyContent := []byte(`{"imageName": "image2", "imageTag": "v1.1-Alice", "challengeName": "ChallengeFour", "creatorName": "Alice", "duration": 30, "participants": ["ben@smu.com.sg"]}`)
+
+	req, _ := http.NewRequest("POST", "/challenge", bytes.NewBuffer(bodyContent))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	// Accepted and left in the outbox for the relay
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, testPublisher.All())
+}
+
+func TestCreateChallenge_PublishRefused(t *testing.T) {
+	seed_images()
+	testPublisher.Reset()
+	testPublisher.FailRoute(mq.ROUTE_CHALLENGE_CREATE, mq.ErrUnroutable)
+	defer testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/challenge", challengeController.CreateChallenge)
+
+	bodyContent := []byte(`{"imageName": "image2", "imageTag": "v1.1-Alice", "challengeName": "ChallengeFive", "creatorName": "Alice", "duration": 30, "participants": ["ben@smu.com.sg"]}`)
+
+	req, _ := http.NewRequest("POST", "/challenge", bytes.NewBuffer(bodyContent))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	// the broker would refuse it again, it is not left for the relay
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, testPublisher.All())
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/mq"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type DeadLetterController struct {
+	DeadLetterCollection collections.DeadLetterCollection
+	Publisher            mq.Publisher
+}
+
+func NewDeadLetterController(client *mongo.Client, publisher mq.Publisher) *DeadLetterController {
+	return &DeadLetterController{
+		DeadLetterCollection: *collections.NewDeadLetterCollection(client),
+		Publisher:            publisher,
+	}
+}
+
+// GetAllDeadLetters godoc
+//
+//	@Summary		Retrieve dead-lettered messages
+//	@Description	Get all messages that were rejected or ran out of retries, newest first
+//	@Tags			admin
+//	@Produce		json
+//	@Param			status	query		string	false	"Filter by status (parked or redriven)"
+//	@Success		200		{array}		models.DeadLetter
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/admin/deadletter [get]
+func (t DeadLetterController) GetAllDeadLetters(c *gin.Context) {
+	deadLetters, statusCode, err := t.DeadLetterCollection.GetAllDeadLetters(c.Query("status"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve dead letters",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *deadLetters)
+}
+
+// GetDeadLetterById godoc
+//
+//	@Summary		Inspect a dead-lettered message
+//	@Description	Get a single dead-lettered message including its body and origin
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		string	true	"Dead letter ID"
+//	@Success		200	{object}	models.DeadLetter
+//	@Failure		400	{object}	models.HTTPError
+//	@Failure		404	{object}	models.HTTPError
+//	@Router			/admin/deadletter/{id} [get]
+func (t DeadLetterController) GetDeadLetterById(c *gin.Context) {
+	dl, statusCode, err := t.DeadLetterCollection.GetDeadLetterById(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve dead letter",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *dl)
+}
+
+// RedriveDeadLetter godoc
+//
+//	@Summary		Re-drive a dead-lettered message
+//	@Description	Publish a dead-lettered message back to topic.router with its original routing key
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		string	true	"Dead letter ID"
+//	@Success		200	{object}	models.DeadLetter
+//	@Success		202	{object}	models.DeadLetter	"Spooled, the message will be published once the MQ is reachable"
+//	@Failure		400	{object}	models.HTTPError
+//	@Failure		404	{object}	models.HTTPError
+//	@Failure		409	{object}	models.HTTPError
+//	@Failure		500	{object}	models.HTTPError
+//	@Failure		503	{object}	models.HTTPError	"Message was nacked or could not be routed"
+//	@Failure		504	{object}	models.HTTPError	"Timed out waiting for publish confirmation"
+//	@Router			/admin/deadletter/{id}/redrive [post]
+func (t DeadLetterController) RedriveDeadLetter(c *gin.Context) {
+	// claimed before publishing, a concurrent re-drive gets 409
+	dl, statusCode, err := t.DeadLetterCollection.ClaimDeadLetter(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to re-drive dead letter",
+			err,
+		)
+		return
+	}
+
+	err = mq.Redrive(t.Publisher, dl)
+	if errors.Is(err, mq.ErrSpooled) {
+		c.JSON(http.StatusAccepted, *dl)
+		return
+	}
+	if err != nil {
+		_, releaseErr := t.DeadLetterCollection.ReleaseDeadLetter(dl.Id)
+		if releaseErr != nil {
+			log.Printf("Failed to park dead letter %s again: %s", dl.Id.Hex(), releaseErr)
+		}
+
+		handleError(
+			c,
+			publishErrorStatus(err),
+			"Failed to re-drive dead letter",
+			err,
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, *dl)
+}
@@ -0,0 +1,150 @@
+// +build integration
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"platform_api/configs"
+	"platform_api/models"
+	"platform_api/mq"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var deadLetterController = NewDeadLetterController(configs.Client, testPublisher)
+
+var deadLetterId = primitive.NewObjectID()
+
+func seed_dead_letters() {
+	seed_dead_letter(deadLetterId)
+}
+
+func seed_dead_letter(id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	deadLetter := models.DeadLetter{
+		Id:             id,
+		MessageId:      "m1",
+		CorId:          "dl1",
+		Type:           mq.EVENT_CHALLENGE_CREATE,
+		Exchange:       mq.EXCHANGE_TOPIC_ROUTER,
+		RoutingKey:     mq.ROUTE_CHALLENGE_CREATE,
+		Queue:          "queue.platform.fromService",
+		Reason:         "rejected",
+		Count:          1,
+		Body:           `{"corId":"dl1"}`,
+		Status:         models.DeadLetterStatusParked,
+		DeadLetteredAt: time.Now().UTC(),
+	}
+
+	_, err := configs.OpenCollection(configs.Client, "dead_letter").InsertOne(ctx, deadLetter)
+	if err != nil {
+		fmt.Printf("Error inserting document: %v\n", err)
+		return
+	}
+}
+
+func TestRedriveDeadLetter(t *testing.T) {
+	seed_dead_letters()
+	testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/deadletter/:id/redrive", deadLetterController.RedriveDeadLetter)
+
+	req, _ := http.NewRequest("POST", "/deadletter/"+deadLetterId.Hex()+"/redrive", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	messages := testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_CREATE)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "dl1", messages[0].CorrelationId)
+	assert.Equal(t, `{"corId":"dl1"}`, string(messages[0].Body))
+
+	// A second re-drive is refused
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestRedriveDeadLetter_PublishFailed(t *testing.T) {
+	id := primitive.NewObjectID()
+	seed_dead_letter(id)
+	testPublisher.Reset()
+	testPublisher.FailRoute(mq.ROUTE_CHALLENGE_CREATE, mq.ErrUnroutable)
+	defer testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/deadletter/:id/redrive", deadLetterController.RedriveDeadLetter)
+	r.GET("/deadletter/:id", deadLetterController.GetDeadLetterById)
+
+	req, _ := http.NewRequest("POST", "/deadletter/"+id.Hex()+"/redrive", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// parked again so it can be re-driven later
+	get, _ := http.NewRequest("GET", "/deadletter/"+id.Hex(), nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, get)
+
+	assert.Contains(t, w.Body.String(), `"status":"parked"`)
+
+	testPublisher.FailRoute(mq.ROUTE_CHALLENGE_CREATE, nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"redriven"`)
+	assert.Len(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_CHALLENGE_CREATE), 1)
+}
+
+func TestRedriveDeadLetter_NotFound(t *testing.T) {
+	r := gin.Default()
+
+	r.POST("/deadletter/:id/redrive", deadLetterController.RedriveDeadLetter)
+
+	req, _ := http.NewRequest("POST", "/deadletter/"+primitive.NewObjectID().Hex()+"/redrive", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetDeadLetterById_BadRequest(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/deadletter/:id", deadLetterController.GetDeadLetterById)
+
+	req, _ := http.NewRequest("GET", "/deadletter/xyz", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
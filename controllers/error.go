@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"log"
+	"net/http"
 	"platform_api/models"
+	"platform_api/mq"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,3 +18,16 @@ func handleError(c *gin.Context, statusCode int, msg string, err error) {
 		Error:   err.Error(),
 	})
 }
+
+// publishErrorStatus maps an error returned by a synchronous publish to an
+// HTTP status code
+func publishErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, mq.ErrUnroutable), errors.Is(err, mq.ErrNacked):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, mq.ErrConfirmTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
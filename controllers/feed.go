@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"platform_api/configs"
+	"platform_api/mq"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	FEED_ACTION_SUBSCRIBE   = "subscribe"
+	FEED_ACTION_UNSUBSCRIBE = "unsubscribe"
+
+	FEED_MESSAGE_PROCESS    = "process"
+	FEED_MESSAGE_SUBSCRIBED = "subscribed"
+	FEED_MESSAGE_HEARTBEAT  = "heartbeat"
+	FEED_MESSAGE_ERROR      = "error"
+)
+
+// event types a feed can be subscribed to
+var feedEvents = []string{
+	mq.EVENT_IMAGE_CREATE,
+	mq.EVENT_CHALLENGE_CREATE,
+	mq.EVENT_CHALLENGE_START,
+}
+
+// FeedCommand is sent by feed clients to change their subscriptions
+type FeedCommand struct {
+	Action string   `json:"action"`
+	Events []string `json:"events"`
+}
+
+// FeedMessage is sent to feed clients
+type FeedMessage struct {
+	Type   string      `json:"type"`
+	Data   interface{} `json:"data,omitempty"`
+	Events []string    `json:"events,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Time   time.Time   `json:"time"`
+}
+
+// FeedProcessesByCreatorName godoc
+//
+//	@Summary		Feeds the process activity of a creator
+//	@Description	WebSocket feed of every new process record of the creator. All event types are subscribed unless the events query lists some. Clients change subscriptions by sending {"action": "subscribe"|"unsubscribe", "events": [...]}, and receive "process", "subscribed", "heartbeat" and "error" messages.
+//	@Tags			processes
+//	@Param			creatorName	path	string	true	"Creator's Name"
+//	@Param			events		query	string	false	"Comma separated event types to subscribe to"	example(imageCreate,challengeCreate)
+//	@Success		101	"Switching Protocols"
+//	@Failure		400	{object}	models.HTTPError
+//	@Failure		403	"Origin not allowed"
+//	@Router			/process/name/{creatorName}/feed [get]
+func (t ProcessController) FeedProcessesByCreatorName(c *gin.Context) {
+	creatorName := c.Param("creatorName")
+
+	subscribed := map[string]bool{}
+	if events := c.Query("events"); events != "" {
+		if err := subscribe(subscribed, strings.Split(events, ",")); err != nil {
+			handleError(
+				c,
+				http.StatusBadRequest,
+				"Invalid events",
+				err,
+			)
+			return
+		}
+	} else {
+		subscribe(subscribed, feedEvents)
+	}
+
+	server := websocket.Server{
+		Handshake: checkFeedOrigin,
+		Handler: func(ws *websocket.Conn) {
+			t.feed(ws, creatorName, subscribed)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkFeedOrigin stops pages on other sites from opening a feed with the
+// cookies of their visitors. Browsers always send an Origin, clients that
+// send none are not browsers and are let through.
+func checkFeedOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, req.Host) {
+		return nil
+	}
+	for _, allowed := range strings.Split(configs.FEED_ALLOWED_ORIGINS, ",") {
+		allowed = strings.TrimSuffix(strings.TrimSpace(allowed), "/")
+		if allowed != "" && strings.EqualFold(allowed, origin) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// feed pushes process records to a connected client until it goes away
+func (t ProcessController) feed(ws *websocket.Conn, creatorName string, subscribed map[string]bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// only records inserted from now on, the rest is in GetProcessByCreatorName
+	after := primitive.NewObjectIDFromTimestamp(time.Now())
+	events := t.ProcessCollection.Follow(ctx, bson.M{"creatorName": creatorName}, after, streamPollInterval())
+
+	// the connection is only read here, closing it ends the feed
+	commands := make(chan FeedCommand)
+	go func() {
+		defer cancel()
+		for {
+			var command FeedCommand
+			if err := websocket.JSON.Receive(ws, &command); err != nil {
+				if _, ok := err.(*json.SyntaxError); !ok {
+					return
+				}
+				// answered with an error by applyFeedCommand
+				command = FeedCommand{}
+			}
+			select {
+			case commands <- command:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	send := func(message FeedMessage) bool {
+		message.Time = time.Now()
+		ws.SetWriteDeadline(time.Now().Add(streamHeartbeat))
+		return websocket.JSON.Send(ws, message) == nil
+	}
+
+	if !send(FeedMessage{Type: FEED_MESSAGE_SUBSCRIBED, Events: subscriptions(subscribed)}) {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var message FeedMessage
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			message = FeedMessage{Type: FEED_MESSAGE_HEARTBEAT}
+		case command := <-commands:
+			message = applyFeedCommand(subscribed, command)
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Event == nil || !subscribed[*event.Event] {
+				continue
+			}
+			message = FeedMessage{Type: FEED_MESSAGE_PROCESS, Data: event}
+		}
+
+		if !send(message) {
+			return
+		}
+	}
+}
+
+// applyFeedCommand changes the subscriptions and returns the reply to send
+func applyFeedCommand(subscribed map[string]bool, command FeedCommand) FeedMessage {
+	var err error
+	switch command.Action {
+	case FEED_ACTION_SUBSCRIBE:
+		err = subscribe(subscribed, command.Events)
+	case FEED_ACTION_UNSUBSCRIBE:
+		err = unsubscribe(subscribed, command.Events)
+	default:
+		err = fmt.Errorf("unknown action %q", command.Action)
+	}
+
+	if err != nil {
+		return FeedMessage{Type: FEED_MESSAGE_ERROR, Error: err.Error()}
+	}
+	return FeedMessage{Type: FEED_MESSAGE_SUBSCRIBED, Events: subscriptions(subscribed)}
+}
+
+func subscribe(subscribed map[string]bool, events []string) error {
+	if err := validateFeedEvents(events); err != nil {
+		return err
+	}
+	for _, event := range events {
+		subscribed[strings.TrimSpace(event)] = true
+	}
+	return nil
+}
+
+func unsubscribe(subscribed map[string]bool, events []string) error {
+	if err := validateFeedEvents(events); err != nil {
+		return err
+	}
+	for _, event := range events {
+		delete(subscribed, strings.TrimSpace(event))
+	}
+	return nil
+}
+
+func validateFeedEvents(events []string) error {
+	if len(events) == 0 {
+		return fmt.Errorf("no events given")
+	}
+	for _, event := range events {
+		if !isFeedEvent(strings.TrimSpace(event)) {
+			return fmt.Errorf("unknown event %q, expected one of %s", event, strings.Join(feedEvents, ", "))
+		}
+	}
+	return nil
+}
+
+func isFeedEvent(event string) bool {
+	for _, e := range feedEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptions lists the subscribed events in a stable order
+func subscriptions(subscribed map[string]bool) []string {
+	events := []string{}
+	for _, event := range feedEvents {
+		if subscribed[event] {
+			events = append(events, event)
+		}
+	}
+	return events
+}
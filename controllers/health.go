@@ -6,8 +6,37 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-type HealthController struct{}
+type HealthController struct {
+	// MQ explains why RabbitMQ is unusable, nil when no broker is used
+	MQ func() error
+}
+
+func NewHealthController(mq func() error) *HealthController {
+	return &HealthController{MQ: mq}
+}
 
+// HealthCheck godoc
+//
+//	@Summary		Check the health of the API
+//	@Description	Report whether the API can reach RabbitMQ and its topology and consumers were set up on the current connection
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{string}	string	"Ok"
+//	@Failure		503	{object}	models.HTTPError
+//	@Router			/health [get]
 func (t HealthController) HealthCheck(c *gin.Context) {
+	if t.MQ != nil {
+		err := t.MQ()
+		if err != nil {
+			handleError(
+				c,
+				http.StatusServiceUnavailable,
+				"MQ is unhealthy",
+				err,
+			)
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, "Ok")
 }
@@ -1,14 +1,16 @@
 package controllers
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"platform_api/collections"
+	"platform_api/configs"
+	"platform_api/models"
 	"platform_api/mq"
 	"platform_api/services"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
@@ -17,11 +19,29 @@ import (
 )
 
 type ImageController struct {
-	ImageService collections.ImageCollection
+	ImageService        collections.ImageCollection
+	ChallengeCollection collections.ChallengeCollection
+	ProcessCollection   collections.ProcessCollection
+	OutboxCollection    collections.OutboxCollection
+	Publisher           mq.Publisher
 }
 
-func NewImageController(client *mongo.Client) *ImageController {
-	return &ImageController{ImageService: *collections.NewImageCollection(client)}
+func NewImageController(client *mongo.Client, publisher mq.Publisher) *ImageController {
+	return &ImageController{
+		ImageService:        *collections.NewImageCollection(client),
+		ChallengeCollection: *collections.NewChallengeCollection(client),
+		ProcessCollection:   *collections.NewProcessCollection(client),
+		OutboxCollection:    *collections.NewOutboxCollection(client),
+		Publisher:           publisher,
+	}
+}
+
+// room for the other form fields and the multipart framing around an archive
+const uploadFormOverhead = 1 << 20
+
+// imageObjectPath is where the zip of an image is kept in the object store
+func imageObjectPath(creatorName string, corId string) string {
+	return fmt.Sprintf("%s/%s-%s.zip", "challenge-zips", creatorName, corId)
 }
 
 // var imageCollection *mongo.Collection = configs.OpenCollection(configs.Client, "image_builder")
@@ -130,10 +150,41 @@ type UploadImageMessage struct {
 //	@Param			imageTag	formData	string					true	"Tag of the Image"
 //	@Param			imageFile	formData	file					true	"The image file to upload"
 //	@Success		200			{object}	map[string]interface{}	"A map containing the correlation ID"
-//	@Failure		400			{object}	models.HTTPError
+//	@Success		202			{object}	map[string]interface{}	"Accepted, message will be published once the MQ is reachable"
+//	@Failure		400			{object}	models.ArchiveValidationError	"Invalid request, or an archive that is not a zip, is too large, has too many files, has entries outside its root or has no Dockerfile at its root"
+//	@Failure		413			{object}	models.ArchiveValidationError	"Request is larger than the maximum archive size"
 //	@Failure		500			{object}	models.HTTPError
+//	@Failure		503			{object}	models.HTTPError				"Message was nacked or could not be routed"
 //	@Router			/image/upload [post]
 func (t ImageController) UploadImage(c *gin.Context) {
+	// bound the body before the form is parsed, the archive would otherwise
+	// be spooled to disk whatever its size
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, configs.ARCHIVE_LIMITS.MaxSize+uploadFormOverhead)
+	_, err := c.MultipartForm()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, models.ArchiveValidationError{
+				HTTPError: models.HTTPError{
+					Code:    http.StatusRequestEntityTooLarge,
+					Message: "Invalid archive",
+					Error:   fmt.Sprintf("request is larger than %d bytes", tooLarge.Limit),
+				},
+				Violations: []models.ArchiveViolation{{
+					Rule:    models.ArchiveRuleSize,
+					Message: fmt.Sprintf("archive is larger than %d bytes", configs.ARCHIVE_LIMITS.MaxSize),
+				}},
+			})
+			return
+		}
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Error",
+			err,
+		)
+		return
+	}
 
 	// create uploadImage message
 	var req UploadImageMessage
@@ -154,7 +205,7 @@ func (t ImageController) UploadImage(c *gin.Context) {
 	req.ImageTag = c.PostForm("imageTag")
 	req.CreatorName = c.PostForm("creatorName")
 
-	statusCode, err := t.ImageService.CheckImageByImageAndCreatorName(req.ImageName, req.CreatorName)
+	statusCode, err := t.ImageService.CheckImageByImageAndCreatorName(req.ImageName, req.ImageTag, req.CreatorName)
 	if err != nil {
 		handleError(
 			c,
@@ -191,13 +242,29 @@ func (t ImageController) UploadImage(c *gin.Context) {
 		)
 		return
 	}
+	defer file.Close()
+
+	// check the archive before anything is uploaded or published
+	violations := services.ValidateArchive(file, formFile.Size, configs.ARCHIVE_LIMITS)
+	if len(violations) > 0 {
+		log.Printf("Rejected archive %s of %s with %d violations", fileName, creatorName, len(violations))
+		c.JSON(http.StatusBadRequest, models.ArchiveValidationError{
+			HTTPError: models.HTTPError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid archive",
+				Error:   violations[0].Message,
+			},
+			Violations: violations,
+		})
+		return
+	}
 
 	// generate correlationId
 	corId := uuid.New().String()
 	log.Printf("Received values: %s, %s, %s and generated %s", imageName, creatorName, fileName, corId)
 
 	// create image message
-	req.S3Path = fmt.Sprintf("%s/%s-%s.zip", "challenge-zips", creatorName, corId)
+	req.S3Path = imageObjectPath(creatorName, corId)
 
 	// upload file to s3 compatible object store
 	uploader := services.GetUploader()
@@ -212,62 +279,434 @@ func (t ImageController) UploadImage(c *gin.Context) {
 		return
 	}
 
-	// set eventStatus
-	req.EventStatus = "imageCreating"
-
 	// set corId
 	req.CorID = corId
 
 	log.Printf("Uploaded file to %s", req)
 
-	// validate json before passing to mq
-	valid := validator.New()
-	err = valid.Struct(req)
+	statusCode, err = startImageCreate(t.Publisher, t.OutboxCollection, req)
 	if err != nil {
 		handleError(
 			c,
-			http.StatusBadRequest,
-			"Error",
+			statusCode,
+			"Failed to queue message",
 			err,
 		)
 		return
 	}
 
-	// marshall data
-	jsonReq, err := json.Marshal(req)
+	// response
+	resp := map[string]interface{}{"corId": corId}
 	if err != nil {
 		handleError(
 			c,
 			http.StatusBadRequest,
 			"Error",
-			errors.New("failed to unmarshal data"),
+			errors.New("failed to generate corId"),
+		)
+		return
+	}
+
+	c.JSON(statusCode, resp)
+}
+
+// startImageCreate asks the downstream services to build the image of an
+// uploaded archive
+func startImageCreate(publisher mq.Publisher, outbox collections.OutboxCollection, req UploadImageMessage) (int, error) {
+	// set eventStatus
+	req.EventStatus = models.EventStatusImageCreating
+
+	// validate json before passing to mq
+	valid := validator.New()
+	err := valid.Struct(req)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// wrap in envelope
+	env, err := mq.NewEnvelope(mq.EVENT_IMAGE_CREATE, req.CorID, req)
+	if err != nil {
+		return http.StatusBadRequest, errors.New("failed to unmarshal data")
+	}
+
+	// record in outbox and publish to mq
+	return enqueue(publisher, outbox, mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD, env)
+}
+
+// DeleteImageMessage tells the downstream services that an image is gone so
+// they can remove its copy from the registry
+type DeleteImageMessage struct {
+	CorID             string `json:"corId"`
+	ImageName         string `json:"imageName"`
+	ImageTag          string `json:"imageTag"`
+	CreatorName       string `json:"creatorName"`
+	ImageRegistryLink string `json:"imageRegistryLink"`
+	S3Path            string `json:"s3Path"`
+}
+
+// DeleteImage godoc
+//
+//	@Summary		Delete an image
+//	@Description	Delete an image that no challenge uses or is being created from, together with its uploaded zip, and trigger the removal of its registry copy
+//	@Tags			images
+//	@Produce		json
+//	@Param			corId	path		string					true	"Correlation ID"
+//	@Success		200		{object}	models.Image
+//	@Success		202		{object}	models.Image			"Deleted, the message will be published once the MQ is reachable"
+//	@Failure		400		{object}	models.HTTPError
+//	@Failure		404		{object}	models.HTTPError
+//	@Failure		409		{object}	models.HTTPError		"Image is used by a challenge"
+//	@Failure		500		{object}	models.HTTPError
+//	@Failure		503		{object}	models.HTTPError		"Message was nacked or could not be routed"
+//	@Router			/image/{corId} [delete]
+func (t ImageController) DeleteImage(c *gin.Context) {
+	corId := c.Param("corId")
+
+	image, statusCode, err := t.ImageService.GetImageByCorId(corId)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve image",
+			err,
 		)
 		return
 	}
 
-	// publish to mq
-	err = mq.Pub(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD, jsonReq)
+	// challenges would fail to start without their image
+	challenges, statusCode, err := t.ChallengeCollection.GetChallengesByImage(image.ImageName, image.ImageTag, image.CreatorName)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to check the challenges of the image",
+			err,
+		)
+		return
+	}
+	if len(*challenges) > 0 {
+		names := make([]string, len(*challenges))
+		for i, challenge := range *challenges {
+			names[i] = challenge.ChallengeName
+		}
+		handleError(
+			c,
+			http.StatusConflict,
+			"Image is used by a challenge",
+			fmt.Errorf("image is used by %s", strings.Join(names, ", ")),
+		)
+		return
+	}
+
+	// nor could the challenges still being created from it
+	pending, statusCode, err := t.ProcessCollection.GetPendingChallengesByImage(image.ImageName, image.ImageTag, image.CreatorName)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to check the challenges of the image",
+			err,
+		)
+		return
+	}
+	if len(*pending) > 0 {
+		names := make([]string, len(*pending))
+		for i, event := range *pending {
+			names[i] = *event.CorId
+			if event.ChallengeName != nil {
+				names[i] = *event.ChallengeName
+			}
+		}
+		handleError(
+			c,
+			http.StatusConflict,
+			"Image is used by a challenge",
+			fmt.Errorf("image is used by %s, still being created", strings.Join(names, ", ")),
+		)
+		return
+	}
+
+	// the record is kept until the zip is gone so that a failed delete can be retried
+	msg := DeleteImageMessage{
+		CorID:             image.CorId,
+		ImageName:         image.ImageName,
+		ImageTag:          image.ImageTag,
+		CreatorName:       image.CreatorName,
+		ImageRegistryLink: image.ImageRegistryLink,
+		S3Path:            imageObjectPath(image.CreatorName, image.CorId),
+	}
+	err = services.GetUploader().DeleteFile(msg.S3Path)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to delete the image file",
+			err,
+		)
+		return
+	}
+
+	// aliases go first, so that a failed delete never leaves them pointing
+	// at a tag that is gone
+	statusCode, err = t.ImageService.Aliases.DeleteAliasesToTag(image.CreatorName, image.ImageName, image.ImageTag)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to delete the aliases of the image",
+			err,
+		)
+		return
+	}
+
+	statusCode, err = t.ImageService.DeleteImageByCorId(corId)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to delete image",
+			err,
+		)
+		return
+	}
+
+	env, err := mq.NewEnvelope(mq.EVENT_IMAGE_DELETE, corId, msg)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to marshal message",
+			err,
+		)
+		return
+	}
+
+	// record in outbox and publish to mq
+	statusCode, err = enqueue(t.Publisher, t.OutboxCollection, mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_DELETE, env)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to queue message",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *image)
+}
+
+// GetImageTags godoc
+//
+//	@Summary		Retrieve the tags of an image
+//	@Description	List every tag of an image, newest upload first, with its upload time, the status of its latest build and the aliases pointing at it
+//	@Tags			images
+//	@Produce		json
+//	@Param			creatorName	path		string	true	"Creator's Name"
+//	@Param			imageName	path		string	true	"Name of the Image"
+//	@Success		200			{array}		models.ImageTagInfo
+//	@Failure		400			{object}	models.HTTPError
+//	@Failure		404			{object}	models.HTTPError
+//	@Failure		500			{object}	models.HTTPError
+//	@Router			/image/name/{creatorName}/{imageName}/tags [get]
+func (t ImageController) GetImageTags(c *gin.Context) {
+	creatorName := c.Param("creatorName")
+	imageName := c.Param("imageName")
+
+	builds, statusCode, err := t.ProcessCollection.GetImageBuilds(creatorName, imageName)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve image builds",
+			err,
+		)
+		return
+	}
+
+	images, statusCode, err := t.ImageService.GetImagesByName(creatorName, imageName)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve images",
+			err,
+		)
+		return
+	}
+
+	aliases, statusCode, err := t.ImageService.Aliases.GetAliasesByImage(creatorName, imageName)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve image aliases",
+			err,
+		)
+		return
+	}
+
+	// builds come newest first, a tag shows its latest upload
+	tags := []models.ImageTagInfo{}
+	byTag := map[string]int{}
+	for _, build := range *builds {
+		if _, ok := byTag[build.ImageTag]; ok {
+			continue
+		}
+		byTag[build.ImageTag] = len(tags)
+		tags = append(tags, models.ImageTagInfo{ImageBuild: build})
+	}
+
+	// images built before their processes were recorded
+	for _, image := range *images {
+		i, ok := byTag[image.ImageTag]
+		if !ok {
+			i = len(tags)
+			byTag[image.ImageTag] = i
+			tags = append(tags, models.ImageTagInfo{ImageBuild: models.ImageBuild{
+				CorId:       image.CorId,
+				ImageTag:    image.ImageTag,
+				EventStatus: models.EventStatusImageCreated,
+			}})
+		}
+		tags[i].ImageRegistryLink = image.ImageRegistryLink
+	}
+
+	if len(tags) == 0 {
+		handleError(
+			c,
+			http.StatusNotFound,
+			"Failed to retrieve image tags",
+			errors.New("no tags found for the image"),
+		)
+		return
+	}
+
+	for i := range tags {
+		tags[i].Outcome = models.ProcessOutcome(tags[i].EventStatus)
+		tags[i].Aliases = []string{}
+	}
+	for _, alias := range *aliases {
+		if i, ok := byTag[alias.ImageTag]; ok {
+			tags[i].Aliases = append(tags[i].Aliases, alias.Alias)
+		}
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
+// SetImageAlias godoc
+//
+//	@Summary		Point an alias at a tag
+//	@Description	Create an alias of an image, such as latest or stable, or move it to another built tag. Challenges created with the alias as their tag use the tag it points at.
+//	@Tags			images
+//	@Accept			json
+//	@Produce		json
+//	@Param			creatorName	path		string				true	"Creator's Name"
+//	@Param			imageName	path		string				true	"Name of the Image"
+//	@Param			alias		path		string				true	"Alias"
+//	@Param			target		body		models.ImageAlias	true	"Tag to point at"
+//	@Success		200			{object}	models.ImageAlias
+//	@Failure		400			{object}	models.HTTPError
+//	@Failure		404			{object}	models.HTTPError
+//	@Failure		409			{object}	models.HTTPError	"Alias is the name of a tag"
+//	@Router			/image/name/{creatorName}/{imageName}/alias/{alias} [put]
+func (t ImageController) SetImageAlias(c *gin.Context) {
+	var req models.ImageAlias
+	err := c.BindJSON(&req)
 	if err != nil {
 		handleError(
 			c,
 			http.StatusBadRequest,
-			"Error",
-			errors.New("failed to format request"),
+			"Invalid request body json",
+			err,
 		)
 		return
 	}
 
-	// response
-	resp := map[string]interface{}{"corId": corId}
+	// validate json
+	v := validator.New()
+	err = v.Struct(req)
 	if err != nil {
 		handleError(
 			c,
 			http.StatusBadRequest,
-			"Error",
-			errors.New("failed to generate corId"),
+			"Invalid request body",
+			err,
+		)
+		return
+	}
+
+	req.CreatorName = c.Param("creatorName")
+	req.ImageName = c.Param("imageName")
+	req.Alias = c.Param("alias")
+
+	// an alias cannot shadow a tag
+	imageTag, statusCode, err := t.ImageService.CheckImageExists(req.ImageName, req.Alias, req.CreatorName)
+	if err == nil && imageTag == req.Alias {
+		statusCode, err = http.StatusConflict, errors.New("alias is the name of a tag")
+	}
+	if err != nil && statusCode != http.StatusNotFound {
+		handleError(
+			c,
+			statusCode,
+			"Invalid alias",
+			err,
+		)
+		return
+	}
+
+	// aliases point at built tags, not at other aliases
+	imageTag, statusCode, err = t.ImageService.CheckImageExists(req.ImageName, req.ImageTag, req.CreatorName)
+	if err == nil && imageTag != req.ImageTag {
+		statusCode, err = http.StatusBadRequest, errors.New("an alias cannot point at another alias")
+	}
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Invalid image tag",
+			err,
+		)
+		return
+	}
+
+	statusCode, err = t.ImageService.Aliases.SetAlias(&req)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to set alias",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, req)
+}
+
+// DeleteImageAlias godoc
+//
+//	@Summary		Delete an alias
+//	@Description	Remove an alias of an image, the tag it pointed at is kept
+//	@Tags			images
+//	@Param			creatorName	path	string	true	"Creator's Name"
+//	@Param			imageName	path	string	true	"Name of the Image"
+//	@Param			alias		path	string	true	"Alias"
+//	@Success		204
+//	@Failure		404	{object}	models.HTTPError
+//	@Router			/image/name/{creatorName}/{imageName}/alias/{alias} [delete]
+func (t ImageController) DeleteImageAlias(c *gin.Context) {
+	statusCode, err := t.ImageService.Aliases.DeleteAlias(c.Param("creatorName"), c.Param("imageName"), c.Param("alias"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to delete alias",
+			err,
 		)
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.Status(statusCode)
 }
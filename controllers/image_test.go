@@ -3,24 +3,31 @@
 package controllers
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"platform_api/configs"
 	"platform_api/models"
+	"platform_api/mq"
+	"platform_api/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 
 	// "go.mongodb.org/mongo-driver/mongo"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
-var imageController = NewImageController(configs.Client)
+var imageController = NewImageController(configs.Client, testPublisher)
 
 func seed_images() {
 	// Create a context with a timeout
@@ -133,4 +140,272 @@ func TestGetImageByCreatorName(t *testing.T) {
 	// Check the response body
 	expectedResponse := `[{"corId":"1a","creatorName":"Bob","imageName":"image1","imageTag":"v1.0-Bob","imageRegistryLink":"registry.com/bob"}]`
 	assert.Equal(t, expectedResponse, w.Body.String())
-}
\ No newline at end of file
+}
+
+func TestDeleteImage_UsedByChallenge(t *testing.T) {
+	seed_images()
+	seed_challenges()
+
+	r := gin.Default()
+
+	r.DELETE("/image/:corId", imageController.DeleteImage)
+
+	req, _ := http.NewRequest("DELETE", "/image/1a", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	// ChallengeOne runs image1:v1.0-Bob
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "ChallengeOne")
+}
+
+// seed_deletable_image records an image no challenge uses
+func seed_deletable_image(t *testing.T, corId string, imageTag string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := configs.OpenCollection(configs.Client, "image_builder").InsertOne(ctx, models.Image{
+		CorId:             corId,
+		CreatorName:       "Dave",
+		ImageName:         "image3",
+		ImageTag:          imageTag,
+		ImageRegistryLink: "registry.com/dave",
+	})
+	assert.NoError(t, err)
+}
+
+// clear_records deletes the records of a process from a collection
+func clear_records(collection string, corId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	configs.OpenCollection(configs.Client, collection).DeleteMany(ctx, bson.D{{Key: "corId", Value: corId}})
+}
+
+func TestDeleteImage(t *testing.T) {
+	corId := uuid.New().String()
+	seed_deletable_image(t, corId, "v1.0-Dave")
+
+	uploader := services.NewMemoryUploader()
+	services.SetUploader(uploader)
+	uploader.PutObject(imageObjectPath("Dave", corId), []byte("zip"))
+
+	testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.DELETE("/image/:corId", imageController.DeleteImage)
+	r.GET("/image/:corId", imageController.GetImageByCorId)
+	r.PUT("/image/name/:creatorName/:imageName/alias/:alias", imageController.SetImageAlias)
+
+	req, _ := http.NewRequest("PUT", "/image/name/Dave/image3/alias/latest", bytes.NewBufferString(`{"imageTag": "v1.0-Dave"}`))
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("DELETE", "/image/"+corId, nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the zip and the record are gone
+	_, ok := uploader.Object(imageObjectPath("Dave", corId))
+	assert.False(t, ok)
+
+	req, _ = http.NewRequest("GET", "/image/"+corId, nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// nor any alias to the tag
+	aliases, _, err := imageController.ImageService.Aliases.GetAliasesByImage("Dave", "image3")
+	assert.NoError(t, err)
+	assert.Empty(t, *aliases)
+
+	// and the builder is told to remove the image
+	messages := testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_DELETE)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, corId, messages[0].CorrelationId)
+}
+
+func TestDeleteImage_ChallengeBeingCreated(t *testing.T) {
+	corId := uuid.New().String()
+	seed_deletable_image(t, corId, "v2.0-Dave")
+	defer clear_records("image_builder", corId)
+
+	services.SetUploader(services.NewMemoryUploader())
+
+	// a challenge creation from the image has not been answered yet
+	processCorId := uuid.New().String()
+	defer clear_records("process_engine", processCorId)
+	event := models.EventChallengeCreate
+	eventStatus := models.EventStatusChallengeCreating
+	creatorName := "Dave"
+	challengeName := "ChallengeDave"
+	imageName := "image3"
+	imageTag := "v2.0-Dave"
+	_, err := imageController.ProcessCollection.InsertProcess(&models.Process{
+		CorId:         &processCorId,
+		Event:         &event,
+		EventStatus:   &eventStatus,
+		CreatorName:   &creatorName,
+		ChallengeName: &challengeName,
+		ImageName:     &imageName,
+		ImageTag:      &imageTag,
+	})
+	assert.NoError(t, err)
+
+	r := gin.Default()
+
+	r.DELETE("/image/:corId", imageController.DeleteImage)
+
+	req, _ := http.NewRequest("DELETE", "/image/"+corId, nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "ChallengeDave")
+}
+
+func TestDeleteImage_NotFound(t *testing.T) {
+	r := gin.Default()
+
+	r.DELETE("/image/:corId", imageController.DeleteImage)
+
+	req, _ := http.NewRequest("DELETE", "/image/xyxy", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestImageAlias(t *testing.T) {
+	seed_images()
+
+	r := gin.Default()
+
+	r.PUT("/image/name/:creatorName/:imageName/alias/:alias", imageController.SetImageAlias)
+	r.GET("/image/name/:creatorName/:imageName/tags", imageController.GetImageTags)
+
+	// point latest at a built tag
+	req, _ := http.NewRequest("PUT", "/image/name/Bob/image1/alias/latest", bytes.NewBufferString(`{"imageTag": "v1.0-Bob"}`))
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"alias":"latest","imageTag":"v1.0-Bob"`)
+
+	// the tag lists its aliases
+	req, _ = http.NewRequest("GET", "/image/name/Bob/image1/tags", nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"imageTag":"v1.0-Bob"`)
+	assert.Contains(t, w.Body.String(), `"aliases":["latest"]`)
+
+	// the alias resolves to the tag
+	imageTag, statusCode, err := imageController.ImageService.CheckImageExists("image1", "latest", "Bob")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "v1.0-Bob", imageTag)
+
+	// an alias cannot shadow a tag
+	req, _ = http.NewRequest("PUT", "/image/name/Bob/image1/alias/v1.0-Bob", bytes.NewBufferString(`{"imageTag": "v1.0-Bob"}`))
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// nor point at a tag that was never built
+	req, _ = http.NewRequest("PUT", "/image/name/Bob/image1/alias/stable", bytes.NewBufferString(`{"imageTag": "v9"}`))
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// uploadRequest builds the multipart upload of an archive
+func uploadRequest(imageName string, archive []byte) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("imageName", imageName)
+	w.WriteField("creatorName", "Carol")
+	w.WriteField("imageTag", "v1")
+	f, _ := w.CreateFormFile("imageFile", "challenge.zip")
+	f.Write(archive)
+	w.Close()
+
+	req, _ := http.NewRequest("POST", "/image", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestUploadImage_InvalidArchive(t *testing.T) {
+	r := gin.Default()
+
+	r.POST("/image", imageController.UploadImage)
+
+	// not a zip at all
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, uploadRequest("carol1", []byte("not a zip")))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"rule":"format"`)
+
+	// an entry outside the root and no Dockerfile
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	f, _ := zw.Create("../escape.sh")
+	f.Write([]byte("echo"))
+	zw.Close()
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, uploadRequest("carol1", archive.Bytes()))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `{"rule":"path","path":"../escape.sh","message":"entry path leaves the archive root"}`)
+	assert.Contains(t, w.Body.String(), `"rule":"dockerfile"`)
+}
+
+func TestUploadImage_TooLarge(t *testing.T) {
+	defer func(limits configs.ArchiveLimits) { configs.ARCHIVE_LIMITS = limits }(configs.ARCHIVE_LIMITS)
+	configs.ARCHIVE_LIMITS.MaxSize = 1 << 10
+
+	r := gin.Default()
+
+	r.POST("/image", imageController.UploadImage)
+
+	// refused while reading the body, before the archive is looked at
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, uploadRequest("carol2", bytes.Repeat([]byte("x"), 2<<20)))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), `"rule":"size"`)
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MigrationController struct {
+	MigrationCollection collections.MigrationCollection
+
+	// migrations by name, returning how many records they rewrote and skipped
+	Migrations map[string]func() (models.MigrationResult, int, error)
+}
+
+func NewMigrationController(client *mongo.Client) *MigrationController {
+	processCollection := collections.NewProcessCollection(client)
+
+	return &MigrationController{
+		MigrationCollection: *collections.NewMigrationCollection(client),
+		Migrations: map[string]func() (models.MigrationResult, int, error){
+			models.MIGRATION_PROCESS_TIMESTAMPS: processCollection.MigrateTimestamps,
+		},
+	}
+}
+
+// StartMigration godoc
+//
+//	@Summary		Start a data migration
+//	@Description	Run a one-off data migration in the background. A migration runs once, unless it failed. processTimestamps rewrites process timestamps stored before they were dates.
+//	@Tags			admin
+//	@Produce		json
+//	@Param			name	path		string	true	"Migration name"	Enums(processTimestamps)
+//	@Success		202		{object}	models.Migration
+//	@Failure		404		{object}	models.HTTPError
+//	@Failure		409		{object}	models.HTTPError	"Migration is running or completed"
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/admin/migration/{name} [post]
+func (t MigrationController) StartMigration(c *gin.Context) {
+	name := c.Param("name")
+
+	migrate, ok := t.Migrations[name]
+	if !ok {
+		handleError(
+			c,
+			http.StatusNotFound,
+			"Failed to start migration",
+			errors.New("no migration with this name"),
+		)
+		return
+	}
+
+	// claimed so it runs once across replicas
+	migration, statusCode, err := t.MigrationCollection.ClaimMigration(name)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to start migration",
+			err,
+		)
+		return
+	}
+
+	go func() {
+		result, _, err := migrate()
+		if err != nil {
+			log.Printf("Migration %s failed after %d records: %s", name, result.Migrated, err)
+		} else {
+			log.Printf("Migration %s rewrote %d records and skipped %d", name, result.Migrated, result.Skipped)
+		}
+
+		_, err = t.MigrationCollection.FinishMigration(name, result, err)
+		if err != nil {
+			log.Printf("Failed to record the outcome of migration %s: %s", name, err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, *migration)
+}
+
+// GetMigration godoc
+//
+//	@Summary		Retrieve a data migration
+//	@Description	Get whether a data migration is running, completed or failed, and how many records it rewrote or skipped
+//	@Tags			admin
+//	@Produce		json
+//	@Param			name	path		string	true	"Migration name"	Enums(processTimestamps)
+//	@Success		200		{object}	models.Migration
+//	@Failure		404		{object}	models.HTTPError
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/admin/migration/{name} [get]
+func (t MigrationController) GetMigration(c *gin.Context) {
+	migration, statusCode, err := t.MigrationCollection.GetMigration(c.Param("name"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve migration",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *migration)
+}
@@ -0,0 +1,130 @@
+// +build integration
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"platform_api/configs"
+	"platform_api/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var migrationController = NewMigrationController(configs.Client)
+
+func clear_migrations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	migrationController.MigrationCollection.Collection.DeleteMany(ctx, bson.D{})
+}
+
+var legacyTime = time.Date(2023, 5, 1, 12, 30, 15, 250000000, time.UTC)
+
+// seed_legacy_timestamps writes a process record for every older shape of
+// its timestamp, keyed by the id of each record
+func seed_legacy_timestamps() map[primitive.ObjectID]interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	legacy := map[primitive.ObjectID]interface{}{
+		primitive.NewObjectID(): bson.D{{Key: "unixNano", Value: legacyTime.UnixNano()}},
+		primitive.NewObjectID(): legacyTime.UnixMilli(),
+		primitive.NewObjectID(): legacyTime.Format(time.RFC3339Nano),
+	}
+
+	var documents []interface{}
+	for id, timestamp := range legacy {
+		documents = append(documents, bson.D{
+			{Key: "_id", Value: id},
+			{Key: "corId", Value: "migration-legacy"},
+			{Key: "timestamp", Value: timestamp},
+		})
+	}
+	configs.OpenCollection(configs.Client, "process_engine").InsertMany(ctx, documents)
+
+	return legacy
+}
+
+func clear_legacy_timestamps() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	configs.OpenCollection(configs.Client, "process_engine").DeleteMany(ctx, bson.D{{Key: "corId", Value: "migration-legacy"}})
+}
+
+func TestStartMigration(t *testing.T) {
+	clear_migrations()
+	defer clear_migrations()
+	legacy := seed_legacy_timestamps()
+	defer clear_legacy_timestamps()
+
+	r := gin.Default()
+
+	r.GET("/admin/migration/:name", migrationController.GetMigration)
+	r.POST("/admin/migration/:name", migrationController.StartMigration)
+
+	req, _ := http.NewRequest("POST", "/admin/migration/processTimestamps", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// runs in the background until it completes
+	var migration models.Migration
+	assert.Eventually(t, func() bool {
+		req, _ := http.NewRequest("GET", "/admin/migration/processTimestamps", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		json.Unmarshal(w.Body.Bytes(), &migration)
+		return migration.Status != models.MigrationStatusRunning
+	}, 30*time.Second, 100*time.Millisecond)
+	assert.Equal(t, models.MigrationStatusCompleted, migration.Status)
+	assert.GreaterOrEqual(t, migration.Migrated, len(legacy))
+	assert.Equal(t, 0, migration.Skipped)
+
+	// every older shape was rewritten as a date
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for id := range legacy {
+		var record bson.Raw
+		err := configs.OpenCollection(configs.Client, "process_engine").FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&record)
+		assert.NoError(t, err)
+
+		timestamp := record.Lookup("timestamp")
+		assert.Equal(t, bsontype.DateTime, timestamp.Type)
+		assert.Equal(t, legacyTime, timestamp.Time().UTC())
+	}
+
+	// and only once
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestStartMigration_NotFound(t *testing.T) {
+	r := gin.Default()
+
+	r.POST("/admin/migration/:name", migrationController.StartMigration)
+
+	req, _ := http.NewRequest("POST", "/admin/migration/xyxy", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/mq"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type OutboxController struct {
+	OutboxCollection collections.OutboxCollection
+}
+
+func NewOutboxController(client *mongo.Client) *OutboxController {
+	return &OutboxController{OutboxCollection: *collections.NewOutboxCollection(client)}
+}
+
+// GetOutboxStats godoc
+//
+//	@Summary		Retrieve outbox statistics
+//	@Description	Get the number of messages waiting to be published to the MQ and the age of the oldest one
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	models.OutboxStats
+//	@Failure		500	{object}	models.HTTPError
+//	@Router			/admin/outbox [get]
+func (t OutboxController) GetOutboxStats(c *gin.Context) {
+	stats, statusCode, err := t.OutboxCollection.GetStats()
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve outbox statistics",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *stats)
+}
+
+// enqueue records a message in the outbox and tries to publish it straight
+// away. It returns 200 when the message reached the MQ and 202 when it was
+// accepted but spooled or left for the relay to retry. A message the broker
+// refused is not retried and fails the request.
+func enqueue(publisher mq.Publisher, outbox collections.OutboxCollection, exchange string, key string, env *mq.Envelope) (int, error) {
+	msg, err := env.OutboxMessage(exchange, key)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	statusCode, err := outbox.InsertMessage(msg)
+	if err != nil {
+		return statusCode, err
+	}
+
+	err = mq.Dispatch(publisher, outbox, msg)
+	if mq.IsRefused(err) {
+		return publishErrorStatus(err), err
+	}
+	if err != nil {
+		log.Printf("Queued %s for retry: %s", env.CorId, err)
+		return http.StatusAccepted, nil
+	}
+
+	return http.StatusOK, nil
+}
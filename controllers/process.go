@@ -1,34 +1,82 @@
 package controllers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
 	"platform_api/collections"
+	"platform_api/configs"
+	"platform_api/models"
+	"platform_api/mq"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ProcessController struct{
 	ProcessCollection collections.ProcessCollection
+	OutboxCollection  collections.OutboxCollection
+	Publisher         mq.Publisher
 }
 
-func NewProcessController(client *mongo.Client) *ProcessController {
-	return &ProcessController{ProcessCollection: *collections.NewProcessCollection(client)}
+func NewProcessController(client *mongo.Client, publisher mq.Publisher) *ProcessController {
+	return &ProcessController{
+		ProcessCollection: *collections.NewProcessCollection(client),
+		OutboxCollection:  *collections.NewOutboxCollection(client),
+		Publisher:         publisher,
+	}
 }
 
 // var processCollection *mongo.Collection = configs.OpenCollection(configs.Client, "process_engine")
 
+const (
+	defaultProcessPageSize = 100
+	maxProcessPageSize     = 1000
+)
+
 // GetAllProcesses godoc
 //
-//	@Summary		Retrieves all processes
-//	@Description	Get all the processes from the process engine
+//	@Summary		Retrieves processes
+//	@Description	Get a page of the processes from the process engine, optionally filtered. Pages are ordered by creation. When more processes follow, the X-Next-Cursor header holds the cursor of the next page and the Link header its URL.
 //	@Tags			processes
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{array}		models.Process
-//	@Failure		500	{object}	models.HTTPError
+//	@Param			event			query		string	false	"Event type"
+//	@Param			eventStatus		query		string	false	"Event status"
+//	@Param			creatorName		query		string	false	"Creator's Name"
+//	@Param			challengeName	query		string	false	"Challenge Name"
+//	@Param			participant		query		string	false	"Participant"
+//	@Param			from			query		string	false	"Created at or after (RFC 3339)"
+//	@Param			to				query		string	false	"Created before (RFC 3339)"
+//	@Param			since			query		string	false	"Timestamp at or after (RFC 3339)"
+//	@Param			until			query		string	false	"Timestamp before (RFC 3339)"
+//	@Param			cursor			query		string	false	"Cursor of the page, from X-Next-Cursor"
+//	@Param			limit			query		int		false	"Page size (default 100, at most 1000)"
+//	@Param			order			query		string	false	"asc (default) or desc"
+//	@Success		200				{array}		models.Process
+//	@Header			200				{string}	X-Next-Cursor	"Cursor of the next page"
+//	@Failure		400				{object}	models.HTTPError
+//	@Failure		500				{object}	models.HTTPError
 //	@Router			/processes [get]
 func (t ProcessController) GetAllProcesses(c *gin.Context) {
-	process, statusCode, err := t.ProcessCollection.GetAllProcesses()
+	search, err := parseProcessSearch(c)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid query",
+			err,
+		)
+		return
+	}
+
+	events, more, statusCode, err := t.ProcessCollection.SearchProcesses(search)
 	if err != nil {
 		handleError(
 			c,
@@ -39,7 +87,131 @@ func (t ProcessController) GetAllProcesses(c *gin.Context) {
 		return
 	}
 
-	c.JSON(statusCode, *process)
+	process := make([]models.Process, len(*events))
+	for i, event := range *events {
+		process[i] = event.Process
+	}
+
+	if more {
+		cursor := (*events)[len(*events)-1].Id.Hex()
+		next := *c.Request.URL
+		query := next.Query()
+		query.Set("cursor", cursor)
+		next.RawQuery = query.Encode()
+
+		c.Header("X-Next-Cursor", cursor)
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
+
+	c.JSON(statusCode, process)
+}
+
+// parseTimeRange reads an optional range of RFC 3339 times from the query
+// parameters with the given names
+func parseTimeRange(c *gin.Context, fromName string, toName string) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+	for name, bound := range map[string]**time.Time{fromName: &from, toName: &to} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*bound = &parsed
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, fmt.Errorf("%s must be before %s", fromName, toName)
+	}
+	return from, to, nil
+}
+
+// GetProcessStats godoc
+//
+//	@Summary		Retrieves process statistics
+//	@Description	Count the processes started in the time range by event type and latest status, with the success rate of the finished ones, p50/p95/p99 time to a terminal status in milliseconds, and a daily series
+//	@Tags			processes
+//	@Produce		json
+//	@Param			creatorName	query		string	false	"Creator's Name"
+//	@Param			from		query		string	false	"Started at or after (RFC 3339)"
+//	@Param			to			query		string	false	"Started before (RFC 3339)"
+//	@Success		200			{object}	models.ProcessStats
+//	@Failure		400			{object}	models.HTTPError
+//	@Failure		500			{object}	models.HTTPError
+//	@Router			/process/stats [get]
+func (t ProcessController) GetProcessStats(c *gin.Context) {
+	from, to, err := parseTimeRange(c, "from", "to")
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid query",
+			err,
+		)
+		return
+	}
+
+	stats, statusCode, err := t.ProcessCollection.GetProcessStats(c.Query("creatorName"), from, to)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve process statistics",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *stats)
+}
+
+// parseProcessSearch reads the search of GetAllProcesses from the query
+func parseProcessSearch(c *gin.Context) (models.ProcessSearch, error) {
+	search := models.ProcessSearch{
+		Event:         c.Query("event"),
+		EventStatus:   c.Query("eventStatus"),
+		CreatorName:   c.Query("creatorName"),
+		ChallengeName: c.Query("challengeName"),
+		Participant:   c.Query("participant"),
+		Limit:         defaultProcessPageSize,
+	}
+
+	var err error
+	search.From, search.To, err = parseTimeRange(c, "from", "to")
+	if err != nil {
+		return search, err
+	}
+	search.Since, search.Until, err = parseTimeRange(c, "since", "until")
+	if err != nil {
+		return search, err
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return search, errors.New("invalid cursor")
+		}
+		search.Cursor = &cursor
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit <= 0 || limit > maxProcessPageSize {
+			return search, fmt.Errorf("limit must be between 1 and %d", maxProcessPageSize)
+		}
+		search.Limit = limit
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		search.Descending = true
+	default:
+		return search, errors.New("order must be asc or desc")
+	}
+
+	return search, nil
 }
 
 // GetProcessByCorID godoc
@@ -50,14 +222,26 @@ func (t ProcessController) GetAllProcesses(c *gin.Context) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			corId	path		string	true	"Correlation ID"
+//	@Param			since	query		string	false	"Timestamp at or after (RFC 3339)"
+//	@Param			until	query		string	false	"Timestamp before (RFC 3339)"
 //	@Success		200		{object}	models.Process
 //	@Failure		400		{object}	models.HTTPError
 //	@Failure		404		{object}	models.HTTPError
 //	@Router			/processes/{corId} [get]
 func (t ProcessController) GetProcessByCorID(c *gin.Context) {
 	corId := c.Param("corId")
+	since, until, err := parseTimeRange(c, "since", "until")
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid query",
+			err,
+		)
+		return
+	}
 	
-	process, statusCode, err := t.ProcessCollection.GetAllProcessByCorID(corId)
+	process, statusCode, err := t.ProcessCollection.GetAllProcessByCorID(corId, since, until)
 	if err != nil {
 		handleError(
 			c,
@@ -79,14 +263,26 @@ func (t ProcessController) GetProcessByCorID(c *gin.Context) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			creatorName	path		string	true	"Creator's Name"
+//	@Param			since		query		string	false	"Timestamp at or after (RFC 3339)"
+//	@Param			until		query		string	false	"Timestamp before (RFC 3339)"
 //	@Success		200			{array}		models.Process
 //	@Failure		400			{object}	models.HTTPError
 //	@Failure		404			{object}	models.HTTPError
 //	@Router			/processes/byCreator/{creatorName} [get]
 func (t ProcessController) GetProcessByCreatorName(c *gin.Context) {
 	creatorName := c.Param("creatorName")
+	since, until, err := parseTimeRange(c, "since", "until")
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid query",
+			err,
+		)
+		return
+	}
 	
-	process, statusCode, err := t.ProcessCollection.GetProcessByCreatorName(creatorName)
+	process, statusCode, err := t.ProcessCollection.GetProcessByCreatorName(creatorName, since, until)
 	if err != nil {
 		handleError(
 			c,
@@ -94,6 +290,7 @@ func (t ProcessController) GetProcessByCreatorName(c *gin.Context) {
 			"Failed to retrieve process",
 			err,
 		)
+		return
 	}
 
 	c.JSON(statusCode, *process)
@@ -102,18 +299,26 @@ func (t ProcessController) GetProcessByCreatorName(c *gin.Context) {
 // GetProcessStatusByCorId godoc
 //
 //	@Summary		Retrieves the status of a process by Correlation ID
-//	@Description	Get the most recent status of a specific process by Correlation ID
+//	@Description	Get the most recent status of a specific process by Correlation ID. With waitFor the request is held until the process reaches that status or any terminal one, and answered with 408 and the current status when the timeout runs out first.
 //	@Tags			processes
 //	@Accept			json
 //	@Produce		json
 //	@Param			corId	path		string	true	"Correlation ID"
-//	@Success		200		{object}	models.Process
+//	@Param			waitFor	query		string	false	"Event status to wait for, or terminal"
+//	@Param			timeout	query		string	false	"How long to wait, such as 60s (default 30s, at most 120s)"
+//	@Success		200		{object}	models.Process	"Process with its outcome: pending, succeeded, failed or cancelled"
 //	@Failure		400		{object}	models.HTTPError
 //	@Failure		404		{object}	models.HTTPError
+//	@Failure		408		{object}	models.Process	"Still in the returned status when the timeout ran out"
 //	@Failure		500		{object}	models.HTTPError
 //	@Router			/processes/status/{corId} [get]
 func (t ProcessController) GetProcessStatusByCorId(c *gin.Context) {
 	corId := c.Param("corId")
+
+	if c.Query("waitFor") != "" {
+		t.waitForProcessStatus(c, corId)
+		return
+	}
 	
 	process, statusCode, err := t.ProcessCollection.GetLatestStatusByCorId(corId)
 	if err != nil {
@@ -123,8 +328,292 @@ func (t ProcessController) GetProcessStatusByCorId(c *gin.Context) {
 			"Failed to retrieve process",
 			err,
 		)
+		return
 	}
 
-	c.JSON(statusCode, *process)
+	respondWithProcess(c, statusCode, *process)
+}
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 120 * time.Second
+
+	// waitFor value matching only the terminal statuses
+	waitForTerminal = "terminal"
+)
+
+// waitForProcessStatus answers GetProcessStatusByCorId once the process is in
+// the waitFor status or a terminal one, or with 408 on timeout
+func (t ProcessController) waitForProcessStatus(c *gin.Context, corId string) {
+	waitFor := c.Query("waitFor")
+	if waitFor != waitForTerminal && !models.IsProcessStatus(waitFor) {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid waitFor",
+			fmt.Errorf("unknown event status %q", waitFor),
+		)
+		return
+	}
+
+	timeout := defaultWaitTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		// plain numbers are seconds
+		if _, err := strconv.Atoi(raw); err == nil {
+			raw += "s"
+		}
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > maxWaitTimeout {
+			handleError(
+				c,
+				http.StatusBadRequest,
+				"Invalid timeout",
+				fmt.Errorf("timeout must be a duration up to %s", maxWaitTimeout),
+			)
+			return
+		}
+		timeout = parsed
+	}
+
+	reached := func(process *models.Process) bool {
+		if process.EventStatus == nil {
+			return false
+		}
+		return *process.EventStatus == waitFor || models.IsTerminalStatus(*process.EventStatus)
+	}
+
+	// the process may not have been recorded yet, wait for it as well
+	var after primitive.ObjectID
+	latest, statusCode, err := t.ProcessCollection.GetLatestEventByCorId(corId)
+	if err != nil && statusCode != http.StatusNotFound {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve process",
+			err,
+		)
+		return
+	}
+	if latest != nil {
+		if reached(&latest.Process) {
+			respondWithProcess(c, http.StatusOK, latest.Process)
+			return
+		}
+		after = latest.Id
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	for event := range t.ProcessCollection.Follow(ctx, bson.M{"corId": corId}, after, streamPollInterval()) {
+		event := event
+		latest = &event
+		if reached(&event.Process) {
+			respondWithProcess(c, http.StatusOK, event.Process)
+			return
+		}
+	}
+
+	// the client went away
+	if c.Request.Context().Err() != nil {
+		return
+	}
+
+	if latest == nil {
+		handleError(
+			c,
+			http.StatusRequestTimeout,
+			"Timed out waiting for process",
+			errors.New("no process found with corId"),
+		)
+		return
+	}
+	respondWithProcess(c, http.StatusRequestTimeout, latest.Process)
+}
+
+// respondWithProcess sends a process with its outcome
+func respondWithProcess(c *gin.Context, statusCode int, process models.Process) {
+	if process.EventStatus != nil {
+		process.Outcome = models.ProcessOutcome(*process.EventStatus)
+	}
+	c.JSON(statusCode, process)
+}
+
+// GetProcessStates godoc
+//
+//	@Summary		Retrieves the process state machines
+//	@Description	Get the event statuses of every event type, the statuses that may follow each of them and whether they are pending, succeeded, failed or cancelled
+//	@Tags			processes
+//	@Produce		json
+//	@Success		200	{array}	models.ProcessStateMachine
+//	@Router			/process/states [get]
+func (t ProcessController) GetProcessStates(c *gin.Context) {
+	c.JSON(http.StatusOK, models.PROCESS_STATE_MACHINES)
+}
+
+// GetProcessTimeline godoc
+//
+//	@Summary		Retrieves the timeline of a process
+//	@Description	Get the ordered steps of a process with the time spent in each event status and the total elapsed time. A process is stuck when its current, non-terminal status has lasted longer than the threshold of its event type.
+//	@Tags			processes
+//	@Produce		json
+//	@Param			corId	path		string	true	"Correlation ID"
+//	@Success		200		{object}	models.ProcessTimeline
+//	@Failure		400		{object}	models.HTTPError
+//	@Failure		404		{object}	models.HTTPError
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/process/{corId}/timeline [get]
+func (t ProcessController) GetProcessTimeline(c *gin.Context) {
+	corId := c.Param("corId")
+
+	events, statusCode, err := t.ProcessCollection.GetProcessEventsByCorId(corId)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve process",
+			err,
+		)
+		return
+	}
+
+	timeline := models.NewProcessTimeline(*events, time.Now(), configs.StuckThreshold)
+
+	c.JSON(statusCode, timeline)
+}
+
+// CancelProcessMessage is published to ask the downstream services to stop
+// an in-flight process.
+type CancelProcessMessage struct {
+	CorId         string  `json:"corId"`
+	Event         string  `json:"event"`
+	EventStatus   string  `json:"eventStatus"`
+	CreatorName   *string `json:"creatorName,omitempty"`
+	ChallengeName *string `json:"challengeName,omitempty"`
+	ImageName     *string `json:"imageName,omitempty"`
+	ImageTag      *string `json:"imageTag,omitempty"`
+	Participant   *string `json:"participant,omitempty"`
+}
+
+// CancelProcess godoc
+//
+//	@Summary		Cancel an in-flight process
+//	@Description	Request cancellation of an image build, challenge creation or attempt start that has not finished yet
+//	@Tags			processes
+//	@Produce		json
+//	@Param			corId	path		string	true	"Correlation ID"
+//	@Success		200		{object}	models.Process	"Cancellation published"
+//	@Success		202		{object}	models.Process	"Cancellation accepted, will be published once the MQ is reachable"
+//	@Failure		400		{object}	models.HTTPError
+//	@Failure		404		{object}	models.HTTPError
+//	@Failure		409		{object}	models.HTTPError	"Process already finished or cancellation already requested"
+//	@Failure		500		{object}	models.HTTPError	"Cancellation was queued but not recorded on the timeline, or another error"
+//	@Failure		503		{object}	models.HTTPError	"Cancellation was nacked or could not be routed"
+//	@Router			/process/{corId}/cancel [post]
+func (t ProcessController) CancelProcess(c *gin.Context) {
+	corId := c.Param("corId")
+
+	latest, statusCode, err := t.ProcessCollection.GetLatestStatusByCorId(corId)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve process",
+			err,
+		)
+		return
+	}
+
+	// only in-flight processes can be cancelled
+	if latest.Event == nil || latest.EventStatus == nil {
+		handleError(
+			c,
+			http.StatusConflict,
+			"Failed to cancel process",
+			errors.New("process has no event status"),
+		)
+		return
+	}
+	err = models.ValidateTransition(*latest.Event, *latest.EventStatus, models.EventStatusCancelRequested)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusConflict,
+			"Failed to cancel process",
+			err,
+		)
+		return
+	}
+
+	req := CancelProcessMessage{
+		CorId:         corId,
+		Event:         *latest.Event,
+		EventStatus:   models.EventStatusCancelRequested,
+		CreatorName:   latest.CreatorName,
+		ChallengeName: latest.ChallengeName,
+		ImageName:     latest.ImageName,
+		ImageTag:      latest.ImageTag,
+		Participant:   latest.Participant,
+	}
+
+	// wrap in envelope
+	env, err := mq.NewEnvelope(mq.EVENT_PROCESS_CANCEL, corId, req)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to marshall JSON",
+			err,
+		)
+		return
+	}
+
+	// record in outbox and publish to mq
+	statusCode, err = enqueue(t.Publisher, t.OutboxCollection, mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_PROCESS_CANCEL, env)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to queue message",
+			err,
+		)
+		return
+	}
+
+	// show the request on the process timeline, it is what keeps the
+	// cancellation from being requested again
+	eventStatus := models.EventStatusCancelRequested
+	process := *latest
+	process.EventStatus = &eventStatus
+
+	err = t.recordCancelRequest(&process)
+	if err != nil {
+		log.Printf("Failed to record cancellation of %s: %s", corId, err)
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Cancellation was queued but not recorded",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, process)
 }
 
+// how often recording a queued cancellation is tried
+const cancelRecordAttempts = 3
+
+// recordCancelRequest inserts the cancelRequested record of a queued
+// cancellation, trying again with a fresh timestamp when it fails
+func (t ProcessController) recordCancelRequest(process *models.Process) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		process.Timestamp = models.NewProcessTimestamp(time.Now())
+		_, err = t.ProcessCollection.InsertProcess(process)
+		if err == nil || attempt == cancelRecordAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+}
@@ -10,14 +10,16 @@ import (
 	"net/http/httptest"
 	"platform_api/configs"
 	"platform_api/models"
+	"platform_api/mq"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
 )
 
-var processController = NewProcessController(configs.Client)
+var processController = NewProcessController(configs.Client, testPublisher)
 
 
 func seed_processes() {
@@ -99,4 +101,477 @@ func TestGetProcessByCorId_NotFound(t *testing.T) {
 	r.ServeHTTP(w, req)
 	
 	assert.Equal(t, http.StatusNotFound, w.Code)
-}
\ No newline at end of file
+}
+
+func TestCancelProcess_NotFound(t *testing.T) {
+	seed_processes()
+
+	r := gin.Default()
+
+	r.POST("/process/:corId/cancel", processController.CancelProcess)
+
+	req, _ := http.NewRequest("POST", "/process/xyxy/cancel", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCancelProcess_Conflict(t *testing.T) {
+	seed_processes()
+	testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/process/:corId/cancel", processController.CancelProcess)
+
+	// seeded processes carry no event status, so there is nothing in flight
+	req, _ := http.NewRequest("POST", "/process/id1/cancel", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, testPublisher.All())
+}
+
+func TestCancelProcess(t *testing.T) {
+	corId := "cancel1"
+	event := "imageCreate"
+	eventStatus := "imageCreating"
+	creatorName := "Ama"
+	processController.ProcessCollection.InsertProcess(&models.Process{
+		Timestamp:   models.NewProcessTimestamp(time.Now().Add(-time.Minute)),
+		CorId:       &corId,
+		Event:       &event,
+		EventStatus: &eventStatus,
+		CreatorName: &creatorName,
+	})
+	testPublisher.Reset()
+
+	r := gin.Default()
+
+	r.POST("/process/:corId/cancel", processController.CancelProcess)
+
+	req, _ := http.NewRequest("POST", "/process/cancel1/cancel", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Check the message that was published
+	messages := testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_PROCESS_CANCEL)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, corId, messages[0].CorrelationId)
+	assert.Equal(t, mq.EVENT_PROCESS_CANCEL, messages[0].Type)
+
+	var published CancelProcessMessage
+	json.Unmarshal(mq.UnwrapPayload(messages[0].Body), &published)
+	assert.Equal(t, event, published.Event)
+	assert.Equal(t, models.EventStatusCancelRequested, published.EventStatus)
+
+	// the request shows on the timeline
+	latest, _, err := processController.ProcessCollection.GetLatestStatusByCorId(corId)
+	assert.NoError(t, err)
+	assert.Equal(t, models.EventStatusCancelRequested, *latest.EventStatus)
+
+	// and cannot be made twice
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Len(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_PROCESS_CANCEL), 1)
+}
+
+func TestStreamProcess(t *testing.T) {
+	corId := "stream1"
+	event := "imageCreate"
+	eventStatus := "imageCreated"
+	processController.ProcessCollection.InsertProcess(&models.Process{
+		Timestamp:   models.NewProcessTimestamp(time.Now()),
+		CorId:       &corId,
+		Event:       &event,
+		EventStatus: &eventStatus,
+	})
+
+	r := gin.Default()
+
+	r.GET("/process/:corId/stream", processController.StreamProcess)
+
+	req, _ := http.NewRequest("GET", "/process/stream1/stream", nil)
+
+	w := httptest.NewRecorder()
+
+	// returns once the terminal status has been sent
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "event: process\n")
+	assert.Contains(t, w.Body.String(), `"eventStatus":"imageCreated"`)
+}
+
+func TestStreamProcess_BadLastEventId(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process/:corId/stream", processController.StreamProcess)
+
+	req, _ := http.NewRequest("GET", "/process/stream1/stream", nil)
+	req.Header.Set("Last-Event-ID", "xyxy")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestFeedProcessesByCreatorName(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process/name/:creatorName/feed", processController.FeedProcessesByCreatorName)
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + server.URL[len("http"):] + "/process/name/Carol/feed?events=imageCreate"
+	ws, err := websocket.Dial(url, "", server.URL)
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	var message FeedMessage
+	assert.NoError(t, websocket.JSON.Receive(ws, &message))
+	assert.Equal(t, FEED_MESSAGE_SUBSCRIBED, message.Type)
+	assert.Equal(t, []string{"imageCreate"}, message.Events)
+
+	assert.NoError(t, websocket.JSON.Send(ws, FeedCommand{Action: FEED_ACTION_SUBSCRIBE, Events: []string{"xyxy"}}))
+	assert.NoError(t, websocket.JSON.Receive(ws, &message))
+	assert.Equal(t, FEED_MESSAGE_ERROR, message.Type)
+
+	corId := "feed1"
+	creatorName := "Carol"
+	event := "imageCreate"
+	eventStatus := "imageCreated"
+	processController.ProcessCollection.InsertProcess(&models.Process{
+		Timestamp:   models.NewProcessTimestamp(time.Now()),
+		CorId:       &corId,
+		Event:       &event,
+		EventStatus: &eventStatus,
+		CreatorName: &creatorName,
+	})
+
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	assert.NoError(t, websocket.JSON.Receive(ws, &message))
+	assert.Equal(t, FEED_MESSAGE_PROCESS, message.Type)
+}
+
+func TestFeedProcessesByCreatorName_Origin(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process/name/:creatorName/feed", processController.FeedProcessesByCreatorName)
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + server.URL[len("http"):] + "/process/name/Carol/feed"
+
+	// pages on other sites cannot open a feed
+	_, err := websocket.Dial(url, "", "https://evil.example")
+	assert.Error(t, err)
+
+	// unless the origin is allowed
+	defer func(origins string) { configs.FEED_ALLOWED_ORIGINS = origins }(configs.FEED_ALLOWED_ORIGINS)
+	configs.FEED_ALLOWED_ORIGINS = "https://console.example, https://evil.example/"
+
+	ws, err := websocket.Dial(url, "", "https://evil.example")
+	assert.NoError(t, err)
+	ws.Close()
+}
+
+func TestGetProcessTimeline(t *testing.T) {
+	corId := "timeline1"
+	event := "imageCreate"
+	start := time.Now().Add(-time.Minute)
+	for i, eventStatus := range []string{"imageCreating", "imageCreated"} {
+		status := eventStatus
+		processController.ProcessCollection.InsertProcess(&models.Process{
+			Timestamp:   models.NewProcessTimestamp(start.Add(time.Duration(i) * 30 * time.Second)),
+			CorId:       &corId,
+			Event:       &event,
+			EventStatus: &status,
+		})
+	}
+
+	r := gin.Default()
+
+	r.GET("/process/:corId/timeline", processController.GetProcessTimeline)
+
+	req, _ := http.NewRequest("GET", "/process/timeline1/timeline", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var timeline models.ProcessTimeline
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &timeline))
+	assert.Equal(t, "imageCreated", timeline.CurrentStatus)
+	assert.True(t, timeline.Terminal)
+	assert.False(t, timeline.Stuck)
+	assert.Len(t, timeline.Steps, 2)
+	assert.Equal(t, int64(30000), timeline.Steps[0].DurationMs)
+	assert.Equal(t, int64(30000), timeline.ElapsedMs)
+}
+
+func TestGetProcessStates(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process/states", processController.GetProcessStates)
+
+	req, _ := http.NewRequest("GET", "/process/states", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var machines []models.ProcessStateMachine
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &machines))
+	assert.Len(t, machines, 3)
+	assert.Equal(t, "imageCreate", machines[0].Event)
+	assert.Equal(t, "imageCreating", machines[0].Initial)
+}
+
+func TestGetAllProcesses_Filtered(t *testing.T) {
+	seed_processes()
+
+	r := gin.Default()
+
+	r.GET("/process", processController.GetAllProcesses)
+
+	req, _ := http.NewRequest("GET", "/process?creatorName=Bob", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"creatorName":"Bob"`)
+	assert.NotContains(t, w.Body.String(), `"creatorName":"Ama"`)
+}
+
+func TestGetAllProcesses_Paginated(t *testing.T) {
+	seed_processes()
+
+	r := gin.Default()
+
+	r.GET("/process", processController.GetAllProcesses)
+
+	req, _ := http.NewRequest("GET", "/process?limit=1", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var first []models.Process
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Len(t, first, 1)
+
+	cursor := w.Header().Get("X-Next-Cursor")
+	assert.NotEmpty(t, cursor)
+
+	req, _ = http.NewRequest("GET", "/process?limit=1&cursor="+cursor, nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	var second []models.Process
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Len(t, second, 1)
+	assert.NotEqual(t, *first[0].CorId, *second[0].CorId)
+}
+
+func TestGetAllProcesses_BadQuery(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process", processController.GetAllProcesses)
+
+	req, _ := http.NewRequest("GET", "/process?from=yesterday", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetProcessStats(t *testing.T) {
+	corId := "stats1"
+	creatorName := "Stan"
+	event := "imageCreate"
+	start := time.Now().Add(-time.Minute)
+	for i, eventStatus := range []string{"imageCreating", "imageCreated"} {
+		status := eventStatus
+		process := models.Process{
+			Timestamp:   models.NewProcessTimestamp(start.Add(time.Duration(i) * 20 * time.Second)),
+			CorId:       &corId,
+			Event:       &event,
+			EventStatus: &status,
+		}
+		// the reply does not repeat the creator
+		if i == 0 {
+			process.CreatorName = &creatorName
+		}
+		processController.ProcessCollection.InsertProcess(&process)
+	}
+
+	r := gin.Default()
+
+	r.GET("/process/stats", processController.GetProcessStats)
+
+	req, _ := http.NewRequest("GET", "/process/stats?creatorName=Stan", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var stats models.ProcessStats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Len(t, stats.Events, 1)
+	assert.Equal(t, int64(1), stats.Events[0].Succeeded)
+	assert.Equal(t, 1.0, stats.Events[0].SuccessRate)
+	assert.Equal(t, 20000.0, stats.Events[0].LatencyMs.P50)
+	assert.Len(t, stats.Daily, 1)
+}
+
+func TestGetProcessStats_BadRange(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process/stats", processController.GetProcessStats)
+
+	req, _ := http.NewRequest("GET", "/process/stats?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetProcessStatusByCorId_WaitFor(t *testing.T) {
+	corId := "wait1"
+	event := "imageCreate"
+	creating := "imageCreating"
+	processController.ProcessCollection.InsertProcess(&models.Process{
+		Timestamp:   models.NewProcessTimestamp(time.Now()),
+		CorId:       &corId,
+		Event:       &event,
+		EventStatus: &creating,
+	})
+
+	r := gin.Default()
+
+	r.GET("/process/:corId", processController.GetProcessStatusByCorId)
+
+	// nothing happens within the timeout
+	req, _ := http.NewRequest("GET", "/process/wait1?waitFor=imageCreated&timeout=1", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), `"eventStatus":"imageCreating"`)
+
+	// the status is reached while waiting
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		created := "imageCreated"
+		processController.ProcessCollection.InsertProcess(&models.Process{
+			Timestamp:   models.NewProcessTimestamp(time.Now()),
+			CorId:       &corId,
+			Event:       &event,
+			EventStatus: &created,
+		})
+	}()
+
+	req, _ = http.NewRequest("GET", "/process/wait1?waitFor=imageCreated&timeout=10s", nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"eventStatus":"imageCreated"`)
+	assert.Contains(t, w.Body.String(), `"outcome":"succeeded"`)
+}
+
+func TestGetProcessStatusByCorId_BadWaitFor(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process/:corId", processController.GetProcessStatusByCorId)
+
+	req, _ := http.NewRequest("GET", "/process/wait1?waitFor=xyxy", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetProcessByCorId_SinceUntil(t *testing.T) {
+	corId := "range1"
+	event := "imageCreate"
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, eventStatus := range []string{"imageCreating", "imageCreated"} {
+		eventStatus := eventStatus
+		processController.ProcessCollection.InsertProcess(&models.Process{
+			Timestamp:   models.NewProcessTimestamp(start.Add(time.Duration(i) * time.Minute)),
+			CorId:       &corId,
+			Event:       &event,
+			EventStatus: &eventStatus,
+		})
+	}
+
+	r := gin.Default()
+
+	r.GET("/process/:corId", processController.GetProcessByCorID)
+
+	req, _ := http.NewRequest("GET", "/process/range1?since=2024-01-01T12:00:30Z&until=2024-01-01T13:00:00Z", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"timestamp":"2024-01-01T12:01:00Z"`)
+	assert.NotContains(t, w.Body.String(), `"eventStatus":"imageCreating"`)
+}
+
+func TestGetProcessByCorId_BadSince(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/process/:corId", processController.GetProcessByCorID)
+
+	req, _ := http.NewRequest("GET", "/process/range1?since=yesterday", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
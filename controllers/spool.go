@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+	"platform_api/mq"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SpoolController struct {
+	Spool *mq.Spool
+}
+
+func NewSpoolController(spool *mq.Spool) *SpoolController {
+	return &SpoolController{Spool: spool}
+}
+
+// GetSpoolStats godoc
+//
+//	@Summary		Retrieve MQ spool statistics
+//	@Description	Get the number and size of publishes spooled to disk while RabbitMQ was unreachable, and the age of the oldest one
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	mq.SpoolStats
+//	@Router			/admin/spool [get]
+func (t SpoolController) GetSpoolStats(c *gin.Context) {
+	c.JSON(http.StatusOK, t.Spool.Stats())
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// how long a browser waits before reconnecting a dropped stream
+	streamRetry = 3 * time.Second
+
+	// comment lines keep idle streams open through proxies
+	streamHeartbeat = 15 * time.Second
+)
+
+// streamPollInterval is how often streams poll Mongo without change streams
+func streamPollInterval() time.Duration {
+	interval, err := time.ParseDuration(configs.PROCESS_STREAM_POLL_INTERVAL)
+	if err != nil || interval <= 0 {
+		return time.Second
+	}
+	return interval
+}
+
+// StreamProcess godoc
+//
+//	@Summary		Streams the status of a process
+//	@Description	Server-Sent Events stream with one "process" event per new process record of the Correlation ID. The stream ends after a terminal status. Send the last received event id in the Last-Event-ID header to resume.
+//	@Tags			processes
+//	@Produce		text/event-stream
+//	@Param			corId			path		string	true	"Correlation ID"
+//	@Param			Last-Event-ID	header		string	false	"Id of the last event received"
+//	@Success		200				{object}	models.ProcessEvent
+//	@Success		204				"Process already finished before Last-Event-ID"
+//	@Failure		400				{object}	models.HTTPError
+//	@Failure		500				{object}	models.HTTPError
+//	@Router			/process/{corId}/stream [get]
+func (t ProcessController) StreamProcess(c *gin.Context) {
+	corId := c.Param("corId")
+
+	// EventSource sends the header on reconnect, the query parameter is for
+	// clients that cannot set headers
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("lastEventId")
+	}
+
+	var after primitive.ObjectID
+	if lastEventId != "" {
+		var err error
+		after, err = primitive.ObjectIDFromHex(lastEventId)
+		if err != nil {
+			handleError(
+				c,
+				http.StatusBadRequest,
+				"Invalid Last-Event-ID",
+				err,
+			)
+			return
+		}
+
+		// nothing left to send once the client has seen the end
+		latest, statusCode, err := t.ProcessCollection.GetLatestEventByCorId(corId)
+		if err != nil && statusCode != http.StatusNotFound {
+			handleError(
+				c,
+				statusCode,
+				"Failed to retrieve process",
+				err,
+			)
+			return
+		}
+		if latest != nil && isTerminalEvent(latest) && bytes.Compare(latest.Id[:], after[:]) <= 0 {
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	events := t.ProcessCollection.Follow(ctx, bson.M{"corId": corId}, after, streamPollInterval())
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetry.Milliseconds())
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %s\nevent: process\ndata: %s\n\n", event.Id.Hex(), data)
+			c.Writer.Flush()
+
+			if isTerminalEvent(&event) {
+				return
+			}
+		}
+	}
+}
+
+// isTerminalEvent reports whether a process record ends its process
+func isTerminalEvent(event *models.ProcessEvent) bool {
+	return event.EventStatus != nil && models.IsTerminalStatus(*event.EventStatus)
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"platform_api/collections"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultSweptLimit = 100
+	maxSweptLimit     = 1000
+)
+
+type SweepController struct {
+	SweptProcessCollection collections.SweptProcessCollection
+}
+
+func NewSweepController(client *mongo.Client) *SweepController {
+	return &SweepController{SweptProcessCollection: *collections.NewSweptProcessCollection(client)}
+}
+
+// GetSweptProcesses godoc
+//
+//	@Summary		Retrieve timed out processes
+//	@Description	Get the processes the sweeper timed out because their status had not changed for too long, newest first
+//	@Tags			admin
+//	@Produce		json
+//	@Param			limit	query		int	false	"Maximum number of processes (default 100, at most 1000)"
+//	@Success		200		{array}		models.SweptProcess
+//	@Failure		400		{object}	models.HTTPError
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/admin/sweeper [get]
+func (t SweepController) GetSweptProcesses(c *gin.Context) {
+	limit := int64(defaultSweptLimit)
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxSweptLimit {
+			handleError(
+				c,
+				http.StatusBadRequest,
+				"Invalid limit",
+				errors.New("limit must be between 1 and 1000"),
+			)
+			return
+		}
+		limit = parsed
+	}
+
+	swept, statusCode, err := t.SweptProcessCollection.GetSweptProcesses(limit)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve timed out processes",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *swept)
+}
@@ -0,0 +1,58 @@
+// +build integration
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"platform_api/configs"
+	"platform_api/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var sweepController = NewSweepController(configs.Client)
+
+func seed_swept_processes() {
+	sweepController.SweptProcessCollection.InsertSweptProcess(&models.SweptProcess{
+		CorId:          "swept1",
+		Event:          "imageCreate",
+		PreviousStatus: "imageCreating",
+		CreatorName:    "Ama",
+		LastUpdatedAt:  time.Now().Add(-time.Hour).UTC(),
+	})
+}
+
+func TestGetSweptProcesses(t *testing.T) {
+	seed_swept_processes()
+
+	r := gin.Default()
+
+	r.GET("/admin/sweeper", sweepController.GetSweptProcesses)
+
+	req, _ := http.NewRequest("GET", "/admin/sweeper?limit=10", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"corId":"swept1"`)
+}
+
+func TestGetSweptProcesses_BadLimit(t *testing.T) {
+	r := gin.Default()
+
+	r.GET("/admin/sweeper", sweepController.GetSweptProcesses)
+
+	req, _ := http.NewRequest("GET", "/admin/sweeper?limit=0", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
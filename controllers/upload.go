@@ -0,0 +1,918 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/configs"
+	"platform_api/models"
+	"platform_api/mq"
+	"platform_api/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// version of the tus resumable upload protocol, https://tus.io/protocols/resumable-upload
+	TUS_VERSION    = "1.0.0"
+	TUS_EXTENSIONS = "creation,termination,expiration"
+
+	// how long an upload can be resumed after its last chunk
+	uploadExpiry = 24 * time.Hour
+
+	// how long storing a single chunk may take
+	uploadChunkTimeout = 30 * time.Minute
+
+	// how long assembling, validating and queueing a complete upload may take
+	// before another request may try again
+	uploadCompleteTimeout = 10 * time.Minute
+
+	// the most parts an upload is assembled from, Cloud Storage composes an
+	// object of at most 1024 components
+	uploadMaxParts = 1024
+)
+
+// TUS_HEADERS are read and written by tus clients, browsers need them allowed
+// and exposed by CORS
+var TUS_HEADERS = []string{
+	"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+	"Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Expires",
+	"Location",
+}
+
+type UploadController struct {
+	ImageService      collections.ImageCollection
+	SessionCollection collections.UploadSessionCollection
+	SignedCollection  collections.SignedUploadCollection
+	OutboxCollection  collections.OutboxCollection
+	Publisher         mq.Publisher
+}
+
+func NewUploadController(client *mongo.Client, publisher mq.Publisher) *UploadController {
+	return &UploadController{
+		ImageService:      *collections.NewImageCollection(client),
+		SessionCollection: *collections.NewUploadSessionCollection(client),
+		SignedCollection:  *collections.NewSignedUploadCollection(client),
+		OutboxCollection:  *collections.NewOutboxCollection(client),
+		Publisher:         publisher,
+	}
+}
+
+// uploadMinChunkSize keeps an upload within uploadMaxParts, every chunk but
+// the last must be at least this large
+func uploadMinChunkSize(session *models.UploadSession) int64 {
+	return (session.Length + uploadMaxParts - 2) / (uploadMaxParts - 1)
+}
+
+// uploadPartPath is where a chunk is kept until its upload is complete. Every
+// write gets its own object, a request that loses the race for an offset
+// then deletes only what it wrote.
+func uploadPartPath(session *models.UploadSession, offset int64) string {
+	return fmt.Sprintf("uploads/%s/%020d-%s", session.Id.Hex(), offset, primitive.NewObjectID().Hex())
+}
+
+// checkTusResumable rejects requests of other protocol versions
+func checkTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", TUS_VERSION)
+	if c.GetHeader("Tus-Resumable") == TUS_VERSION {
+		return true
+	}
+
+	c.Header("Tus-Version", TUS_VERSION)
+	handleError(
+		c,
+		http.StatusPreconditionFailed,
+		"Unsupported tus version",
+		fmt.Errorf("Tus-Resumable must be %s", TUS_VERSION),
+	)
+	return false
+}
+
+// parseUploadMetadata reads the Upload-Metadata header, comma separated
+// pairs of a key and a base64 encoded value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value of %s", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func setUploadHeaders(c *gin.Context, session *models.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	if session.Status == models.UploadStatusUploading {
+		c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// GetUploadOptions godoc
+//
+//	@Summary		Describe the resumable upload protocol
+//	@Description	Report the tus version, extensions and maximum archive size supported by resumable uploads
+//	@Tags			uploads
+//	@Success		204
+//	@Header			204	{string}	Tus-Version		"Supported tus versions"
+//	@Header			204	{string}	Tus-Extension	"Supported tus extensions"
+//	@Header			204	{string}	Tus-Max-Size	"Largest archive in bytes"
+//	@Router			/image/uploads [options]
+func (t UploadController) GetUploadOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", TUS_VERSION)
+	c.Header("Tus-Version", TUS_VERSION)
+	c.Header("Tus-Extension", TUS_EXTENSIONS)
+	c.Header("Tus-Max-Size", strconv.FormatInt(configs.ARCHIVE_LIMITS.MaxSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload godoc
+//
+//	@Summary		Start a resumable upload
+//	@Description	Create a tus upload session for a challenge archive. Upload-Metadata must carry imageName, creatorName and imageTag, and may carry filename. Chunks are then PATCHed to the Location, and the image is built once the archive is complete and valid.
+//	@Tags			uploads
+//	@Produce		json
+//	@Param			Tus-Resumable	header		string	true	"1.0.0"
+//	@Param			Upload-Length	header		int		true	"Size of the archive in bytes"
+//	@Param			Upload-Metadata	header		string	true	"Comma separated keys and base64 values"
+//	@Success		201				{object}	models.UploadSession
+//	@Header			201				{string}	Location	"URL of the upload"
+//	@Failure		400				{object}	models.HTTPError
+//	@Failure		412				{object}	models.HTTPError	"Unsupported tus version"
+//	@Failure		413				{object}	models.HTTPError	"Archive is too large"
+//	@Failure		500				{object}	models.HTTPError
+//	@Router			/image/uploads [post]
+func (t UploadController) CreateUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	// deferred lengths are not supported, the size limit is checked up front
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid Upload-Length",
+			errors.New("Upload-Length must be a positive number of bytes"),
+		)
+		return
+	}
+	if length > configs.ARCHIVE_LIMITS.MaxSize {
+		handleError(
+			c,
+			http.StatusRequestEntityTooLarge,
+			"Archive is too large",
+			fmt.Errorf("archive is %d bytes, at most %d are allowed", length, configs.ARCHIVE_LIMITS.MaxSize),
+		)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid Upload-Metadata",
+			err,
+		)
+		return
+	}
+
+	session := models.UploadSession{
+		CorId:       uuid.New().String(),
+		CreatorName: metadata["creatorName"],
+		ImageName:   metadata["imageName"],
+		ImageTag:    metadata["imageTag"],
+		FileName:    metadata["filename"],
+		Length:      length,
+		ExpiresAt:   time.Now().Add(uploadExpiry).UTC(),
+	}
+
+	statusCode, err := t.ImageService.CheckImageByImageAndCreatorName(session.ImageName, session.ImageTag, session.CreatorName)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Error",
+			err,
+		)
+		return
+	}
+
+	statusCode, err = t.SessionCollection.InsertSession(&session)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to create upload",
+			err,
+		)
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+session.Id.Hex())
+	setUploadHeaders(c, &session)
+	c.JSON(statusCode, session)
+}
+
+// GetUploadOffset godoc
+//
+//	@Summary		Retrieve the offset of a resumable upload
+//	@Description	Report how many bytes of the archive were stored, uploads resume from there
+//	@Tags			uploads
+//	@Param			Tus-Resumable	header	string	true	"1.0.0"
+//	@Param			id				path	string	true	"Upload ID"
+//	@Success		200
+//	@Header			200	{int}	Upload-Offset	"Bytes stored"
+//	@Header			200	{int}	Upload-Length	"Size of the archive"
+//	@Failure		404	{object}	models.HTTPError
+//	@Failure		410	{object}	models.HTTPError	"Upload has expired"
+//	@Router			/image/uploads/{id} [head]
+func (t UploadController) GetUploadOffset(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	session, statusCode, err := t.SessionCollection.GetSessionById(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve upload",
+			err,
+		)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, session)
+	c.Status(http.StatusOK)
+}
+
+// GetUpload godoc
+//
+//	@Summary		Retrieve a resumable upload
+//	@Description	Get the progress of an upload, the correlation ID of its image and, when the archive was rejected, its violations
+//	@Tags			uploads
+//	@Produce		json
+//	@Param			id	path		string	true	"Upload ID"
+//	@Success		200	{object}	models.UploadSession
+//	@Failure		404	{object}	models.HTTPError
+//	@Failure		410	{object}	models.HTTPError	"Upload has expired"
+//	@Router			/image/uploads/{id} [get]
+func (t UploadController) GetUpload(c *gin.Context) {
+	session, statusCode, err := t.SessionCollection.GetSessionById(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve upload",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *session)
+}
+
+// PatchUpload godoc
+//
+//	@Summary		Upload a chunk of a resumable upload
+//	@Description	Store the body at Upload-Offset, which must be the current offset. Every chunk but the last must hold at least 1/1023 of the archive, so that it is assembled from at most 1024 parts; shorter chunks are discarded with a 400. Whatever arrives before a disconnect is kept within the same rule. The chunk that completes the archive validates it and starts the image creation; a complete upload whose image creation failed to start is retried with an empty chunk at the final offset.
+//	@Tags			uploads
+//	@Accept			application/offset+octet-stream
+//	@Param			Tus-Resumable	header	string	true	"1.0.0"
+//	@Param			Upload-Offset	header	int		true	"Offset of the chunk"
+//	@Param			id				path	string	true	"Upload ID"
+//	@Success		204
+//	@Header			204	{int}	Upload-Offset	"Bytes stored"
+//	@Failure		400	{object}	models.ArchiveValidationError	"Invalid request or archive"
+//	@Failure		404	{object}	models.HTTPError
+//	@Failure		409	{object}	models.HTTPError	"Offset does not match, or the upload is being completed or finished"
+//	@Failure		410	{object}	models.HTTPError	"Upload has expired"
+//	@Failure		415	{object}	models.HTTPError
+//	@Failure		500	{object}	models.HTTPError
+//	@Failure		503	{object}	models.HTTPError	"Message was nacked or could not be routed"
+//	@Router			/image/uploads/{id} [patch]
+func (t UploadController) PatchUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		handleError(
+			c,
+			http.StatusUnsupportedMediaType,
+			"Invalid Content-Type",
+			errors.New("chunks must be sent as application/offset+octet-stream"),
+		)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid Upload-Offset",
+			errors.New("Upload-Offset must be a number of bytes"),
+		)
+		return
+	}
+
+	session, statusCode, err := t.SessionCollection.GetSessionById(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve upload",
+			err,
+		)
+		return
+	}
+	// a complete upload may be claimed by a request that never finished it
+	if session.Status != models.UploadStatusUploading && session.Status != models.UploadStatusCompleting {
+		handleError(
+			c,
+			http.StatusConflict,
+			"Upload is finished",
+			fmt.Errorf("upload is %s", session.Status),
+		)
+		return
+	}
+	if offset != session.Offset {
+		setUploadHeaders(c, session)
+		handleError(
+			c,
+			http.StatusConflict,
+			"Upload-Offset does not match",
+			fmt.Errorf("upload is at offset %d", session.Offset),
+		)
+		return
+	}
+
+	if offset < session.Length {
+		statusCode, err = t.storeChunk(c, session, offset)
+		if err != nil {
+			handleError(
+				c,
+				statusCode,
+				"Failed to store chunk",
+				err,
+			)
+			return
+		}
+	}
+
+	if session.Offset == session.Length {
+		t.completeUpload(c, session)
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.Status(http.StatusNoContent)
+}
+
+// storeChunk stores the request body as the part at offset
+func (t UploadController) storeChunk(c *gin.Context, session *models.UploadSession, offset int64) (int, error) {
+	uploader := services.GetUploader()
+	part := uploadPartPath(session, offset)
+
+	// not tied to the request, what arrived before a disconnect is kept
+	ctx, cancel := context.WithTimeout(context.Background(), uploadChunkTimeout)
+	defer cancel()
+
+	n, readErr := uploader.WriteObject(ctx, io.LimitReader(c.Request.Body, session.Length-offset), part)
+	if readErr != nil {
+		log.Printf("Upload %s was interrupted after %d bytes: %s", session.Id.Hex(), n, readErr)
+	}
+	if n == 0 {
+		uploader.DeleteFile(part)
+		if readErr != nil {
+			return http.StatusBadRequest, readErr
+		}
+		return http.StatusOK, nil
+	}
+	if minSize := uploadMinChunkSize(session); n < minSize && offset+n < session.Length {
+		uploader.DeleteFile(part)
+		if readErr != nil {
+			return http.StatusBadRequest, readErr
+		}
+		return http.StatusBadRequest, fmt.Errorf("chunks must be at least %d bytes, except the last", minSize)
+	}
+
+	statusCode, err := t.SessionCollection.AppendPart(session, offset, part, n, time.Now().Add(uploadExpiry))
+	if err != nil {
+		// another request stored this offset first
+		uploader.DeleteFile(part)
+		return statusCode, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// completeUpload assembles the archive, validates it and starts the image
+// creation. The session is claimed first so that a retry racing the request
+// that completed it does not build the image twice. It is finished once the
+// image creation is queued, until then an empty chunk at the final offset
+// tries again.
+func (t UploadController) completeUpload(c *gin.Context, session *models.UploadSession) {
+	uploader := services.GetUploader()
+	setUploadHeaders(c, session)
+
+	statusCode, err := t.SessionCollection.ClaimSession(session, time.Now().Add(uploadCompleteTimeout))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to complete upload",
+			err,
+		)
+		return
+	}
+
+	req := UploadImageMessage{
+		ImageName:   session.ImageName,
+		CreatorName: session.CreatorName,
+		ImageTag:    session.ImageTag,
+		S3Path:      imageObjectPath(session.CreatorName, session.CorId),
+		CorID:       session.CorId,
+	}
+
+	err = uploader.ComposeFiles(req.S3Path, session.Parts)
+	if err != nil {
+		t.releaseSession(session)
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to assemble the archive",
+			err,
+		)
+		return
+	}
+
+	violations, err := services.ValidateArchiveObject(uploader, req.S3Path, configs.ARCHIVE_LIMITS)
+	if err != nil {
+		t.releaseSession(session)
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to validate the archive",
+			err,
+		)
+		return
+	}
+
+	if len(violations) > 0 {
+		statusCode, err := t.SessionCollection.FinishSession(session, models.UploadStatusFailed, violations)
+		if err != nil {
+			t.releaseSession(session)
+			handleError(
+				c,
+				statusCode,
+				"Failed to finish upload",
+				err,
+			)
+			return
+		}
+
+		t.deleteParts(session)
+		err = uploader.DeleteFile(req.S3Path)
+		if err != nil {
+			log.Printf("Failed to delete rejected archive %s: %s", req.S3Path, err)
+		}
+
+		log.Printf("Rejected upload %s of %s with %d violations", session.Id.Hex(), session.CreatorName, len(violations))
+		c.JSON(http.StatusBadRequest, models.ArchiveValidationError{
+			HTTPError: models.HTTPError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid archive",
+				Error:   violations[0].Message,
+			},
+			Violations: violations,
+		})
+		return
+	}
+
+	log.Printf("Assembled upload %s to %s", session.Id.Hex(), req.S3Path)
+
+	statusCode, err = startImageCreate(t.Publisher, t.OutboxCollection, req)
+	if err != nil {
+		t.releaseSession(session)
+		handleError(
+			c,
+			statusCode,
+			"Failed to queue message",
+			err,
+		)
+		return
+	}
+
+	// the image creation is queued, a failure here only leaves the session
+	// claimed until a retry may queue it again
+	_, err = t.SessionCollection.FinishSession(session, models.UploadStatusCompleted, nil)
+	if err != nil {
+		log.Printf("Failed to finish upload %s: %s", session.Id.Hex(), err)
+	} else {
+		t.deleteParts(session)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// releaseSession lets a retry complete an upload this request could not
+func (t UploadController) releaseSession(session *models.UploadSession) {
+	_, err := t.SessionCollection.ReleaseSession(session)
+	if err != nil {
+		log.Printf("Failed to release upload %s: %s", session.Id.Hex(), err)
+	}
+}
+
+// deleteParts removes the chunks of a finished upload
+func (t UploadController) deleteParts(session *models.UploadSession) {
+	uploader := services.GetUploader()
+	for _, part := range session.Parts {
+		err := uploader.DeleteFile(part)
+		if err != nil {
+			log.Printf("Failed to delete part %s of upload %s: %s", part, session.Id.Hex(), err)
+		}
+	}
+}
+
+// DeleteUpload godoc
+//
+//	@Summary		Terminate a resumable upload
+//	@Description	Give up an upload and delete the chunks stored so far, the archive of a complete upload is kept
+//	@Tags			uploads
+//	@Param			Tus-Resumable	header	string	true	"1.0.0"
+//	@Param			id				path	string	true	"Upload ID"
+//	@Success		204
+//	@Failure		404	{object}	models.HTTPError
+//	@Failure		410	{object}	models.HTTPError	"Upload has expired"
+//	@Router			/image/uploads/{id} [delete]
+func (t UploadController) DeleteUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	session, statusCode, err := t.SessionCollection.GetSessionById(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve upload",
+			err,
+		)
+		return
+	}
+
+	if session.Status == models.UploadStatusUploading {
+		uploader := services.GetUploader()
+		for _, part := range session.Parts {
+			err = uploader.DeleteFile(part)
+			if err != nil {
+				handleError(
+					c,
+					http.StatusInternalServerError,
+					"Failed to delete upload",
+					err,
+				)
+				return
+			}
+		}
+	}
+
+	statusCode, err = t.SessionCollection.DeleteSession(session)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to delete upload",
+			err,
+		)
+		return
+	}
+
+	c.Status(statusCode)
+}
+
+// signedUploadPath is where a client PUTs the archive of a signed upload. It
+// is copied to the path of the image before it is validated, the client can
+// never write the object that is built.
+func signedUploadPath(upload *models.SignedUpload) string {
+	return fmt.Sprintf("uploads/signed/%s.zip", upload.CorId)
+}
+
+// uploadURLExpiry is how long a signed upload URL can be used
+func uploadURLExpiry() time.Duration {
+	expiry, err := time.ParseDuration(configs.UPLOAD_URL_EXPIRY)
+	if err != nil || expiry <= 0 {
+		return 15 * time.Minute
+	}
+	return expiry
+}
+
+// CreateUploadURL godoc
+//
+//	@Summary		Request a signed upload URL
+//	@Description	Validate the image like an upload would and return a time-limited signed URL to PUT the challenge archive straight to the bucket, with the returned headers. The archive can be PUT once. Confirm the upload afterwards to build the image.
+//	@Tags			uploads
+//	@Accept			json
+//	@Produce		json
+//	@Param			upload	body		models.SignedUpload	true	"imageName, creatorName and imageTag"
+//	@Success		201		{object}	models.SignedUploadURL
+//	@Failure		400		{object}	models.HTTPError
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/image/upload-url [post]
+func (t UploadController) CreateUploadURL(c *gin.Context) {
+	var req models.SignedUpload
+	err := c.BindJSON(&req)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid request body json",
+			err,
+		)
+		return
+	}
+
+	// validate json
+	v := validator.New()
+	err = v.Struct(req)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid request body",
+			err,
+		)
+		return
+	}
+
+	statusCode, err := t.ImageService.CheckImageByImageAndCreatorName(req.ImageName, req.ImageTag, req.CreatorName)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Error",
+			err,
+		)
+		return
+	}
+
+	upload := models.SignedUpload{
+		CorId:       uuid.New().String(),
+		CreatorName: req.CreatorName,
+		ImageName:   req.ImageName,
+		ImageTag:    req.ImageTag,
+		ExpiresAt:   time.Now().Add(uploadURLExpiry()).UTC(),
+	}
+	upload.S3Path = imageObjectPath(upload.CreatorName, upload.CorId)
+
+	url, headers, err := services.GetUploader().SignedUploadURL(signedUploadPath(&upload), "application/zip", configs.ARCHIVE_LIMITS.MaxSize, upload.ExpiresAt)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to sign upload URL",
+			err,
+		)
+		return
+	}
+
+	statusCode, err = t.SignedCollection.InsertSignedUpload(&upload)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to create upload",
+			err,
+		)
+		return
+	}
+
+	log.Printf("Signed upload URL for %s of %s until %s", upload.CorId, upload.CreatorName, upload.ExpiresAt)
+
+	c.JSON(statusCode, models.SignedUploadURL{
+		CorId:     upload.CorId,
+		URL:       url,
+		Method:    http.MethodPut,
+		Headers:   headers,
+		ExpiresAt: upload.ExpiresAt,
+	})
+}
+
+// ConfirmUpload godoc
+//
+//	@Summary		Confirm a signed upload
+//	@Description	Check that the archive was PUT to the signed URL, copy it to the image and validate the copy, then start building the image. Invalid archives are deleted. An upload whose image creation failed to start is confirmed again.
+//	@Tags			uploads
+//	@Produce		json
+//	@Param			corId	path		string	true	"Correlation ID"
+//	@Success		200		{object}	map[string]string
+//	@Success		202		{object}	map[string]string			"Confirmed, the message will be published once the MQ is reachable"
+//	@Failure		400		{object}	models.ArchiveValidationError
+//	@Failure		404		{object}	models.HTTPError
+//	@Failure		409		{object}	models.HTTPError			"Archive is not uploaded yet, or the upload is being or already confirmed"
+//	@Failure		410		{object}	models.HTTPError			"Upload URL expired before the archive was uploaded"
+//	@Failure		500		{object}	models.HTTPError
+//	@Failure		503		{object}	models.HTTPError			"Message was nacked or could not be routed"
+//	@Router			/image/{corId}/confirm [post]
+func (t UploadController) ConfirmUpload(c *gin.Context) {
+	upload, statusCode, err := t.SignedCollection.GetSignedUploadByCorId(c.Param("corId"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve upload",
+			err,
+		)
+		return
+	}
+	// a pending upload may be claimed by a request that never confirmed it
+	if upload.Status != models.SignedUploadStatusPending && upload.Status != models.SignedUploadStatusConfirming {
+		handleError(
+			c,
+			http.StatusConflict,
+			"Error",
+			fmt.Errorf("upload is already %s", upload.Status),
+		)
+		return
+	}
+
+	// claimed so that a confirmation sent twice builds the image once
+	statusCode, err = t.SignedCollection.ClaimSignedUpload(upload, time.Now().Add(uploadCompleteTimeout))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Error",
+			err,
+		)
+		return
+	}
+
+	uploader := services.GetUploader()
+	staged := signedUploadPath(upload)
+	_, err = uploader.GetFileSize(staged)
+	if err != nil {
+		t.releaseSignedUpload(upload)
+		switch {
+		case !errors.Is(err, services.ErrFileNotFound):
+			handleError(
+				c,
+				http.StatusInternalServerError,
+				"Failed to retrieve archive",
+				err,
+			)
+		case time.Now().After(upload.ExpiresAt):
+			handleError(
+				c,
+				http.StatusGone,
+				"Error",
+				errors.New("upload URL expired before the archive was uploaded"),
+			)
+		default:
+			handleError(
+				c,
+				http.StatusConflict,
+				"Error",
+				errors.New("archive is not uploaded yet"),
+			)
+		}
+		return
+	}
+
+	// another upload may have taken the tag since the URL was signed
+	statusCode, err = t.ImageService.CheckImageByImageAndCreatorName(upload.ImageName, upload.ImageTag, upload.CreatorName)
+	if err != nil {
+		t.releaseSignedUpload(upload)
+		handleError(
+			c,
+			statusCode,
+			"Error",
+			err,
+		)
+		return
+	}
+
+	// what is validated is what gets built
+	err = uploader.ComposeFiles(upload.S3Path, []string{staged})
+	if err != nil {
+		t.releaseSignedUpload(upload)
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to copy the archive",
+			err,
+		)
+		return
+	}
+
+	violations, err := services.ValidateArchiveObject(uploader, upload.S3Path, configs.ARCHIVE_LIMITS)
+	if err != nil {
+		t.releaseSignedUpload(upload)
+		handleError(
+			c,
+			http.StatusInternalServerError,
+			"Failed to validate the archive",
+			err,
+		)
+		return
+	}
+
+	if len(violations) > 0 {
+		statusCode, err = t.SignedCollection.FinishSignedUpload(upload, models.SignedUploadStatusFailed)
+		if err != nil {
+			t.releaseSignedUpload(upload)
+			handleError(
+				c,
+				statusCode,
+				"Failed to confirm upload",
+				err,
+			)
+			return
+		}
+
+		for _, object := range []string{upload.S3Path, staged} {
+			err = uploader.DeleteFile(object)
+			if err != nil {
+				log.Printf("Failed to delete rejected archive %s: %s", object, err)
+			}
+		}
+
+		log.Printf("Rejected signed upload %s of %s with %d violations", upload.CorId, upload.CreatorName, len(violations))
+		c.JSON(http.StatusBadRequest, models.ArchiveValidationError{
+			HTTPError: models.HTTPError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid archive",
+				Error:   violations[0].Message,
+			},
+			Violations: violations,
+		})
+		return
+	}
+
+	log.Printf("Confirmed signed upload %s to %s", upload.CorId, upload.S3Path)
+
+	statusCode, err = startImageCreate(t.Publisher, t.OutboxCollection, UploadImageMessage{
+		ImageName:   upload.ImageName,
+		CreatorName: upload.CreatorName,
+		ImageTag:    upload.ImageTag,
+		S3Path:      upload.S3Path,
+		CorID:       upload.CorId,
+	})
+	if err != nil {
+		t.releaseSignedUpload(upload)
+		handleError(
+			c,
+			statusCode,
+			"Failed to queue message",
+			err,
+		)
+		return
+	}
+
+	// the image creation is queued, a failure here only leaves the upload
+	// claimed until a confirmation may queue it again
+	_, err = t.SignedCollection.FinishSignedUpload(upload, models.SignedUploadStatusConfirmed)
+	if err != nil {
+		log.Printf("Failed to confirm signed upload %s: %s", upload.CorId, err)
+	} else {
+		err = uploader.DeleteFile(staged)
+		if err != nil {
+			log.Printf("Failed to delete staged archive %s: %s", staged, err)
+		}
+	}
+
+	c.JSON(statusCode, gin.H{"corId": upload.CorId})
+}
+
+// releaseSignedUpload lets a confirmation that failed be sent again
+func (t UploadController) releaseSignedUpload(upload *models.SignedUpload) {
+	_, err := t.SignedCollection.ReleaseSignedUpload(upload)
+	if err != nil {
+		log.Printf("Failed to release signed upload %s: %s", upload.CorId, err)
+	}
+}
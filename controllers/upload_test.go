@@ -0,0 +1,559 @@
+// +build integration
+
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"platform_api/configs"
+	"platform_api/models"
+	"platform_api/mq"
+	"platform_api/services"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var uploadController = NewUploadController(configs.Client, testPublisher)
+
+func uploadRouter() *gin.Engine {
+	r := gin.Default()
+
+	r.OPTIONS("/uploads", uploadController.GetUploadOptions)
+	r.POST("/uploads", uploadController.CreateUpload)
+	r.HEAD("/uploads/:id", uploadController.GetUploadOffset)
+	r.GET("/uploads/:id", uploadController.GetUpload)
+	r.PATCH("/uploads/:id", uploadController.PatchUpload)
+	r.POST("/upload-url", uploadController.CreateUploadURL)
+	r.POST("/:corId/confirm", uploadController.ConfirmUpload)
+
+	return r
+}
+
+func createUploadRequest(length int64) *http.Request {
+	metadata := "imageName " + base64.StdEncoding.EncodeToString([]byte("dave1")) +
+		",creatorName " + base64.StdEncoding.EncodeToString([]byte("Dave")) +
+		",imageTag " + base64.StdEncoding.EncodeToString([]byte("v1"))
+
+	req, _ := http.NewRequest("POST", "/uploads", nil)
+	req.Header.Set("Tus-Resumable", TUS_VERSION)
+	req.Header.Set("Upload-Length", strconv.FormatInt(length, 10))
+	req.Header.Set("Upload-Metadata", metadata)
+	return req
+}
+
+func patchUploadRequest(location string, offset int, chunk []byte) *http.Request {
+	req, _ := http.NewRequest("PATCH", location, bytes.NewBuffer(chunk))
+	req.Header.Set("Tus-Resumable", TUS_VERSION)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	return req
+}
+
+// testArchive is a zip with a Dockerfile at its root
+func testArchive() []byte {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	f, _ := zw.Create("Dockerfile")
+	f.Write([]byte("FROM scratch"))
+	zw.Close()
+	return archive.Bytes()
+}
+
+func getUploadSession(r *gin.Engine, location string) models.UploadSession {
+	req, _ := http.NewRequest("GET", location, nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	var session models.UploadSession
+	json.Unmarshal(w.Body.Bytes(), &session)
+	return session
+}
+
+func TestGetUploadOptions(t *testing.T) {
+	r := uploadRouter()
+
+	req, _ := http.NewRequest("OPTIONS", "/uploads", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, TUS_VERSION, w.Header().Get("Tus-Version"))
+	assert.Contains(t, w.Header().Get("Tus-Extension"), "creation")
+}
+
+func TestCreateUpload(t *testing.T) {
+	r := uploadRouter()
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, createUploadRequest(1024))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	location := w.Header().Get("Location")
+	assert.Regexp(t, `^/uploads/[0-9a-f]{24}$`, location)
+	assert.Contains(t, w.Body.String(), `"status":"uploading"`)
+
+	// nothing is stored yet
+	req, _ := http.NewRequest("HEAD", location, nil)
+	req.Header.Set("Tus-Resumable", TUS_VERSION)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "1024", w.Header().Get("Upload-Length"))
+
+	// chunks must start at the current offset
+	req, _ = http.NewRequest("PATCH", location, bytes.NewBufferString("PK"))
+	req.Header.Set("Tus-Resumable", TUS_VERSION)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "512")
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "0", w.Header().Get("Upload-Offset"))
+
+	// and be sent as a tus chunk
+	req, _ = http.NewRequest("PATCH", location, bytes.NewBufferString("PK"))
+	req.Header.Set("Tus-Resumable", TUS_VERSION)
+	req.Header.Set("Content-Type", "application/zip")
+	req.Header.Set("Upload-Offset", "0")
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestCreateUpload_Invalid(t *testing.T) {
+	r := uploadRouter()
+
+	// another protocol version
+	req := createUploadRequest(1024)
+	req.Header.Set("Tus-Resumable", "0.2.2")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, TUS_VERSION, w.Header().Get("Tus-Version"))
+
+	// larger than archives may be
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, createUploadRequest(configs.ARCHIVE_LIMITS.MaxSize+1))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestPatchUpload(t *testing.T) {
+	services.SetUploader(services.NewMemoryUploader())
+	testPublisher.Reset()
+
+	r := uploadRouter()
+	archive := testArchive()
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, createUploadRequest(int64(len(archive))))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	location := w.Header().Get("Location")
+
+	// the first half is stored
+	half := len(archive) / 2
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, 0, archive[:half]))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, strconv.Itoa(half), w.Header().Get("Upload-Offset"))
+
+	// the upload resumes where it stopped
+	req, _ := http.NewRequest("HEAD", location, nil)
+	req.Header.Set("Tus-Resumable", TUS_VERSION)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, strconv.Itoa(half), w.Header().Get("Upload-Offset"))
+
+	// and the last chunk starts the image creation
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, half, archive[half:]))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, strconv.Itoa(len(archive)), w.Header().Get("Upload-Offset"))
+
+	session := getUploadSession(r, location)
+	assert.Equal(t, models.UploadStatusCompleted, session.Status)
+
+	uploader := services.GetUploader().(*services.MemoryUploader)
+	stored, ok := uploader.Object(imageObjectPath("Dave", session.CorId))
+	assert.True(t, ok)
+	assert.Equal(t, archive, stored)
+	assert.Empty(t, uploader.Objects("uploads/"))
+
+	messages := testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, session.CorId, messages[0].CorrelationId)
+}
+
+func TestPatchUpload_RetryImageCreate(t *testing.T) {
+	services.SetUploader(services.NewMemoryUploader())
+	testPublisher.Reset()
+	testPublisher.FailRoute(mq.ROUTE_IMAGE_BUILD, mq.ErrUnroutable)
+	defer testPublisher.Reset()
+
+	r := uploadRouter()
+	archive := testArchive()
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, createUploadRequest(int64(len(archive))))
+
+	location := w.Header().Get("Location")
+
+	// the archive is complete but the image creation cannot be queued
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, 0, archive))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, models.UploadStatusUploading, getUploadSession(r, location).Status)
+
+	// an empty chunk at the final offset tries again
+	testPublisher.FailRoute(mq.ROUTE_IMAGE_BUILD, nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, len(archive), nil))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, models.UploadStatusCompleted, getUploadSession(r, location).Status)
+	assert.Len(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD), 1)
+}
+
+func TestPatchUpload_Completing(t *testing.T) {
+	services.SetUploader(services.NewMemoryUploader())
+	testPublisher.Reset()
+	testPublisher.FailRoute(mq.ROUTE_IMAGE_BUILD, mq.ErrUnroutable)
+	defer testPublisher.Reset()
+
+	r := uploadRouter()
+	archive := testArchive()
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, createUploadRequest(int64(len(archive))))
+
+	location := w.Header().Get("Location")
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, 0, archive))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	testPublisher.FailRoute(mq.ROUTE_IMAGE_BUILD, nil)
+
+	// another request is completing the upload
+	session, _, err := uploadController.SessionCollection.GetSessionById(path.Base(location))
+	assert.NoError(t, err)
+	_, err = uploadController.SessionCollection.ClaimSession(session, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, len(archive), nil))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD))
+
+	// until its claim runs out
+	_, err = uploadController.SessionCollection.ReleaseSession(session)
+	assert.NoError(t, err)
+	_, err = uploadController.SessionCollection.ClaimSession(session, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, len(archive), nil))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, models.UploadStatusCompleted, getUploadSession(r, location).Status)
+	assert.Len(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD), 1)
+}
+
+func TestPatchUpload_SameOffset(t *testing.T) {
+	uploader := services.NewMemoryUploader()
+	services.SetUploader(uploader)
+
+	r := uploadRouter()
+	archive := testArchive()
+	half := len(archive) / 2
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, createUploadRequest(int64(len(archive))))
+
+	location := w.Header().Get("Location")
+	stale, _, err := uploadController.SessionCollection.GetSessionById(path.Base(location))
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, 0, archive[:half]))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// an abandoned request for the same offset finishes after it
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = patchUploadRequest(location, 0, archive[:half])
+	statusCode, err := uploadController.storeChunk(c, stale, 0)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	// and leaves the part that was recorded alone
+	session, _, err := uploadController.SessionCollection.GetSessionById(path.Base(location))
+	assert.NoError(t, err)
+	assert.Len(t, session.Parts, 1)
+	assert.Equal(t, session.Parts, uploader.Objects("uploads/"+session.Id.Hex()+"/"))
+}
+
+func TestPatchUpload_ChunkTooSmall(t *testing.T) {
+	services.SetUploader(services.NewMemoryUploader())
+
+	r := uploadRouter()
+
+	// every chunk but the last holds at least 10 bytes
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, createUploadRequest(10*(uploadMaxParts-1)))
+
+	location := w.Header().Get("Location")
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, 0, []byte("PK")))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the chunk was discarded
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, patchUploadRequest(location, 0, bytes.Repeat([]byte("x"), 10)))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Upload-Offset"))
+}
+
+func TestCreateUploadURL_MissingTag(t *testing.T) {
+	r := uploadRouter()
+
+	body := []byte(`{"imageName":"dave1","creatorName":"Dave"}`)
+	req, _ := http.NewRequest("POST", "/upload-url", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// createUploadURL signs an upload URL for a new tag of dave2
+func createUploadURL(t *testing.T, r *gin.Engine) models.SignedUploadURL {
+	body := []byte(`{"imageName":"dave2","creatorName":"Dave","imageTag":"v1"}`)
+	req, _ := http.NewRequest("POST", "/upload-url", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var signed models.SignedUploadURL
+	json.Unmarshal(w.Body.Bytes(), &signed)
+	return signed
+}
+
+func TestCreateUploadURL(t *testing.T) {
+	services.SetUploader(services.NewMemoryUploader())
+
+	r := uploadRouter()
+
+	signed := createUploadURL(t, r)
+
+	// the client writes a staging object, never the archive that is built
+	assert.Contains(t, signed.URL, "/uploads/signed/"+signed.CorId+".zip")
+	assert.NotContains(t, signed.URL, "challenge-zips")
+	assert.Equal(t, http.MethodPut, signed.Method)
+	assert.Equal(t, "application/zip", signed.Headers["Content-Type"])
+	assert.Equal(t, "0", signed.Headers["X-Goog-If-Generation-Match"])
+}
+
+func TestConfirmUpload(t *testing.T) {
+	uploader := services.NewMemoryUploader()
+	services.SetUploader(uploader)
+	testPublisher.Reset()
+
+	r := uploadRouter()
+	archive := testArchive()
+
+	signed := createUploadURL(t, r)
+	staged := "uploads/signed/" + signed.CorId + ".zip"
+
+	// nothing was PUT yet
+	req, _ := http.NewRequest("POST", "/"+signed.CorId+"/confirm", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	uploader.PutObject(staged, archive)
+
+	req, _ = http.NewRequest("POST", "/"+signed.CorId+"/confirm", nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), signed.CorId)
+
+	// the validated copy is built and the staging object is gone
+	s3Path := imageObjectPath("Dave", signed.CorId)
+	stored, ok := uploader.Object(s3Path)
+	assert.True(t, ok)
+	assert.Equal(t, archive, stored)
+	_, ok = uploader.Object(staged)
+	assert.False(t, ok)
+
+	messages := testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD)
+	assert.Len(t, messages, 1)
+
+	var published UploadImageMessage
+	json.Unmarshal(mq.UnwrapPayload(messages[0].Body), &published)
+	assert.Equal(t, s3Path, published.S3Path)
+
+	// an upload is confirmed once
+	req, _ = http.NewRequest("POST", "/"+signed.CorId+"/confirm", nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestConfirmUpload_RetryImageCreate(t *testing.T) {
+	uploader := services.NewMemoryUploader()
+	services.SetUploader(uploader)
+	testPublisher.Reset()
+	testPublisher.FailRoute(mq.ROUTE_IMAGE_BUILD, mq.ErrUnroutable)
+	defer testPublisher.Reset()
+
+	r := uploadRouter()
+
+	signed := createUploadURL(t, r)
+	uploader.PutObject("uploads/signed/"+signed.CorId+".zip", testArchive())
+
+	req, _ := http.NewRequest("POST", "/"+signed.CorId+"/confirm", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// still pending, so the confirmation can be sent again
+	testPublisher.FailRoute(mq.ROUTE_IMAGE_BUILD, nil)
+
+	req, _ = http.NewRequest("POST", "/"+signed.CorId+"/confirm", nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD), 1)
+}
+
+func TestConfirmUpload_Confirming(t *testing.T) {
+	uploader := services.NewMemoryUploader()
+	services.SetUploader(uploader)
+	testPublisher.Reset()
+
+	r := uploadRouter()
+
+	signed := createUploadURL(t, r)
+	uploader.PutObject("uploads/signed/"+signed.CorId+".zip", testArchive())
+
+	// another confirmation of the upload is running
+	upload, _, err := uploadController.SignedCollection.GetSignedUploadByCorId(signed.CorId)
+	assert.NoError(t, err)
+	_, err = uploadController.SignedCollection.ClaimSignedUpload(upload, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/"+signed.CorId+"/confirm", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD))
+
+	// until its claim runs out
+	_, err = uploadController.SignedCollection.ReleaseSignedUpload(upload)
+	assert.NoError(t, err)
+	_, err = uploadController.SignedCollection.ClaimSignedUpload(upload, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+
+	req, _ = http.NewRequest("POST", "/"+signed.CorId+"/confirm", nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, testPublisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_IMAGE_BUILD), 1)
+}
+
+func TestConfirmUpload_NotFound(t *testing.T) {
+	r := uploadRouter()
+
+	req, _ := http.NewRequest("POST", "/00000000-0000-0000-0000-000000000000/confirm", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type WebhookController struct {
+	SubscriptionCollection collections.WebhookSubscriptionCollection
+	DeliveryCollection     collections.WebhookDeliveryCollection
+}
+
+func NewWebhookController(client *mongo.Client) *WebhookController {
+	return &WebhookController{
+		SubscriptionCollection: *collections.NewWebhookSubscriptionCollection(client),
+		DeliveryCollection:     *collections.NewWebhookDeliveryCollection(client),
+	}
+}
+
+// CreateWebhook godoc
+//
+//	@Summary		Register a webhook
+//	@Description	POST the creator's processes to a URL once they reach a terminal status, optionally only for some event types (imageCreate, challengeCreate, challengeStart). Payloads are signed with the secret: X-Webhook-Signature is "sha256=" followed by the hex HMAC-SHA256 of X-Webhook-Timestamp, a dot and the body. A secret is generated when none is given and is only returned here.
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook	body		models.WebhookSubscription	true	"Webhook"
+//	@Success		201		{object}	models.WebhookSubscription
+//	@Failure		400		{object}	models.HTTPError
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/webhook [post]
+func (t WebhookController) CreateWebhook(c *gin.Context) {
+	var req models.WebhookSubscription
+	err := c.BindJSON(&req)
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid request body json",
+			err,
+		)
+		return
+	}
+
+	// validate json
+	v := validator.New()
+	err = v.Struct(req)
+	if err == nil && len(req.Events) > 0 {
+		err = validateFeedEvents(req.Events)
+	}
+	if err != nil {
+		handleError(
+			c,
+			http.StatusBadRequest,
+			"Invalid request body",
+			err,
+		)
+		return
+	}
+
+	// the id and creation time are the server's to pick
+	req.Id = primitive.NilObjectID
+	req.CreatedAt = time.Time{}
+
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			handleError(
+				c,
+				http.StatusInternalServerError,
+				"Failed to generate secret",
+				err,
+			)
+			return
+		}
+		req.Secret = hex.EncodeToString(secret)
+	}
+
+	statusCode, err := t.SubscriptionCollection.InsertSubscription(&req)
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to create webhook",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, req)
+}
+
+// GetWebhooksByCreatorName godoc
+//
+//	@Summary		Retrieve the webhooks of a creator
+//	@Description	Get all webhooks registered by a creator, without their secrets
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			creatorName	path		string	true	"Creator's Name"
+//	@Success		200			{array}		models.WebhookSubscription
+//	@Failure		400			{object}	models.HTTPError
+//	@Failure		500			{object}	models.HTTPError
+//	@Router			/webhook/name/{creatorName} [get]
+func (t WebhookController) GetWebhooksByCreatorName(c *gin.Context) {
+	subs, statusCode, err := t.SubscriptionCollection.GetSubscriptionsByCreatorName(c.Param("creatorName"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve webhooks",
+			err,
+		)
+		return
+	}
+
+	for i := range *subs {
+		(*subs)[i].Secret = ""
+	}
+
+	c.JSON(statusCode, *subs)
+}
+
+// GetWebhookById godoc
+//
+//	@Summary		Retrieve a webhook
+//	@Description	Get a single webhook, without its secret
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id	path		string	true	"Webhook ID"
+//	@Success		200	{object}	models.WebhookSubscription
+//	@Failure		400	{object}	models.HTTPError
+//	@Failure		404	{object}	models.HTTPError
+//	@Router			/webhook/{id} [get]
+func (t WebhookController) GetWebhookById(c *gin.Context) {
+	sub, statusCode, err := t.SubscriptionCollection.GetSubscriptionById(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve webhook",
+			err,
+		)
+		return
+	}
+
+	sub.Secret = ""
+	c.JSON(statusCode, *sub)
+}
+
+// DeleteWebhook godoc
+//
+//	@Summary		Delete a webhook
+//	@Description	Stop sending deliveries to a webhook, pending deliveries are given up
+//	@Tags			webhooks
+//	@Param			id	path	string	true	"Webhook ID"
+//	@Success		204
+//	@Failure		400	{object}	models.HTTPError
+//	@Failure		404	{object}	models.HTTPError
+//	@Router			/webhook/{id} [delete]
+func (t WebhookController) DeleteWebhook(c *gin.Context) {
+	statusCode, err := t.SubscriptionCollection.DeleteSubscription(c.Param("id"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to delete webhook",
+			err,
+		)
+		return
+	}
+
+	c.Status(statusCode)
+}
+
+// GetWebhookDeliveries godoc
+//
+//	@Summary		Retrieve the deliveries of a webhook
+//	@Description	Get the deliveries of a webhook with the log of every attempt, newest first
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id		path		string	true	"Webhook ID"
+//	@Param			status	query		string	false	"Filter by status (pending, delivered or failed)"
+//	@Success		200		{array}		models.WebhookDelivery
+//	@Failure		400		{object}	models.HTTPError
+//	@Failure		500		{object}	models.HTTPError
+//	@Router			/webhook/{id}/delivery [get]
+func (t WebhookController) GetWebhookDeliveries(c *gin.Context) {
+	deliveries, statusCode, err := t.DeliveryCollection.GetDeliveriesBySubscriptionId(c.Param("id"), c.Query("status"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to retrieve webhook deliveries",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *deliveries)
+}
+
+// RedeliverWebhook godoc
+//
+//	@Summary		Redeliver a webhook delivery
+//	@Description	Send a delivered or failed delivery again with the same payload
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id			path		string	true	"Webhook ID"
+//	@Param			deliveryId	path		string	true	"Delivery ID"
+//	@Success		202			{object}	models.WebhookDelivery
+//	@Failure		400			{object}	models.HTTPError
+//	@Failure		404			{object}	models.HTTPError
+//	@Failure		409			{object}	models.HTTPError	"Delivery is still pending"
+//	@Router			/webhook/{id}/delivery/{deliveryId}/redeliver [post]
+func (t WebhookController) RedeliverWebhook(c *gin.Context) {
+	delivery, statusCode, err := t.DeliveryCollection.Redeliver(c.Param("id"), c.Param("deliveryId"))
+	if err != nil {
+		handleError(
+			c,
+			statusCode,
+			"Failed to redeliver webhook",
+			err,
+		)
+		return
+	}
+
+	c.JSON(statusCode, *delivery)
+}
@@ -0,0 +1,157 @@
+// +build integration
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"platform_api/configs"
+	"platform_api/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var webhookController = NewWebhookController(configs.Client)
+
+var webhookId = primitive.NewObjectID()
+var webhookDeliveryId = primitive.NewObjectID()
+
+func seed_webhooks() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	webhook := models.WebhookSubscription{
+		Id:          webhookId,
+		CreatorName: "Dave",
+		URL:         "http://localhost/hook",
+		Secret:      "s3cret",
+		Events:      []string{},
+		CreatedAt:   time.Now().UTC(),
+	}
+	_, err := configs.OpenCollection(configs.Client, "webhook_subscription").InsertOne(ctx, webhook)
+	if err != nil {
+		fmt.Printf("Error inserting document: %v\n", err)
+		return
+	}
+
+	delivery := models.WebhookDelivery{
+		Id:             webhookDeliveryId,
+		SubscriptionId: webhookId,
+		ProcessId:      primitive.NewObjectID(),
+		CorId:          "wh1",
+		Event:          "imageCreate",
+		EventStatus:    "imageCreated",
+		URL:            webhook.URL,
+		Payload:        `{"corId":"wh1"}`,
+		Status:         models.WebhookDeliveryStatusFailed,
+		Attempts:       8,
+		Log:            []models.WebhookAttempt{},
+		CreatedAt:      time.Now().UTC(),
+		NextAttemptAt:  time.Now().UTC(),
+	}
+	_, err = configs.OpenCollection(configs.Client, "webhook_delivery").InsertOne(ctx, delivery)
+	if err != nil {
+		fmt.Printf("Error inserting document: %v\n", err)
+		return
+	}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	r := gin.Default()
+
+	r.POST("/webhook", webhookController.CreateWebhook)
+	r.GET("/webhook/name/:creatorName", webhookController.GetWebhooksByCreatorName)
+
+	body := `{"creatorName":"Erin","url":"https://ci.example.com/hook","events":["imageCreate"]}`
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewBufferString(body))
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created models.WebhookSubscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Secret)
+
+	// secrets are only returned on creation
+	req, _ = http.NewRequest("GET", "/webhook/name/Erin", nil)
+
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+}
+
+func TestCreateWebhook_ServerFields(t *testing.T) {
+	r := gin.Default()
+
+	r.POST("/webhook", webhookController.CreateWebhook)
+
+	// a client cannot pick the id of its webhook
+	id := primitive.NewObjectID()
+	body := `{"id":"` + id.Hex() + `","creatorName":"Erin","url":"https://ci.example.com/hook","createdAt":"2001-01-01T00:00:00Z"}`
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewBufferString(body))
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created models.WebhookSubscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEqual(t, id, created.Id)
+	assert.False(t, created.Id.IsZero())
+	assert.WithinDuration(t, time.Now(), created.CreatedAt, time.Minute)
+}
+
+func TestCreateWebhook_UnknownEvent(t *testing.T) {
+	r := gin.Default()
+
+	r.POST("/webhook", webhookController.CreateWebhook)
+
+	body := `{"creatorName":"Erin","url":"https://ci.example.com/hook","events":["xyxy"]}`
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewBufferString(body))
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRedeliverWebhook(t *testing.T) {
+	seed_webhooks()
+
+	r := gin.Default()
+
+	r.POST("/webhook/:id/delivery/:deliveryId/redeliver", webhookController.RedeliverWebhook)
+
+	req, _ := http.NewRequest("POST", "/webhook/"+webhookId.Hex()+"/delivery/"+webhookDeliveryId.Hex()+"/redeliver", nil)
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"pending"`)
+
+	// the delivery is pending until it has been attempted
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -1,16 +1,82 @@
 package main
 
 import (
+	"log"
 	"platform_api/configs"
 	"platform_api/mq"
 	"platform_api/routes"
 	"platform_api/services"
 	_ "platform_api/docs"
+	"time"
 )
 
 func main() {
 	configs.InitEnv()   // init env
 	services.Init()     // init s3
-	mq.Init()           // init rabbitmq connection
-	routes.InitRoutes() // init controller routes
+
+	// names of exchanges, queues and routing keys
+	mq.UseTopology(configs.TOPOLOGY)
+
+	var publisher mq.Publisher
+	var spool *mq.Spool
+	if configs.MQ_DRIVER == "memory" {
+
+		// keep messages in memory for local development
+		log.Println("Using in-memory MQ publisher")
+		publisher = mq.NewMemoryPublisher()
+
+	} else {
+
+		mq.Init() // init rabbitmq connection
+
+		// spool publishes to disk while rabbitmq is unreachable
+		var err error
+		spool, err = mq.OpenSpool(configs.MQ_SPOOL_DIR)
+		if err != nil {
+			log.Panic("Failed to open MQ spool", err)
+		}
+		spooling := mq.NewSpoolingPublisher(configs.Client, mq.NewAMQPPublisher(), spool, mq.AMQP_MANAGER.Ready)
+		spooling.Start(time.Second)
+		publisher = spooling
+
+		// consume replies from downstream services
+		err = mq.NewConsumer(configs.Client).Start()
+		if err != nil {
+			log.Panic("Failed to start consumer", err)
+		}
+
+		// archive dead-lettered messages for inspection
+		err = mq.NewDeadLetterArchiver(configs.Client).Start()
+		if err != nil {
+			log.Panic("Failed to start dead letter archiver", err)
+		}
+
+	}
+
+	// relay messages left in the outbox
+	interval, err := time.ParseDuration(configs.OUTBOX_RELAY_INTERVAL)
+	if err != nil {
+		log.Panic("Invalid OUTBOX_RELAY_INTERVAL", err)
+	}
+	mq.NewOutboxRelay(configs.Client, publisher, interval).Start()
+
+	// time out processes the downstream services never finished
+	timeout, err := time.ParseDuration(configs.PROCESS_TIMEOUT)
+	if err != nil {
+		log.Panic("Invalid PROCESS_TIMEOUT", err)
+	}
+	interval, err = time.ParseDuration(configs.PROCESS_SWEEP_INTERVAL)
+	if err != nil {
+		log.Panic("Invalid PROCESS_SWEEP_INTERVAL", err)
+	}
+	services.NewProcessSweeper(configs.Client, publisher, timeout, interval).Start()
+
+	// notify webhooks of finished processes
+	interval, err = time.ParseDuration(configs.WEBHOOK_DELIVERY_INTERVAL)
+	if err != nil {
+		log.Panic("Invalid WEBHOOK_DELIVERY_INTERVAL", err)
+	}
+	services.NewWebhookDispatcher(configs.Client, interval).Start()
+
+	routes.InitRoutes(publisher, spool) // init controller routes
 }
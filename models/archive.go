@@ -0,0 +1,25 @@
+package models
+
+// rules an uploaded archive can break
+const (
+	ArchiveRuleFormat           = "format"
+	ArchiveRuleSize             = "size"
+	ArchiveRuleUncompressedSize = "uncompressedSize"
+	ArchiveRuleFileCount        = "fileCount"
+	ArchiveRulePath             = "path"
+	ArchiveRuleDockerfile       = "dockerfile"
+)
+
+// ArchiveViolation is a rule an uploaded archive breaks, with the entry that
+// breaks it when there is one
+type ArchiveViolation struct {
+	Rule    string `json:"rule"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// ArchiveValidationError is the response to an upload of an invalid archive
+type ArchiveValidationError struct {
+	HTTPError
+	Violations []ArchiveViolation `json:"violations"`
+}
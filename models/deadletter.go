@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DeadLetterStatusParked   = "parked"
+	DeadLetterStatusRedriven = "redriven"
+)
+
+// DeadLetter is a message that was rejected or ran out of retries
+type DeadLetter struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	MessageId      string             `json:"messageId" bson:"messageId"`
+	CorId          string             `json:"corId" bson:"corId"`
+	Type           string             `json:"type" bson:"type"`
+	Exchange       string             `json:"exchange" bson:"exchange"`
+	RoutingKey     string             `json:"routingKey" bson:"routingKey"`
+	Queue          string             `json:"queue" bson:"queue"`
+	Reason         string             `json:"reason" bson:"reason"`
+	Count          int64              `json:"count" bson:"count"`
+	Retries        int                `json:"retries" bson:"retries"`
+	Body           string             `json:"body" bson:"body"`
+	Status         string             `json:"status" bson:"status"`
+	DeadLetteredAt time.Time          `json:"deadLetteredAt" bson:"deadLetteredAt"`
+	RedrivenAt     *time.Time         `json:"redrivenAt,omitempty" bson:"redrivenAt,omitempty"`
+}
@@ -1,6 +1,10 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Image struct {
     _Id               primitive.ObjectID `json:"_id" bson:"_id"`
@@ -9,4 +13,32 @@ type Image struct {
 	ImageName         string             `json:"imageName" bson:"imageName"`
 	ImageTag          string             `json:"imageTag" bson:"imageTag"`
 	ImageRegistryLink string             `json:"imageRegistryLink" bson:"imageRegistryLink"`
-}
\ No newline at end of file
+}
+
+// ImageAlias is a tag, such as latest or stable, that creators move between
+// the built tags of an image
+type ImageAlias struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CreatorName string             `json:"creatorName" bson:"creatorName"`
+	ImageName   string             `json:"imageName" bson:"imageName"`
+	Alias       string             `json:"alias" bson:"alias"`
+	ImageTag    string             `json:"imageTag" bson:"imageTag" validate:"required"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ImageBuild is an upload of an image tag as recorded by the process engine
+type ImageBuild struct {
+	CorId       string     `json:"corId" bson:"_id"`
+	ImageTag    string     `json:"imageTag" bson:"imageTag"`
+	UploadedAt  *time.Time `json:"uploadedAt" bson:"uploadedAt"`
+	EventStatus string     `json:"eventStatus" bson:"eventStatus"`
+}
+
+// ImageTagInfo is a tag of an image with its latest build and the aliases
+// pointing at it
+type ImageTagInfo struct {
+	ImageBuild        `bson:",inline"`
+	Outcome           string   `json:"outcome"`
+	ImageRegistryLink string   `json:"imageRegistryLink,omitempty"`
+	Aliases           []string `json:"aliases"`
+}
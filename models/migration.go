@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+const (
+	MigrationStatusRunning   = "running"
+	MigrationStatusCompleted = "completed"
+	MigrationStatusFailed    = "failed"
+)
+
+// MIGRATION_PROCESS_TIMESTAMPS rewrites process timestamps stored before
+// they were dates
+const MIGRATION_PROCESS_TIMESTAMPS = "processTimestamps"
+
+// Migration is a one-off data migration started through the admin API
+type Migration struct {
+	Name       string     `json:"name" bson:"_id"`
+	Status     string     `json:"status" bson:"status"`
+	Migrated   int        `json:"migrated" bson:"migrated"`
+	Skipped    int        `json:"skipped" bson:"skipped"`
+	Error      string     `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt" bson:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+}
+
+// MigrationResult counts the records a migration rewrote, and the ones it
+// had to leave as they were
+type MigrationResult struct {
+	Migrated int
+	Skipped  int
+}
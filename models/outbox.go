@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OutboxStatusPending    = "pending"
+	OutboxStatusDispatched = "dispatched"
+
+	// refused by the broker, it is not retried
+	OutboxStatusRefused = "refused"
+)
+
+// OutboxMessage is a message accepted by the API that still has to reach,
+// or has already reached, the MQ
+type OutboxMessage struct {
+	Id            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	MessageId     string             `json:"messageId" bson:"messageId"`
+	CorId         string             `json:"corId" bson:"corId"`
+	Type          string             `json:"type" bson:"type"`
+	Exchange      string             `json:"exchange" bson:"exchange"`
+	RoutingKey    string             `json:"routingKey" bson:"routingKey"`
+	Body          string             `json:"body" bson:"body"`
+	Status        string             `json:"status" bson:"status"`
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	LastError     string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	DispatchedAt  *time.Time         `json:"dispatchedAt,omitempty" bson:"dispatchedAt,omitempty"`
+}
+
+// OutboxStats describes the backlog of messages waiting to be dispatched
+type OutboxStats struct {
+	Pending          int64      `json:"pending"`
+	Refused          int64      `json:"refused"`
+	OldestPendingAt  *time.Time `json:"oldestPendingAt,omitempty"`
+	OldestPendingAge float64    `json:"oldestPendingAgeSeconds"`
+}
@@ -1,16 +1,54 @@
 package models
 
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
 // "google.golang.org/genproto/googleapis/type/datetime"
 
 type Process struct {
-	Timestamp     map[string]interface{} `json:"timestamp" bson:"timestamp"`
-	CorId         *string                `json:"corId" bson:"corId"`
-	Event         *string                `json:"event" bson:"event"`
-	EventStatus   *string                `json:"eventStatus" bson:"eventStatus"`
-	CreatorName   *string                `json:"creatorName,omitempty" bson:"creatorName,omitempty"`
-	ChallengeName *string                `json:"challengeName,omitempty" bson:"challengeName,omitempty"`
-	ImageName     *string                `json:"imageName,omitempty" bson:"imageName,omitempty"`
-	ImageTag      *string                `json:"imageTag,omitempty" bson:"imageTag,omitempty"`
-	Participant   *string                `json:"participant,omitempty" bson:"participant,omitempty"`
-	Participants  *[]string              `json:"participants,omitempty" bson:"participants,omitempty"`
-}
\ No newline at end of file
+	Timestamp     *ProcessTime `json:"timestamp" bson:"timestamp" swaggertype:"string" format:"date-time"`
+	CorId         *string      `json:"corId" bson:"corId"`
+	Event         *string      `json:"event" bson:"event"`
+	EventStatus   *string      `json:"eventStatus" bson:"eventStatus"`
+	CreatorName   *string      `json:"creatorName,omitempty" bson:"creatorName,omitempty"`
+	ChallengeName *string      `json:"challengeName,omitempty" bson:"challengeName,omitempty"`
+	ImageName     *string      `json:"imageName,omitempty" bson:"imageName,omitempty"`
+	ImageTag      *string      `json:"imageTag,omitempty" bson:"imageTag,omitempty"`
+	Participant   *string      `json:"participant,omitempty" bson:"participant,omitempty"`
+	Participants  *[]string    `json:"participants,omitempty" bson:"participants,omitempty"`
+
+	// id of the message the record was written for, a redelivery of the
+	// message is not recorded twice
+	MessageId *string `json:"-" bson:"messageId,omitempty"`
+
+	// derived from EventStatus by the state machine, never stored
+	Outcome string `json:"outcome,omitempty" bson:"-"`
+}
+
+// ProcessEvent is a process_engine record together with its document id,
+// which orders the records of a process and identifies them on streams
+type ProcessEvent struct {
+	Id      primitive.ObjectID `json:"id" bson:"_id"`
+	Process `bson:",inline"`
+}
+
+// ProcessSearch filters and pages through process_engine records
+type ProcessSearch struct {
+	Event         string
+	EventStatus   string
+	CreatorName   string
+	ChallengeName string
+	Participant   string
+	From          *time.Time
+	To            *time.Time
+	Since         *time.Time
+	Until         *time.Time
+
+	// id of the last record of the previous page
+	Cursor     *primitive.ObjectID
+	Limit      int64
+	Descending bool
+}
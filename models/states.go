@@ -0,0 +1,210 @@
+package models
+
+import "fmt"
+
+const (
+	EventImageCreate     = "imageCreate"
+	EventChallengeCreate = "challengeCreate"
+	EventChallengeStart  = "challengeStart"
+)
+
+const (
+	EventStatusImageCreating     = "imageCreating"
+	EventStatusImageCreated      = "imageCreated"
+	EventStatusImageCreateFailed = "imageCreateFailed"
+
+	EventStatusChallengeCreating     = "challengeCreating"
+	EventStatusChallengeCreated      = "challengeCreated"
+	EventStatusChallengeCreateFailed = "challengeCreateFailed"
+
+	EventStatusChallengeStarting    = "challengeStarting"
+	EventStatusChallengeStarted     = "challengeStarted"
+	EventStatusChallengeStartFailed = "challengeStartFailed"
+
+	EventStatusCancelRequested = "cancelRequested"
+	EventStatusCancelled       = "cancelled"
+
+	// recorded by the sweeper when no service reported back in time
+	EventStatusTimedOut = "timedOut"
+)
+
+// outcomes of a process, as seen by API clients
+const (
+	ProcessOutcomePending   = "pending"
+	ProcessOutcomeSucceeded = "succeeded"
+	ProcessOutcomeFailed    = "failed"
+	ProcessOutcomeCancelled = "cancelled"
+)
+
+// ProcessState is an event status and the statuses that may follow it
+type ProcessState struct {
+	Name     string   `json:"name"`
+	Outcome  string   `json:"outcome"`
+	Terminal bool     `json:"terminal"`
+	Next     []string `json:"next"`
+}
+
+// ProcessStateMachine lists the statuses a process of one event type goes
+// through, starting at Initial
+type ProcessStateMachine struct {
+	Event   string         `json:"event"`
+	Initial string         `json:"initial"`
+	States  []ProcessState `json:"states"`
+}
+
+// newStateMachine builds the machine shared by all event types: the
+// in-progress status ends in success or failure, and can be cancelled or
+// time out until it does. A success reported after the timeout still wins,
+// the downstream service did create what was asked for.
+func newStateMachine(event string, inProgress string, succeeded string, failed string) ProcessStateMachine {
+	return ProcessStateMachine{
+		Event:   event,
+		Initial: inProgress,
+		States: []ProcessState{
+			{Name: inProgress, Outcome: ProcessOutcomePending, Next: []string{succeeded, failed, EventStatusCancelRequested, EventStatusCancelled, EventStatusTimedOut}},
+			{Name: EventStatusCancelRequested, Outcome: ProcessOutcomePending, Next: []string{succeeded, failed, EventStatusCancelled, EventStatusTimedOut}},
+			{Name: succeeded, Outcome: ProcessOutcomeSucceeded, Terminal: true, Next: []string{}},
+			{Name: failed, Outcome: ProcessOutcomeFailed, Terminal: true, Next: []string{}},
+			{Name: EventStatusCancelled, Outcome: ProcessOutcomeCancelled, Terminal: true, Next: []string{}},
+			{Name: EventStatusTimedOut, Outcome: ProcessOutcomeFailed, Terminal: true, Next: []string{succeeded}},
+		},
+	}
+}
+
+// PROCESS_STATE_MACHINES defines the statuses of every event type
+var PROCESS_STATE_MACHINES = []ProcessStateMachine{
+	newStateMachine(EventImageCreate, EventStatusImageCreating, EventStatusImageCreated, EventStatusImageCreateFailed),
+	newStateMachine(EventChallengeCreate, EventStatusChallengeCreating, EventStatusChallengeCreated, EventStatusChallengeCreateFailed),
+	newStateMachine(EventChallengeStart, EventStatusChallengeStarting, EventStatusChallengeStarted, EventStatusChallengeStartFailed),
+}
+
+// GetStateMachine returns the state machine of an event type
+func GetStateMachine(event string) (*ProcessStateMachine, bool) {
+	for i := range PROCESS_STATE_MACHINES {
+		if PROCESS_STATE_MACHINES[i].Event == event {
+			return &PROCESS_STATE_MACHINES[i], true
+		}
+	}
+	return nil, false
+}
+
+// State returns a status of the machine
+func (t ProcessStateMachine) State(eventStatus string) (*ProcessState, bool) {
+	for i := range t.States {
+		if t.States[i].Name == eventStatus {
+			return &t.States[i], true
+		}
+	}
+	return nil, false
+}
+
+// PendingStatuses lists the statuses of the machine that are still in flight
+func (t ProcessStateMachine) PendingStatuses() []string {
+	statuses := []string{}
+	for _, state := range t.States {
+		if state.Outcome == ProcessOutcomePending {
+			statuses = append(statuses, state.Name)
+		}
+	}
+	return statuses
+}
+
+// ValidateTransition checks that a process of the event type may move from
+// one status to the next. The first status recorded for a process may be any
+// of the machine, since earlier ones can be written by other services.
+func ValidateTransition(event string, from string, to string) error {
+	machine, ok := GetStateMachine(event)
+	if !ok {
+		return fmt.Errorf("unknown event %q", event)
+	}
+	if _, ok := machine.State(to); !ok {
+		return fmt.Errorf("unknown %s status %q", event, to)
+	}
+	if from == "" {
+		return nil
+	}
+
+	state, ok := machine.State(from)
+	if !ok {
+		return fmt.Errorf("unknown %s status %q", event, from)
+	}
+	for _, next := range state.Next {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s cannot move from %q to %q", event, from, to)
+}
+
+// IsTerminalStatus reports whether a process with this status has finished
+func IsTerminalStatus(eventStatus string) bool {
+	return ProcessOutcome(eventStatus) != ProcessOutcomePending
+}
+
+// ProcessOutcome returns whether a process with this status is pending,
+// succeeded, failed or was cancelled. Statuses outside the state machines
+// count as pending.
+func ProcessOutcome(eventStatus string) string {
+	for _, machine := range PROCESS_STATE_MACHINES {
+		if state, ok := machine.State(eventStatus); ok {
+			return state.Outcome
+		}
+	}
+	return ProcessOutcomePending
+}
+
+// TerminalStatuses lists the event statuses that end a process
+func TerminalStatuses() []string {
+	seen := map[string]bool{}
+	statuses := []string{}
+	for _, machine := range PROCESS_STATE_MACHINES {
+		for _, state := range machine.States {
+			if state.Terminal && !seen[state.Name] {
+				seen[state.Name] = true
+				statuses = append(statuses, state.Name)
+			}
+		}
+	}
+	return statuses
+}
+
+// PendingStatuses lists the event statuses of processes still in flight
+func PendingStatuses() []string {
+	seen := map[string]bool{}
+	statuses := []string{}
+	for _, machine := range PROCESS_STATE_MACHINES {
+		for _, state := range machine.States {
+			if state.Outcome == ProcessOutcomePending && !seen[state.Name] {
+				seen[state.Name] = true
+				statuses = append(statuses, state.Name)
+			}
+		}
+	}
+	return statuses
+}
+
+// StatusesWithOutcome lists the event statuses that end a process with the
+// given outcome
+func StatusesWithOutcome(outcome string) []string {
+	seen := map[string]bool{}
+	statuses := []string{}
+	for _, machine := range PROCESS_STATE_MACHINES {
+		for _, state := range machine.States {
+			if state.Terminal && state.Outcome == outcome && !seen[state.Name] {
+				seen[state.Name] = true
+				statuses = append(statuses, state.Name)
+			}
+		}
+	}
+	return statuses
+}
+
+// IsProcessStatus reports whether any state machine has the event status
+func IsProcessStatus(eventStatus string) bool {
+	for _, machine := range PROCESS_STATE_MACHINES {
+		if _, ok := machine.State(eventStatus); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// ProcessStats summarises the processes started in a time range
+type ProcessStats struct {
+	CreatorName string              `json:"creatorName,omitempty"`
+	From        *time.Time          `json:"from,omitempty"`
+	To          *time.Time          `json:"to,omitempty"`
+	Events      []EventStats        `json:"events"`
+	Daily       []DailyProcessStats `json:"daily"`
+}
+
+// EventStats counts the processes of one event type by their latest status
+// and how long the finished ones took
+type EventStats struct {
+	Event       string             `json:"event"`
+	Total       int64              `json:"total"`
+	Pending     int64              `json:"pending"`
+	Succeeded   int64              `json:"succeeded"`
+	Failed      int64              `json:"failed"`
+	Cancelled   int64              `json:"cancelled"`
+	SuccessRate float64            `json:"successRate"`
+	ByStatus    map[string]int64   `json:"byStatus"`
+	LatencyMs   LatencyPercentiles `json:"latencyMs"`
+}
+
+// LatencyPercentiles of the time from the first record of a process to its
+// terminal status
+type LatencyPercentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// DailyProcessStats counts the processes of an event type started on a day
+type DailyProcessStats struct {
+	Day       string `json:"day"`
+	Event     string `json:"event"`
+	Started   int64  `json:"started"`
+	Succeeded int64  `json:"succeeded"`
+	Failed    int64  `json:"failed"`
+}
+
+// LatencyBucket counts the durations falling in one bucket of a histogram
+type LatencyBucket struct {
+	Count int64   `bson:"count"`
+	MaxMs float64 `bson:"maxMs"`
+}
+
+// NewLatencyPercentiles takes the nearest-rank percentiles of a histogram in
+// ascending buckets. A percentile is the longest duration of the bucket its
+// rank falls in.
+func NewLatencyPercentiles(buckets []LatencyBucket) LatencyPercentiles {
+	var count int64
+	for _, bucket := range buckets {
+		count += bucket.Count
+	}
+
+	percentiles := LatencyPercentiles{Count: int(count)}
+	if count == 0 {
+		return percentiles
+	}
+
+	rank := func(p float64) float64 {
+		r := int64(math.Ceil(p * float64(count)))
+		var seen int64
+		for _, bucket := range buckets {
+			seen += bucket.Count
+			if seen >= r {
+				return bucket.MaxMs
+			}
+		}
+		return buckets[len(buckets)-1].MaxMs
+	}
+	percentiles.P50 = rank(0.50)
+	percentiles.P95 = rank(0.95)
+	percentiles.P99 = rank(0.99)
+	return percentiles
+}
+
+// Add counts processes that ended, or are still pending, in a status
+func (t *EventStats) Add(eventStatus string, count int64) {
+	if t.ByStatus == nil {
+		t.ByStatus = map[string]int64{}
+	}
+	t.ByStatus[eventStatus] += count
+	t.Total += count
+
+	switch ProcessOutcome(eventStatus) {
+	case ProcessOutcomeSucceeded:
+		t.Succeeded += count
+	case ProcessOutcomeFailed:
+		t.Failed += count
+	case ProcessOutcomeCancelled:
+		t.Cancelled += count
+	default:
+		t.Pending += count
+	}
+
+	// of the processes that finished
+	if finished := t.Succeeded + t.Failed + t.Cancelled; finished > 0 {
+		t.SuccessRate = float64(t.Succeeded) / float64(finished)
+	}
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SweptProcess is a process the sweeper timed out because its status had
+// not changed for too long
+type SweptProcess struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CorId          string             `json:"corId" bson:"corId"`
+	Event          string             `json:"event" bson:"event"`
+	PreviousStatus string             `json:"previousStatus" bson:"previousStatus"`
+	CreatorName    string             `json:"creatorName,omitempty" bson:"creatorName,omitempty"`
+	LastUpdatedAt  time.Time          `json:"lastUpdatedAt" bson:"lastUpdatedAt"`
+	SweptAt        time.Time          `json:"sweptAt" bson:"sweptAt"`
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProcessTimelineStep is the time a process spent in one event status
+type ProcessTimelineStep struct {
+	Id          primitive.ObjectID `json:"id"`
+	Event       string             `json:"event"`
+	EventStatus string             `json:"eventStatus"`
+	StartedAt   time.Time          `json:"startedAt"`
+	EndedAt     *time.Time         `json:"endedAt,omitempty"`
+	DurationMs  int64              `json:"durationMs"`
+}
+
+// ProcessTimeline is the ordered history of a process
+type ProcessTimeline struct {
+	CorId            string                `json:"corId"`
+	Event            string                `json:"event"`
+	CurrentStatus    string                `json:"currentStatus"`
+	Outcome          string                `json:"outcome"`
+	Terminal         bool                  `json:"terminal"`
+	StartedAt        time.Time             `json:"startedAt"`
+	UpdatedAt        time.Time             `json:"updatedAt"`
+	ElapsedMs        int64                 `json:"elapsedMs"`
+	Stuck            bool                  `json:"stuck"`
+	StuckThresholdMs int64                 `json:"stuckThresholdMs"`
+	Steps            []ProcessTimelineStep `json:"steps"`
+}
+
+// Time returns when the record was written, records without a timestamp
+// fall back to the creation time of their document id
+func (t ProcessEvent) Time() time.Time {
+	if t.Timestamp != nil && !t.Timestamp.IsZero() {
+		return t.Timestamp.Time
+	}
+	return t.Id.Timestamp().UTC()
+}
+
+// NewProcessTimeline builds the timeline of the records of one process. The
+// current status is stuck once it has lasted longer than the threshold of
+// the event type, a zero threshold disables the check.
+func NewProcessTimeline(events []ProcessEvent, now time.Time, stuckThreshold func(event string) time.Duration) ProcessTimeline {
+	sorted := make([]ProcessEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, tj := sorted[i].Time(), sorted[j].Time()
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return bytes.Compare(sorted[i].Id[:], sorted[j].Id[:]) < 0
+	})
+
+	timeline := ProcessTimeline{Steps: []ProcessTimelineStep{}}
+
+	for i, event := range sorted {
+		step := ProcessTimelineStep{
+			Id:          event.Id,
+			Event:       deref(event.Event),
+			EventStatus: deref(event.EventStatus),
+			StartedAt:   event.Time(),
+		}
+		if i+1 < len(sorted) {
+			endedAt := sorted[i+1].Time()
+			step.EndedAt = &endedAt
+			step.DurationMs = endedAt.Sub(step.StartedAt).Milliseconds()
+		}
+		timeline.Steps = append(timeline.Steps, step)
+
+		if timeline.CorId == "" {
+			timeline.CorId = deref(event.CorId)
+		}
+		if step.Event != "" {
+			timeline.Event = step.Event
+		}
+	}
+
+	if len(timeline.Steps) == 0 {
+		return timeline
+	}
+
+	stuckAfter := stuckThreshold(timeline.Event)
+	timeline.StuckThresholdMs = stuckAfter.Milliseconds()
+
+	first, last := timeline.Steps[0], &timeline.Steps[len(timeline.Steps)-1]
+	timeline.CurrentStatus = last.EventStatus
+	timeline.Outcome = ProcessOutcome(last.EventStatus)
+	timeline.Terminal = IsTerminalStatus(last.EventStatus)
+	timeline.StartedAt = first.StartedAt
+	timeline.UpdatedAt = last.StartedAt
+
+	// the process is over at its terminal status, otherwise the clock runs
+	end := now
+	if timeline.Terminal {
+		end = last.StartedAt
+	} else {
+		last.DurationMs = now.Sub(last.StartedAt).Milliseconds()
+		timeline.Stuck = stuckAfter > 0 && now.Sub(last.StartedAt) > stuckAfter
+	}
+	timeline.ElapsedMs = end.Sub(timeline.StartedAt).Milliseconds()
+
+	return timeline
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
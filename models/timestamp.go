@@ -0,0 +1,162 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// ProcessTime is when a process record was written. It is stored as a BSON
+// date and sent as an RFC 3339 string. Older records embedded the time in
+// other shapes, which are still read until MigrateTimestamps rewrote them.
+type ProcessTime struct {
+	time.Time
+}
+
+// NewProcessTimestamp returns the timestamp of a record written at t, at the
+// millisecond precision of BSON dates
+func NewProcessTimestamp(t time.Time) *ProcessTime {
+	return &ProcessTime{Time: t.UTC().Truncate(time.Millisecond)}
+}
+
+func (t ProcessTime) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if t.IsZero() {
+		return bsontype.Null, nil, nil
+	}
+	return bsontype.DateTime, bsoncore.AppendDateTime(nil, t.UnixMilli()), nil
+}
+
+func (t *ProcessTime) UnmarshalBSONValue(bsonType bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: bsonType, Data: data}
+
+	switch bsonType {
+	case bsontype.Null, bsontype.Undefined:
+		// the driver allocates the pointer before asking to unmarshal a null
+		t.Time = time.Time{}
+	case bsontype.DateTime:
+		t.Time = time.UnixMilli(value.DateTime()).UTC()
+	case bsontype.Timestamp:
+		seconds, _ := value.Timestamp()
+		t.Time = time.Unix(int64(seconds), 0).UTC()
+	case bsontype.Int64, bsontype.Int32, bsontype.Double:
+		number, _ := value.AsInt64OK()
+		t.Time = fromUnixNumber(number)
+	case bsontype.String:
+		parsed, err := time.Parse(time.RFC3339Nano, value.StringValue())
+		if err != nil {
+			return err
+		}
+		t.Time = parsed.UTC()
+	case bsontype.EmbeddedDocument:
+		return t.unmarshalEmbedded(value.Document())
+	default:
+		return fmt.Errorf("cannot read a process timestamp from BSON %s", bsonType)
+	}
+	return nil
+}
+
+// unmarshalEmbedded reads the embedded documents written by older versions,
+// such as {"unixNano": ...}
+func (t *ProcessTime) unmarshalEmbedded(doc bsoncore.Document) error {
+	var embedded map[string]interface{}
+	err := bson.Unmarshal(doc, &embedded)
+	if err != nil {
+		return err
+	}
+
+	units := []struct {
+		key  string
+		unit time.Duration
+	}{
+		{"unixNano", time.Nanosecond},
+		{"unixMilli", time.Millisecond},
+		{"unix", time.Second},
+		{"seconds", time.Second},
+	}
+	for _, u := range units {
+		number, ok := toInt64(embedded[u.key])
+		if !ok {
+			continue
+		}
+		t.Time = time.Unix(0, 0).Add(time.Duration(number) * u.unit).UTC()
+		if nanos, ok := toInt64(embedded["nanos"]); ok && u.key == "seconds" {
+			t.Time = t.Time.Add(time.Duration(nanos))
+		}
+		return nil
+	}
+
+	if date, ok := embedded["$date"]; ok {
+		switch date := date.(type) {
+		case string:
+			parsed, err := time.Parse(time.RFC3339Nano, date)
+			if err != nil {
+				return err
+			}
+			t.Time = parsed.UTC()
+			return nil
+		default:
+			if number, ok := toInt64(date); ok {
+				t.Time = time.UnixMilli(number).UTC()
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("cannot read a process timestamp from %v", embedded)
+}
+
+func (t ProcessTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(time.RFC3339Nano))
+}
+
+func (t *ProcessTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	var raw string
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed.UTC()
+	return nil
+}
+
+// fromUnixNumber guesses the unit of a unix time from its magnitude
+func fromUnixNumber(number int64) time.Time {
+	switch {
+	case number > 1e17:
+		return time.Unix(0, number).UTC()
+	case number > 1e14:
+		return time.UnixMicro(number).UTC()
+	case number > 1e11:
+		return time.UnixMilli(number).UTC()
+	default:
+		return time.Unix(number, 0).UTC()
+	}
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch number := value.(type) {
+	case int64:
+		return number, true
+	case int32:
+		return int64(number), true
+	case float64:
+		return int64(number), true
+	}
+	return 0, false
+}
@@ -0,0 +1,88 @@
+// +build integration
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProcessTime_UnmarshalBSONValue(t *testing.T) {
+	at := time.Date(2023, 5, 1, 12, 30, 15, 250000000, time.UTC)
+
+	shapes := map[string]interface{}{
+		"date":      primitive.NewDateTimeFromTime(at),
+		"timestamp": primitive.Timestamp{T: uint32(at.Unix())},
+		"unix":      at.Unix(),
+		"unixMilli": at.UnixMilli(),
+		"unixMicro": at.UnixMicro(),
+		"unixNano":  at.UnixNano(),
+		"int32":     int32(at.Unix()),
+		"double":    float64(at.UnixMilli()),
+		"string":    at.Format(time.RFC3339Nano),
+		"embedded":  bson.D{{Key: "unixNano", Value: at.UnixNano()}},
+		"seconds":   bson.D{{Key: "seconds", Value: at.Unix()}, {Key: "nanos", Value: int32(at.Nanosecond())}},
+		"$date":     bson.D{{Key: "$date", Value: at.Format(time.RFC3339Nano)}},
+	}
+	// the shapes without sub-second precision
+	truncated := map[string]bool{"timestamp": true, "unix": true, "int32": true}
+
+	for name, shape := range shapes {
+		data, err := bson.Marshal(bson.D{{Key: "timestamp", Value: shape}})
+		assert.NoError(t, err)
+
+		var record struct {
+			Timestamp *ProcessTime `bson:"timestamp"`
+		}
+		err = bson.Unmarshal(data, &record)
+		assert.NoError(t, err, name)
+
+		expected := at
+		if truncated[name] {
+			expected = at.Truncate(time.Second)
+		}
+		if assert.NotNil(t, record.Timestamp, name) {
+			assert.Equal(t, expected, record.Timestamp.Time, name)
+		}
+	}
+}
+
+func TestProcessTime_UnmarshalBSONValue_Unreadable(t *testing.T) {
+	for _, shape := range []interface{}{"yesterday", bson.D{{Key: "when", Value: "now"}}, true} {
+		data, _ := bson.Marshal(bson.D{{Key: "timestamp", Value: shape}})
+
+		var record struct {
+			Timestamp *ProcessTime `bson:"timestamp"`
+		}
+		err := bson.Unmarshal(data, &record)
+		assert.Error(t, err)
+	}
+}
+
+func TestProcessTime_RoundTrip(t *testing.T) {
+	timestamp := NewProcessTimestamp(time.Now())
+
+	data, err := bson.Marshal(bson.D{{Key: "timestamp", Value: timestamp}})
+	assert.NoError(t, err)
+
+	var record struct {
+		Timestamp *ProcessTime `bson:"timestamp"`
+	}
+	err = bson.Unmarshal(data, &record)
+	assert.NoError(t, err)
+	assert.Equal(t, timestamp.Time, record.Timestamp.Time)
+}
+
+func TestFromUnixNumber(t *testing.T) {
+	at := time.Date(2023, 5, 1, 12, 30, 15, 123456789, time.UTC)
+
+	assert.Equal(t, at.Truncate(time.Second), fromUnixNumber(at.Unix()))
+	assert.Equal(t, at.Truncate(time.Millisecond), fromUnixNumber(at.UnixMilli()))
+	assert.Equal(t, at.Truncate(time.Microsecond), fromUnixNumber(at.UnixMicro()))
+	assert.Equal(t, at, fromUnixNumber(at.UnixNano()))
+	assert.Equal(t, time.Unix(0, 0).UTC(), fromUnixNumber(0))
+}
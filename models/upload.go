@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// status of an upload session
+const (
+	UploadStatusUploading  = "uploading"
+	UploadStatusCompleting = "completing"
+	UploadStatusCompleted  = "completed"
+	UploadStatusFailed     = "failed"
+)
+
+// UploadSession is a resumable upload of a challenge archive. The chunks are
+// stored as separate parts that are composed into the archive once all of
+// Length has arrived.
+type UploadSession struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CorId       string             `json:"corId" bson:"corId"`
+	CreatorName string             `json:"creatorName" bson:"creatorName"`
+	ImageName   string             `json:"imageName" bson:"imageName"`
+	ImageTag    string             `json:"imageTag" bson:"imageTag"`
+	FileName    string             `json:"fileName,omitempty" bson:"fileName,omitempty"`
+	Length      int64              `json:"length" bson:"length"`
+	Offset      int64              `json:"offset" bson:"offset"`
+	Parts       []string           `json:"-" bson:"parts"`
+	Status      string             `json:"status" bson:"status"`
+	Violations  []ArchiveViolation `json:"violations,omitempty" bson:"violations,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time          `json:"expiresAt" bson:"expiresAt"`
+
+	// until when a request that is completing the upload holds it
+	ClaimedUntil *time.Time `json:"-" bson:"claimedUntil,omitempty"`
+}
+
+// status of a signed upload
+const (
+	SignedUploadStatusPending    = "pending"
+	SignedUploadStatusConfirming = "confirming"
+	SignedUploadStatusConfirmed  = "confirmed"
+	SignedUploadStatusFailed     = "failed"
+)
+
+// SignedUpload is an archive a client PUTs straight to the bucket with a
+// signed URL, the image is created once the upload is confirmed
+type SignedUpload struct {
+	Id          primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	CorId       string             `json:"corId" bson:"corId"`
+	CreatorName string             `json:"creatorName" bson:"creatorName" validate:"required"`
+	ImageName   string             `json:"imageName" bson:"imageName" validate:"required"`
+	ImageTag    string             `json:"imageTag" bson:"imageTag" validate:"required"`
+	S3Path      string             `json:"s3Path" bson:"s3Path"`
+	Status      string             `json:"status" bson:"status"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time          `json:"expiresAt" bson:"expiresAt"`
+
+	// until when a request that is confirming the upload holds it
+	ClaimedUntil *time.Time `json:"-" bson:"claimedUntil,omitempty"`
+}
+
+// SignedUploadURL tells a client where and how to PUT its archive
+type SignedUploadURL struct {
+	CorId     string            `json:"corId"`
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
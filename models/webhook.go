@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusDelivered = "delivered"
+	WebhookDeliveryStatusFailed    = "failed"
+)
+
+// WebhookSubscription asks for a creator's finished processes to be POSTed
+// to a URL. Events filters on the process event type, empty means all.
+type WebhookSubscription struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CreatorName string             `json:"creatorName" bson:"creatorName" validate:"required"`
+	URL         string             `json:"url" bson:"url" validate:"required,url"`
+	Secret      string             `json:"secret,omitempty" bson:"secret"`
+	Events      []string           `json:"events" bson:"events"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// WebhookAttempt is the log entry of one delivery attempt
+type WebhookAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs int64     `json:"durationMs" bson:"durationMs"`
+}
+
+// WebhookDelivery is a terminal process event to be sent to one subscription
+type WebhookDelivery struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SubscriptionId primitive.ObjectID `json:"subscriptionId" bson:"subscriptionId"`
+	ProcessId      primitive.ObjectID `json:"processId" bson:"processId"`
+	CorId          string             `json:"corId" bson:"corId"`
+	Event          string             `json:"event" bson:"event"`
+	EventStatus    string             `json:"eventStatus" bson:"eventStatus"`
+	URL            string             `json:"url" bson:"url"`
+	Payload        string             `json:"payload" bson:"payload"`
+	Status         string             `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	Log            []WebhookAttempt   `json:"log" bson:"log"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	NextAttemptAt  time.Time          `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	DeliveredAt    *time.Time         `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
+
+// WebhookPayload is the JSON body POSTed to subscribers
+type WebhookPayload struct {
+	DeliveryId  string       `json:"deliveryId"`
+	CorId       string       `json:"corId"`
+	Event       string       `json:"event"`
+	EventStatus string       `json:"eventStatus"`
+	CreatorName string       `json:"creatorName"`
+	Process     ProcessEvent `json:"process"`
+}
@@ -0,0 +1,375 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	reconnectMinBackoff = 1 * time.Second
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// ErrConnectionClosed is returned when a channel is requested from a manager
+// that has been shut down
+var ErrConnectionClosed = errors.New("amqp connection manager is closed")
+
+// pooledChannel is a confirm mode channel along with its return listener
+type pooledChannel struct {
+	ch      *amqp.Channel
+	returns chan amqp.Return
+}
+
+// ConnectionManager owns the AMQP connection, reconnects when the broker
+// drops it and hands out a bounded number of publishing channels
+type ConnectionManager struct {
+	url string
+
+	mu    sync.Mutex
+	conn  *amqp.Connection
+	ready chan struct{} // closed while connected and not blocked
+	hooks []func(*amqp.Connection) error
+
+	// first connect hook that failed on the current connection
+	hookErr error
+
+	idle   chan *pooledChannel
+	slots  chan struct{}
+	closed chan struct{}
+}
+
+func NewConnectionManager(url string, poolSize int) *ConnectionManager {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	return &ConnectionManager{
+		url:    url,
+		ready:  make(chan struct{}),
+		idle:   make(chan *pooledChannel, poolSize),
+		slots:  make(chan struct{}, poolSize),
+		closed: make(chan struct{}),
+	}
+}
+
+// Connect dials the broker and starts watching the connection. If the broker
+// is unreachable the error is returned and dialing continues in the background.
+func (m *ConnectionManager) Connect() error {
+	conn, err := amqp.Dial(m.url)
+	if err != nil {
+		go func() {
+			conn := m.reconnect()
+			if conn != nil {
+				m.watch(conn)
+			}
+		}()
+		return err
+	}
+
+	m.connected(conn)
+	go m.watch(conn)
+	return nil
+}
+
+// OnConnect registers a hook that is run on every (re)connect, e.g. to
+// declare topology or start consumers. It is run right away if the manager
+// is already connected.
+func (m *ConnectionManager) OnConnect(hook func(*amqp.Connection) error) error {
+	m.mu.Lock()
+	m.hooks = append(m.hooks, hook)
+	conn := m.conn
+	m.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	err := hook(conn)
+	if err != nil {
+		m.mu.Lock()
+		if m.hookErr == nil {
+			m.hookErr = err
+		}
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Ready reports whether publishes can currently be served
+func (m *ConnectionManager) Ready() bool {
+	m.mu.Lock()
+	ready := m.ready
+	m.mu.Unlock()
+
+	select {
+	case <-ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Healthy reports whether publishes can be served and every connect hook,
+// such as the topology declaration or a consumer, succeeded
+func (m *ConnectionManager) Healthy() bool {
+	return m.Err() == nil
+}
+
+// Err explains why the manager is not healthy, or returns nil
+func (m *ConnectionManager) Err() error {
+	m.mu.Lock()
+	hookErr := m.hookErr
+	m.mu.Unlock()
+
+	if hookErr != nil {
+		return fmt.Errorf("AMQP connect hook failed: %w", hookErr)
+	}
+	if !m.Ready() {
+		return errors.New("not connected to RabbitMQ")
+	}
+	return nil
+}
+
+// Close shuts the manager and the underlying connection down
+func (m *ConnectionManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.closed:
+		return nil
+	default:
+	}
+	close(m.closed)
+
+	if m.conn == nil {
+		return nil
+	}
+	return m.conn.Close()
+}
+
+// connected swaps in a new connection, runs the hooks and releases waiting
+// publishers
+func (m *ConnectionManager) connected(conn *amqp.Connection) {
+	m.mu.Lock()
+	m.conn = conn
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	var hookErr error
+	for _, hook := range hooks {
+		err := hook(conn)
+		if err != nil {
+			log.Printf("Failed to run AMQP connect hook: %s", err)
+			if hookErr == nil {
+				hookErr = err
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.hookErr = hookErr
+	m.mu.Unlock()
+
+	m.setReady(true)
+}
+
+// setReady opens or closes the gate publishers wait on
+func (m *ConnectionManager) setReady(ready bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.ready:
+		if !ready {
+			m.ready = make(chan struct{})
+		}
+	default:
+		if ready {
+			close(m.ready)
+		}
+	}
+}
+
+// markDown closes the gate if conn is still the current connection, so that
+// publishers wait for the watcher instead of spinning on a dead connection
+func (m *ConnectionManager) markDown(conn *amqp.Connection) {
+	m.mu.Lock()
+	current := m.conn == conn
+	m.mu.Unlock()
+
+	if current {
+		m.setReady(false)
+	}
+}
+
+// watch follows the state of a connection and replaces it once it is lost
+func (m *ConnectionManager) watch(conn *amqp.Connection) {
+	closes := conn.NotifyClose(make(chan *amqp.Error, 1))
+	blocks := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+
+	for {
+		select {
+		case b := <-blocks:
+			if b.Active {
+				log.Printf("RabbitMQ blocked publishing: %s", b.Reason)
+			} else {
+				log.Println("RabbitMQ unblocked publishing")
+			}
+			m.setReady(!b.Active)
+
+		case err := <-closes:
+			m.setReady(false)
+			m.drainIdle()
+
+			select {
+			case <-m.closed:
+				return
+			default:
+			}
+
+			log.Printf("Lost connection to RabbitMQ: %v", err)
+			conn = m.reconnect()
+			if conn == nil {
+				return
+			}
+			closes = conn.NotifyClose(make(chan *amqp.Error, 1))
+			blocks = conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+		}
+	}
+}
+
+// reconnect dials the broker with exponential backoff until it succeeds or
+// the manager is closed
+func (m *ConnectionManager) reconnect() *amqp.Connection {
+	backoff := reconnectMinBackoff
+	for {
+		select {
+		case <-m.closed:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		conn, err := amqp.Dial(m.url)
+		if err != nil {
+			log.Printf("Failed to reconnect to RabbitMQ: %s, retrying in %s", err, backoff)
+			backoff *= 2
+			if backoff > reconnectMaxBackoff {
+				backoff = reconnectMaxBackoff
+			}
+			continue
+		}
+
+		log.Println("Reconnected to RabbitMQ")
+		m.connected(conn)
+		return conn
+	}
+}
+
+// drainIdle closes every idle channel of a lost connection
+func (m *ConnectionManager) drainIdle() {
+	for {
+		select {
+		case pc := <-m.idle:
+			pc.ch.Close()
+		default:
+			return
+		}
+	}
+}
+
+// acquire waits for a free publishing channel, giving up when ctx is done
+func (m *ConnectionManager) acquire(ctx context.Context) (*pooledChannel, error) {
+	select {
+	case m.slots <- struct{}{}:
+	case <-m.closed:
+		return nil, ErrConnectionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		m.mu.Lock()
+		ready, conn := m.ready, m.conn
+		m.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-m.closed:
+			<-m.slots
+			return nil, ErrConnectionClosed
+		case <-ctx.Done():
+			<-m.slots
+			return nil, ctx.Err()
+		}
+
+		// reuse an idle channel if it is still open
+		select {
+		case pc := <-m.idle:
+			if !pc.ch.IsClosed() {
+				return pc, nil
+			}
+			continue
+		default:
+		}
+
+		pc, err := openChannel(conn)
+		if err != nil {
+			if conn.IsClosed() {
+				// wait for the watcher to reconnect
+				m.markDown(conn)
+				continue
+			}
+			<-m.slots
+			return nil, err
+		}
+		return pc, nil
+	}
+}
+
+// release returns a channel to the pool, closing it if it is no longer usable
+func (m *ConnectionManager) release(pc *pooledChannel, healthy bool) {
+	if healthy && !pc.ch.IsClosed() {
+		// a return left behind would be taken for the next publish's
+		pc.drainReturns()
+		m.idle <- pc
+	} else {
+		pc.ch.Close()
+	}
+	<-m.slots
+}
+
+// openChannel opens a confirm mode channel on conn
+func openChannel(conn *amqp.Connection) (*pooledChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	return &pooledChannel{
+		ch:      ch,
+		returns: ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// drainReturns discards the returns that were not read
+func (pc *pooledChannel) drainReturns() {
+	for {
+		select {
+		case <-pc.returns:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// +build integration
+
+package mq
+
+import (
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionManager_HookFailure(t *testing.T) {
+	m := NewConnectionManager("amqp://localhost", 1)
+	assert.False(t, m.Healthy())
+	assert.False(t, m.Ready())
+
+	failing := true
+	m.hooks = []func(*amqp.Connection) error{
+		func(*amqp.Connection) error { return nil },
+		func(*amqp.Connection) error {
+			if failing {
+				return errors.New("PRECONDITION_FAILED")
+			}
+			return nil
+		},
+	}
+
+	// publishes go through, but the failure is reported
+	m.connected(nil)
+	assert.True(t, m.Ready())
+	assert.False(t, m.Healthy())
+	assert.ErrorContains(t, m.Err(), "PRECONDITION_FAILED")
+
+	// cleared once the hooks succeed on a reconnect
+	failing = false
+	m.connected(nil)
+	assert.True(t, m.Healthy())
+	assert.NoError(t, m.Err())
+}
+
+func TestPooledChannel_DrainReturns(t *testing.T) {
+	pc := &pooledChannel{returns: make(chan amqp.Return, 1)}
+	pc.returns <- amqp.Return{RoutingKey: "platform.fromService.unknown"}
+
+	pc.drainReturns()
+
+	select {
+	case <-pc.returns:
+		t.Fatal("return left in the channel")
+	default:
+	}
+}
@@ -0,0 +1,321 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/models"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrMalformedMessage marks a delivery that can never be processed and
+// should be dropped rather than requeued
+var ErrMalformedMessage = errors.New("malformed message")
+
+// ErrConflictingReply marks a reply whose record clashes with one created by
+// another process, such as an image tag that already exists. Retrying cannot
+// resolve it, so it is logged and acknowledged.
+var ErrConflictingReply = errors.New("conflicting reply")
+
+// ReplyMessage is the body sent back by the downstream services on the
+// platform.toService.* routes. Only the fields relevant to the event are set.
+type ReplyMessage struct {
+	CorId             string   `json:"corId"`
+	Event             string   `json:"event"`
+	EventStatus       string   `json:"eventStatus"`
+	CreatorName       string   `json:"creatorName"`
+	ImageName         string   `json:"imageName"`
+	ImageTag          string   `json:"imageTag"`
+	ImageRegistryLink string   `json:"imageRegistryLink"`
+	ChallengeName     string   `json:"challengeName"`
+	Duration          int      `json:"duration"`
+	Participants      []string `json:"participants"`
+	Participant       string   `json:"participant"`
+	Token             string   `json:"token"`
+	SSHkey            string   `json:"sshkey"`
+	IpAddress         string   `json:"ipaddress"`
+	Port              string   `json:"port"`
+}
+
+// Consumer materializes image, challenge, attempt and process records from
+// the replies of the downstream services
+type Consumer struct {
+	ImageCollection     collections.ImageCollection
+	ChallengeCollection collections.ChallengeCollection
+	AttemptCollection   collections.AttemptCollection
+	ProcessCollection   collections.ProcessCollection
+}
+
+func NewConsumer(client *mongo.Client) *Consumer {
+	return &Consumer{
+		ImageCollection:     *collections.NewImageCollection(client),
+		ChallengeCollection: *collections.NewChallengeCollection(client),
+		AttemptCollection:   *collections.NewAttemptCollection(client),
+		ProcessCollection:   *collections.NewProcessCollection(client),
+	}
+}
+
+// Start consumes the reply queue in the background, resuming on every reconnect
+func (t *Consumer) Start() error {
+	return AMQP_MANAGER.OnConnect(t.consume)
+}
+
+// consume consumes the reply queue declared by declareTopology on conn
+func (t *Consumer) consume(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = ch.Qos(10, 0, false)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := ch.Consume(
+		QUEUE_PLATFORM_TO, // queue
+		"platform-api",    // consumer
+		false,             // auto-ack
+		false,             // exclusive
+		false,             // no-local
+		false,             // no-wait
+		nil,               // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range deliveries {
+			t.handle(d)
+		}
+		log.Println("Consumer delivery channel closed")
+	}()
+
+	log.Printf("Consuming replies from %s", QUEUE_PLATFORM_TO)
+	return nil
+}
+
+// handle processes a single delivery and acknowledges it. Malformed messages
+// are dead-lettered straight away, anything else that fails is retried with
+// backoff until the retries are exhausted.
+func (t *Consumer) handle(d amqp.Delivery) {
+	routingKey := OriginalRoutingKey(d)
+	err := t.Process(routingKey, d.Body)
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+
+	if errors.Is(err, ErrConflictingReply) {
+		log.Printf("Dropped %s: %s", routingKey, err)
+		d.Ack(false)
+		return
+	}
+
+	log.Printf("Failed to process %s: %s", routingKey, err)
+	if errors.Is(err, ErrMalformedMessage) {
+		d.Nack(false, false)
+		return
+	}
+
+	err = Retry(d, QUEUE_PLATFORM_TO)
+	if err != nil {
+		if !errors.Is(err, ErrRetriesExhausted) {
+			log.Printf("Failed to schedule retry for %s: %s", routingKey, err)
+		}
+		d.Nack(false, false)
+		return
+	}
+	d.Ack(false)
+}
+
+// Process applies a reply received on the given routing key
+func (t *Consumer) Process(routingKey string, body []byte) error {
+	var msg ReplyMessage
+	err := json.Unmarshal(UnwrapPayload(body), &msg)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformedMessage, err)
+	}
+
+	if msg.CorId == "" || msg.EventStatus == "" {
+		return fmt.Errorf("%w: corId and eventStatus are required", ErrMalformedMessage)
+	}
+
+	// a reply must move the process along its state machine
+	event := replyEvent(routingKey, &msg)
+	duplicate, err := t.checkTransition(msg.CorId, event, msg.EventStatus)
+	if err != nil {
+		return err
+	}
+
+	switch routingKey {
+	case ROUTE_IMAGE_BUILT:
+		err = t.applyImage(&msg)
+	case ROUTE_CHALLENGE_CREATED:
+		err = t.applyChallenge(&msg)
+	case ROUTE_CHALLENGE_STARTED:
+		err = t.applyAttempt(&msg)
+	case ROUTE_PROCESS_CANCELLED:
+		// only recorded on the timeline
+	default:
+		return fmt.Errorf("%w: unknown routing key %s", ErrMalformedMessage, routingKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	// a redelivered reply has already been recorded
+	if duplicate {
+		return nil
+	}
+	return t.recordProcess(event, EnvelopeMessageId(body), &msg)
+}
+
+// checkTransition validates the reply against the latest recorded status of
+// the process, and reports whether it repeats that status
+func (t *Consumer) checkTransition(corId string, event string, eventStatus string) (bool, error) {
+	from := ""
+	latest, statusCode, err := t.ProcessCollection.GetLatestStatusByCorId(corId)
+	if err != nil && statusCode != http.StatusNotFound {
+		return false, err
+	}
+	if latest != nil && latest.EventStatus != nil {
+		from = *latest.EventStatus
+	}
+
+	if from == eventStatus {
+		return true, nil
+	}
+	err = models.ValidateTransition(event, from, eventStatus)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrMalformedMessage, err)
+	}
+	return false, nil
+}
+
+func (t *Consumer) applyImage(msg *ReplyMessage) error {
+	if msg.EventStatus != models.EventStatusImageCreated {
+		return nil
+	}
+
+	statusCode, err := t.ImageCollection.UpsertImage(&models.Image{
+		CorId:             msg.CorId,
+		CreatorName:       msg.CreatorName,
+		ImageName:         msg.ImageName,
+		ImageTag:          msg.ImageTag,
+		ImageRegistryLink: msg.ImageRegistryLink,
+	})
+	return storeError(statusCode, err)
+}
+
+func (t *Consumer) applyChallenge(msg *ReplyMessage) error {
+	if msg.EventStatus != models.EventStatusChallengeCreated {
+		return nil
+	}
+
+	statusCode, err := t.ChallengeCollection.UpsertChallenge(&models.Challenge{
+		CorID:             msg.CorId,
+		ChallengeName:     msg.ChallengeName,
+		CreatorName:       msg.CreatorName,
+		ImageName:         msg.ImageName,
+		ImageTag:          msg.ImageTag,
+		ImageRegistryLink: msg.ImageRegistryLink,
+		Duration:          msg.Duration,
+		Participants:      msg.Participants,
+	})
+	return storeError(statusCode, err)
+}
+
+func (t *Consumer) applyAttempt(msg *ReplyMessage) error {
+	if msg.EventStatus != models.EventStatusChallengeStarted {
+		return nil
+	}
+
+	statusCode, err := t.AttemptCollection.UpsertAttempt(&models.Attempt{
+		ChallengeName:     msg.ChallengeName,
+		CreatorName:       msg.CreatorName,
+		Participant:       msg.Participant,
+		Token:             msg.Token,
+		ImageRegistryLink: msg.ImageRegistryLink,
+		SSHkey:            msg.SSHkey,
+		IpAddress:         msg.IpAddress,
+		Port:              msg.Port,
+	})
+	return storeError(statusCode, err)
+}
+
+// replyEvent returns the event type of the process a reply belongs to
+func replyEvent(routingKey string, msg *ReplyMessage) string {
+	// cancellations are recorded against the event they cancelled
+	if routingKey == ROUTE_PROCESS_CANCELLED && msg.Event != "" {
+		return msg.Event
+	}
+	return eventFromRoutingKey(routingKey)
+}
+
+// recordProcess appends the reply to the process_engine timeline
+func (t *Consumer) recordProcess(event string, messageId string, msg *ReplyMessage) error {
+	process := models.Process{
+		MessageId:     optional(messageId),
+		Timestamp:     models.NewProcessTimestamp(time.Now()),
+		CorId:         &msg.CorId,
+		Event:         &event,
+		EventStatus:   &msg.EventStatus,
+		CreatorName:   optional(msg.CreatorName),
+		ChallengeName: optional(msg.ChallengeName),
+		ImageName:     optional(msg.ImageName),
+		ImageTag:      optional(msg.ImageTag),
+		Participant:   optional(msg.Participant),
+	}
+	if len(msg.Participants) > 0 {
+		process.Participants = &msg.Participants
+	}
+
+	statusCode, err := t.ProcessCollection.InsertProcess(&process)
+
+	// only the message id is unique, the message was recorded already
+	if statusCode == http.StatusConflict {
+		return nil
+	}
+	return storeError(statusCode, err)
+}
+
+// storeError drops requests the store rejected as invalid or conflicting,
+// everything else is retried
+func storeError(statusCode int, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch statusCode {
+	case http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", ErrMalformedMessage, err)
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %s", ErrConflictingReply, err)
+	}
+	return err
+}
+
+// eventFromRoutingKey returns the event type the reply routing key belongs to
+func eventFromRoutingKey(routingKey string) string {
+	for event, routes := range TOPOLOGY.Events {
+		if routes.Reply == routingKey {
+			return event
+		}
+	}
+	return routingKey[strings.LastIndex(routingKey, ".")+1:]
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
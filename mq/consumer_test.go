@@ -0,0 +1,205 @@
+// +build integration
+
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"platform_api/configs"
+	"platform_api/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const consumerTestCreator = "consumer-test"
+
+var testConsumer = NewConsumer(configs.Client)
+
+func clear_consumer_records() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: consumerTestCreator}}
+	testConsumer.ImageCollection.Collection.DeleteMany(ctx, filter)
+	testConsumer.ProcessCollection.Collection.DeleteMany(ctx, filter)
+}
+
+func imageReply(corId string, imageTag string) []byte {
+	body, _ := json.Marshal(ReplyMessage{
+		CorId:             corId,
+		EventStatus:       models.EventStatusImageCreated,
+		CreatorName:       consumerTestCreator,
+		ImageName:         "consumer-image",
+		ImageTag:          imageTag,
+		ImageRegistryLink: "registry/consumer-image:" + imageTag,
+	})
+	return body
+}
+
+func TestProcess_ImageCreated(t *testing.T) {
+	clear_consumer_records()
+	defer clear_consumer_records()
+
+	corId := uuid.New().String()
+
+	err := testConsumer.Process(ROUTE_IMAGE_BUILT, imageReply(corId, "v1"))
+	assert.NoError(t, err)
+
+	image, _, err := testConsumer.ImageCollection.GetImageByCorId(corId)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", image.ImageTag)
+
+	latest, _, err := testConsumer.ProcessCollection.GetLatestStatusByCorId(corId)
+	assert.NoError(t, err)
+	assert.Equal(t, models.EventStatusImageCreated, *latest.EventStatus)
+
+	// a redelivery is applied again without a second timeline record
+	err = testConsumer.Process(ROUTE_IMAGE_BUILT, imageReply(corId, "v1"))
+	assert.NoError(t, err)
+
+	events, _, err := testConsumer.ProcessCollection.GetProcessEventsByCorId(corId)
+	assert.NoError(t, err)
+	assert.Len(t, *events, 1)
+}
+
+func TestProcess_Enveloped(t *testing.T) {
+	clear_consumer_records()
+	defer clear_consumer_records()
+
+	corId := uuid.New().String()
+	env, err := NewEnvelope(EVENT_IMAGE_CREATE, corId, json.RawMessage(imageReply(corId, "v1")))
+	assert.NoError(t, err)
+	body, _ := json.Marshal(env)
+
+	err = testConsumer.Process(ROUTE_IMAGE_BUILT, body)
+	assert.NoError(t, err)
+
+	_, statusCode, _ := testConsumer.ImageCollection.GetImageByCorId(corId)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestProcess_SameTimestamp(t *testing.T) {
+	clear_consumer_records()
+	defer clear_consumer_records()
+
+	// two statuses recorded within the same millisecond are both kept
+	corId := uuid.New().String()
+	event := models.EventImageCreate
+	creatorName := consumerTestCreator
+	timestamp := models.NewProcessTimestamp(time.Now().Add(-time.Second))
+	for _, eventStatus := range []string{models.EventStatusImageCreating, models.EventStatusCancelRequested} {
+		status := eventStatus
+		_, err := testConsumer.ProcessCollection.InsertProcess(&models.Process{
+			Timestamp:   timestamp,
+			CorId:       &corId,
+			Event:       &event,
+			EventStatus: &status,
+			CreatorName: &creatorName,
+		})
+		assert.NoError(t, err)
+	}
+
+	// while a redelivered message is recorded once
+	msg := ReplyMessage{CorId: corId, EventStatus: models.EventStatusCancelled, CreatorName: consumerTestCreator}
+	messageId := uuid.NewString()
+	for i := 0; i < 2; i++ {
+		err := testConsumer.recordProcess(event, messageId, &msg)
+		assert.NoError(t, err)
+	}
+
+	events, _, err := testConsumer.ProcessCollection.GetProcessEventsByCorId(corId)
+	assert.NoError(t, err)
+	assert.Len(t, *events, 3)
+}
+
+func TestProcess_DuplicateImageTag(t *testing.T) {
+	clear_consumer_records()
+	defer clear_consumer_records()
+
+	err := testConsumer.Process(ROUTE_IMAGE_BUILT, imageReply(uuid.New().String(), "v1"))
+	assert.NoError(t, err)
+
+	// another build of the same tag cannot be materialized, nor retried
+	corId := uuid.New().String()
+	err = testConsumer.Process(ROUTE_IMAGE_BUILT, imageReply(corId, "v1"))
+	assert.True(t, errors.Is(err, ErrConflictingReply))
+
+	_, statusCode, _ := testConsumer.ImageCollection.GetImageByCorId(corId)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestProcess_Malformed(t *testing.T) {
+	err := testConsumer.Process(ROUTE_IMAGE_BUILT, []byte("not json"))
+	assert.True(t, errors.Is(err, ErrMalformedMessage))
+
+	body, _ := json.Marshal(ReplyMessage{EventStatus: models.EventStatusImageCreated})
+	err = testConsumer.Process(ROUTE_IMAGE_BUILT, body)
+	assert.True(t, errors.Is(err, ErrMalformedMessage))
+
+	err = testConsumer.Process("platform.toService.unknown", imageReply(uuid.New().String(), "v1"))
+	assert.True(t, errors.Is(err, ErrMalformedMessage))
+}
+
+func TestProcess_InvalidTransition(t *testing.T) {
+	clear_consumer_records()
+	defer clear_consumer_records()
+
+	corId := uuid.New().String()
+	err := testConsumer.Process(ROUTE_IMAGE_BUILT, imageReply(corId, "v1"))
+	assert.NoError(t, err)
+
+	// a finished process cannot fail afterwards
+	body, _ := json.Marshal(ReplyMessage{
+		CorId:       corId,
+		EventStatus: models.EventStatusImageCreateFailed,
+		CreatorName: consumerTestCreator,
+	})
+	err = testConsumer.Process(ROUTE_IMAGE_BUILT, body)
+	assert.True(t, errors.Is(err, ErrMalformedMessage))
+}
+
+func TestProcess_LateSuccess(t *testing.T) {
+	clear_consumer_records()
+	defer clear_consumer_records()
+
+	corId := uuid.New().String()
+	event := models.EventImageCreate
+	creatorName := consumerTestCreator
+	for i, eventStatus := range []string{models.EventStatusImageCreating, models.EventStatusTimedOut} {
+		status := eventStatus
+		_, err := testConsumer.ProcessCollection.InsertProcess(&models.Process{
+			Timestamp:   models.NewProcessTimestamp(time.Now().Add(time.Duration(i-2) * time.Second)),
+			CorId:       &corId,
+			Event:       &event,
+			EventStatus: &status,
+			CreatorName: &creatorName,
+		})
+		assert.NoError(t, err)
+	}
+
+	// a late failure changes nothing
+	body, _ := json.Marshal(ReplyMessage{
+		CorId:       corId,
+		EventStatus: models.EventStatusImageCreateFailed,
+		CreatorName: consumerTestCreator,
+	})
+	err := testConsumer.Process(ROUTE_IMAGE_BUILT, body)
+	assert.True(t, errors.Is(err, ErrMalformedMessage))
+
+	// but the image was built after all, it is materialized
+	err = testConsumer.Process(ROUTE_IMAGE_BUILT, imageReply(corId, "late"))
+	assert.NoError(t, err)
+
+	_, statusCode, _ := testConsumer.ImageCollection.GetImageByCorId(corId)
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	latest, _, err := testConsumer.ProcessCollection.GetLatestStatusByCorId(corId)
+	assert.NoError(t, err)
+	assert.Equal(t, models.EventStatusImageCreated, *latest.EventStatus)
+}
@@ -0,0 +1,218 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"platform_api/collections"
+	"platform_api/models"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// header counting how many times a message was retried
+	HEADER_RETRY_COUNT = "x-retry-count"
+
+	// header keeping the routing key a retried message was first published with
+	HEADER_ORIGINAL_ROUTING_KEY = "x-original-routing-key"
+)
+
+// ErrRetriesExhausted is returned by Retry once a message used up every delay
+var ErrRetriesExhausted = errors.New("retries exhausted")
+
+// RetryDeclare declares a retry queue per delay of the topology for every
+// queue consumed by the platform
+func RetryDeclare(ch *amqp.Channel) error {
+	for _, queue := range retryQueues() {
+		for _, delay := range TOPOLOGY.Delays {
+			name := retryQueueName(queue, delay)
+
+			// retry queues hold a message for their TTL and then dead-letter
+			// it straight back into the queue it failed on
+			_, err := ch.QueueDeclare(
+				name,  // name
+				true,  // durable
+				false, // delete when unused
+				false, // exclusive
+				false, // no-wait
+				amqp.Table{
+					"x-message-ttl":             delay.Milliseconds(),
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": queue,
+				}, // arguments
+			)
+			if err != nil {
+				return err
+			}
+
+			err = ch.QueueBind(name, name, EXCHANGE_RETRY, false, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// retryQueues lists the queues whose failed messages are retried
+func retryQueues() []string {
+	return []string{QUEUE_PLATFORM_TO}
+}
+
+// Retry republishes a failed delivery of queue to its next retry queue.
+// It returns ErrRetriesExhausted once every delay was used.
+func Retry(d amqp.Delivery, queue string) error {
+	count := retryCount(d.Headers)
+	if count >= len(TOPOLOGY.Delays) {
+		return ErrRetriesExhausted
+	}
+
+	headers := map[string]interface{}{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HEADER_RETRY_COUNT] = int32(count + 1)
+	headers[HEADER_ORIGINAL_ROUTING_KEY] = OriginalRoutingKey(d)
+
+	return Pub(EXCHANGE_RETRY, retryQueueName(queue, TOPOLOGY.Delays[count]), Message{
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Type:          d.Type,
+		Timestamp:     d.Timestamp,
+		Headers:       headers,
+		Body:          d.Body,
+	})
+}
+
+// OriginalRoutingKey returns the routing key a delivery was first published
+// with, before any retry
+func OriginalRoutingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[HEADER_ORIGINAL_ROUTING_KEY].(string); ok && key != "" {
+		return key
+	}
+	return d.RoutingKey
+}
+
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queue, delay)
+}
+
+func retryCount(headers amqp.Table) int {
+	switch count := headers[HEADER_RETRY_COUNT].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	default:
+		return 0
+	}
+}
+
+// DeadLetterArchiver moves messages from the parking-lot queue into the
+// dead_letter collection so they can be inspected and re-driven
+type DeadLetterArchiver struct {
+	DeadLetterCollection collections.DeadLetterCollection
+}
+
+func NewDeadLetterArchiver(client *mongo.Client) *DeadLetterArchiver {
+	return &DeadLetterArchiver{DeadLetterCollection: *collections.NewDeadLetterCollection(client)}
+}
+
+// Start consumes the parking lot in the background, resuming on every reconnect
+func (t *DeadLetterArchiver) Start() error {
+	return AMQP_MANAGER.OnConnect(t.consume)
+}
+
+func (t *DeadLetterArchiver) consume(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := ch.Consume(
+		QUEUE_PARKING_LOT,          // queue
+		"platform-api-dead-letter", // consumer
+		false,                      // auto-ack
+		false,                      // exclusive
+		false,                      // no-local
+		false,                      // no-wait
+		nil,                        // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range deliveries {
+			_, err := t.DeadLetterCollection.InsertDeadLetter(deadLetterFromDelivery(d))
+			if err != nil {
+				log.Printf("Failed to archive dead letter %s: %s", d.MessageId, err)
+				d.Nack(false, true)
+				continue
+			}
+			d.Ack(false)
+		}
+		log.Println("Dead letter delivery channel closed")
+	}()
+
+	return nil
+}
+
+// deadLetterFromDelivery reads the origin of a dead-lettered message from
+// its x-death header
+func deadLetterFromDelivery(d amqp.Delivery) *models.DeadLetter {
+	dl := models.DeadLetter{
+		MessageId:      d.MessageId,
+		CorId:          d.CorrelationId,
+		Type:           d.Type,
+		Exchange:       d.Exchange,
+		RoutingKey:     OriginalRoutingKey(d),
+		Body:           string(d.Body),
+		DeadLetteredAt: time.Now().UTC(),
+	}
+
+	deaths, _ := d.Headers["x-death"].([]interface{})
+	if len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			dl.Queue, _ = death["queue"].(string)
+			dl.Reason, _ = death["reason"].(string)
+			if ex, ok := death["exchange"].(string); ok {
+				dl.Exchange = ex
+			}
+			if _, retried := d.Headers[HEADER_ORIGINAL_ROUTING_KEY]; !retried {
+				if keys, ok := death["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+					if key, ok := keys[0].(string); ok {
+						dl.RoutingKey = key
+					}
+				}
+			}
+			if count, ok := death["count"].(int64); ok {
+				dl.Count = count
+			}
+		}
+	}
+	dl.Retries = retryCount(d.Headers)
+
+	return &dl
+}
+
+// Redrive publishes a dead letter back to the exchange it was dead-lettered from
+func Redrive(publisher Publisher, dl *models.DeadLetter) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return publisher.Publish(ctx, EXCHANGE_TOPIC_ROUTER, dl.RoutingKey, Message{
+		MessageId:     dl.MessageId,
+		CorrelationId: dl.CorId,
+		Type:          dl.Type,
+		Timestamp:     time.Now().UTC(),
+		Body:          []byte(dl.Body),
+	})
+}
@@ -0,0 +1,116 @@
+package mq
+
+import (
+	"encoding/json"
+	"platform_api/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PRODUCER       = "platform-api"
+	SCHEMA_VERSION = 1
+
+	EVENT_IMAGE_CREATE     = models.EventImageCreate
+	EVENT_CHALLENGE_CREATE = models.EventChallengeCreate
+	EVENT_CHALLENGE_START  = models.EventChallengeStart
+	EVENT_PROCESS_CANCEL   = "processCancel"
+	EVENT_PROCESS_TIMEOUT  = "processTimeout"
+	EVENT_IMAGE_DELETE     = "imageDelete"
+)
+
+// Envelope wraps every payload published by the platform so consumers can
+// route, dedupe and trace messages without knowing the payload shape
+type Envelope struct {
+	MessageId     string          `json:"messageId"`
+	CorId         string          `json:"corId"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schemaVersion"`
+	ProducedAt    time.Time       `json:"producedAt"`
+	Producer      string          `json:"producer"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Message is a body along with the metadata set on the AMQP publishing
+type Message struct {
+	MessageId     string
+	CorrelationId string
+	Type          string
+	Timestamp     time.Time
+	Headers       map[string]interface{}
+	Body          []byte
+}
+
+// NewEnvelope wraps payload as an event of the given type for corId
+func NewEnvelope(eventType string, corId string, payload interface{}) (*Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		MessageId:     uuid.NewString(),
+		CorId:         corId,
+		Type:          eventType,
+		SchemaVersion: SCHEMA_VERSION,
+		ProducedAt:    time.Now().UTC(),
+		Producer:      PRODUCER,
+		Payload:       data,
+	}, nil
+}
+
+// Message marshals the envelope into a message ready to be published
+func (t *Envelope) Message() (Message, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		MessageId:     t.MessageId,
+		CorrelationId: t.CorId,
+		Type:          t.Type,
+		Timestamp:     t.ProducedAt,
+		Body:          body,
+	}, nil
+}
+
+// OutboxMessage marshals the envelope into an outbox message for the route
+func (t *Envelope) OutboxMessage(exchange string, key string) (*models.OutboxMessage, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OutboxMessage{
+		MessageId:  t.MessageId,
+		CorId:      t.CorId,
+		Type:       t.Type,
+		Exchange:   exchange,
+		RoutingKey: key,
+		Body:       string(body),
+	}, nil
+}
+
+// UnwrapPayload returns the payload of an enveloped body, or the body itself
+// when it was published without an envelope
+func UnwrapPayload(body []byte) []byte {
+	var env Envelope
+	err := json.Unmarshal(body, &env)
+	if err != nil || env.SchemaVersion == 0 || len(env.Payload) == 0 {
+		return body
+	}
+	return env.Payload
+}
+
+// EnvelopeMessageId returns the message id of an enveloped body, or an empty
+// string when it was published without an envelope
+func EnvelopeMessageId(body []byte) string {
+	var env Envelope
+	err := json.Unmarshal(body, &env)
+	if err != nil || env.SchemaVersion == 0 {
+		return ""
+	}
+	return env.MessageId
+}
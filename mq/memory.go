@@ -0,0 +1,101 @@
+package mq
+
+import (
+	"context"
+	"sync"
+)
+
+// PublishedMessage is a message recorded by the MemoryPublisher
+type PublishedMessage struct {
+	Exchange   string
+	RoutingKey string
+	Message
+}
+
+// MemoryPublisher is an in-memory Publisher for tests and local development.
+// It records every message it accepts and can be told to fail publishes.
+type MemoryPublisher struct {
+	mu        sync.Mutex
+	messages  []PublishedMessage
+	err       error
+	routeErrs map[string]error
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{routeErrs: map[string]error{}}
+}
+
+func (t *MemoryPublisher) Publish(ctx context.Context, ex string, key string, msg Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if t.err != nil {
+		return t.err
+	}
+	if err, ok := t.routeErrs[key]; ok {
+		return err
+	}
+
+	msg.Body = append([]byte(nil), msg.Body...)
+	t.messages = append(t.messages, PublishedMessage{
+		Exchange:   ex,
+		RoutingKey: key,
+		Message:    msg,
+	})
+	return nil
+}
+
+// Messages returns the messages published to the exchange with the routing key
+func (t *MemoryPublisher) Messages(ex string, key string) []PublishedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var messages []PublishedMessage
+	for _, msg := range t.messages {
+		if msg.Exchange == ex && msg.RoutingKey == key {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// All returns every published message in order
+func (t *MemoryPublisher) All() []PublishedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]PublishedMessage(nil), t.messages...)
+}
+
+// FailWith makes every following publish return err, nil restores it
+func (t *MemoryPublisher) FailWith(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.err = err
+}
+
+// FailRoute makes publishes on the routing key return err, nil restores it
+func (t *MemoryPublisher) FailRoute(key string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err == nil {
+		delete(t.routeErrs, key)
+		return
+	}
+	t.routeErrs[key] = err
+}
+
+// Reset forgets recorded messages and simulated failures
+func (t *MemoryPublisher) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+	t.err = nil
+	t.routeErrs = map[string]error{}
+}
@@ -0,0 +1,113 @@
+package mq
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	outboxMinBackoff = 2 * time.Second
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+// Dispatch publishes an outbox message and records the outcome. On failure
+// the message stays pending and is retried by the OutboxRelay, unless the
+// broker refused it. A spooled message also stays pending until the spool
+// replays it, so it is not lost with the disk of this instance.
+func Dispatch(publisher Publisher, outbox collections.OutboxCollection, msg *models.OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := publisher.Publish(ctx, msg.Exchange, msg.RoutingKey, Message{
+		MessageId:     msg.MessageId,
+		CorrelationId: msg.CorId,
+		Type:          msg.Type,
+		Timestamp:     msg.CreatedAt,
+		Body:          []byte(msg.Body),
+	})
+	if IsRefused(err) {
+		_, markErr := outbox.MarkRefused(msg, err)
+		if markErr != nil {
+			log.Printf("Failed to record outbox refusal for %s: %s", msg.CorId, markErr)
+		}
+		return err
+	}
+	if err != nil {
+		_, markErr := outbox.MarkFailed(msg, err, time.Now().Add(outboxBackoff(msg.Attempts)))
+		if markErr != nil {
+			log.Printf("Failed to record outbox failure for %s: %s", msg.CorId, markErr)
+		}
+		return err
+	}
+
+	_, err = outbox.MarkDispatched(msg)
+	if err != nil {
+		// the message reached the MQ, it will at worst be delivered again
+		log.Printf("Failed to mark outbox message %s as dispatched: %s", msg.CorId, err)
+	}
+	return nil
+}
+
+// OutboxRelay drains pending outbox messages to the MQ
+type OutboxRelay struct {
+	OutboxCollection collections.OutboxCollection
+	Publisher        Publisher
+	Interval         time.Duration
+}
+
+func NewOutboxRelay(client *mongo.Client, publisher Publisher, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		OutboxCollection: *collections.NewOutboxCollection(client),
+		Publisher:        publisher,
+		Interval:         interval,
+	}
+}
+
+// Start polls the outbox in the background
+func (t *OutboxRelay) Start() {
+	go func() {
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			t.drain()
+		}
+	}()
+}
+
+// drain dispatches due messages until none are left
+func (t *OutboxRelay) drain() {
+	for {
+		msg, statusCode, err := t.OutboxCollection.ClaimNextPending()
+		if err != nil {
+			if statusCode != http.StatusNotFound {
+				log.Printf("Failed to claim outbox message: %s", err)
+			}
+			return
+		}
+
+		err = Dispatch(t.Publisher, t.OutboxCollection, msg)
+		if err != nil {
+			log.Printf("Failed to relay %s (attempt %d): %s", msg.CorId, msg.Attempts+1, err)
+		}
+	}
+}
+
+// outboxBackoff returns the delay before the next attempt, doubling with
+// every failed attempt
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxMinBackoff
+	for i := 0; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,143 @@
+// +build integration
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"platform_api/collections"
+	"platform_api/configs"
+	"platform_api/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const outboxTestRoute = "platform.fromService.outboxTest"
+
+var testOutbox = *collections.NewOutboxCollection(configs.Client)
+
+func insert_outbox_message(t *testing.T) *models.OutboxMessage {
+	env, err := NewEnvelope(EVENT_IMAGE_CREATE, uuid.New().String(), map[string]string{"imageName": "outbox"})
+	assert.NoError(t, err)
+	msg, err := env.OutboxMessage(EXCHANGE_TOPIC_ROUTER, outboxTestRoute)
+	assert.NoError(t, err)
+
+	_, err = testOutbox.InsertMessage(msg)
+	assert.NoError(t, err)
+	return msg
+}
+
+func get_outbox_message(t *testing.T, msg *models.OutboxMessage) models.OutboxMessage {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stored models.OutboxMessage
+	err := testOutbox.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: msg.Id}}).Decode(&stored)
+	assert.NoError(t, err)
+	return stored
+}
+
+func clear_outbox_messages() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	testOutbox.Collection.DeleteMany(ctx, bson.D{{Key: "routingKey", Value: outboxTestRoute}})
+}
+
+func TestDispatch(t *testing.T) {
+	defer clear_outbox_messages()
+	publisher := NewMemoryPublisher()
+	msg := insert_outbox_message(t)
+
+	err := Dispatch(publisher, testOutbox, msg)
+	assert.NoError(t, err)
+
+	published := publisher.Messages(EXCHANGE_TOPIC_ROUTER, outboxTestRoute)
+	assert.Len(t, published, 1)
+	assert.Equal(t, msg.MessageId, published[0].MessageId)
+	assert.Equal(t, msg.Body, string(published[0].Body))
+
+	stored := get_outbox_message(t, msg)
+	assert.Equal(t, models.OutboxStatusDispatched, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.NotNil(t, stored.DispatchedAt)
+}
+
+func TestDispatch_Failed(t *testing.T) {
+	defer clear_outbox_messages()
+	publisher := NewMemoryPublisher()
+	publisher.FailWith(ErrConfirmTimeout)
+	msg := insert_outbox_message(t)
+
+	err := Dispatch(publisher, testOutbox, msg)
+	assert.True(t, errors.Is(err, ErrConfirmTimeout))
+
+	// left for the relay to retry after a backoff
+	stored := get_outbox_message(t, msg)
+	assert.Equal(t, models.OutboxStatusPending, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.Equal(t, ErrConfirmTimeout.Error(), stored.LastError)
+	assert.True(t, stored.NextAttemptAt.After(time.Now()))
+}
+
+func TestDispatch_Refused(t *testing.T) {
+	defer clear_outbox_messages()
+	publisher := NewMemoryPublisher()
+	publisher.FailWith(ErrUnroutable)
+	msg := insert_outbox_message(t)
+
+	err := Dispatch(publisher, testOutbox, msg)
+	assert.True(t, errors.Is(err, ErrUnroutable))
+
+	// the relay does not retry what the broker refused
+	stored := get_outbox_message(t, msg)
+	assert.Equal(t, models.OutboxStatusRefused, stored.Status)
+	assert.Equal(t, ErrUnroutable.Error(), stored.LastError)
+}
+
+func TestOutboxRelay_Retries(t *testing.T) {
+	defer clear_outbox_messages()
+	publisher := NewMemoryPublisher()
+	publisher.FailWith(ErrConfirmTimeout)
+	msg := insert_outbox_message(t)
+
+	relay := OutboxRelay{OutboxCollection: testOutbox, Publisher: publisher, Interval: time.Second}
+
+	// not due yet, the first attempt is still leased to the request
+	relay.drain()
+	assert.Empty(t, publisher.All())
+	assert.Equal(t, 0, get_outbox_message(t, msg).Attempts)
+
+	// due but the MQ is still down
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	due := bson.D{{Key: "$set", Value: bson.D{{Key: "nextAttemptAt", Value: time.Now().Add(-time.Second)}}}}
+	_, err := testOutbox.Collection.UpdateByID(ctx, msg.Id, due)
+	assert.NoError(t, err)
+
+	relay.drain()
+	stored := get_outbox_message(t, msg)
+	assert.Equal(t, models.OutboxStatusPending, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+
+	// due again once the MQ is back
+	publisher.FailWith(nil)
+	_, err = testOutbox.Collection.UpdateByID(ctx, msg.Id, due)
+	assert.NoError(t, err)
+
+	relay.drain()
+	stored = get_outbox_message(t, msg)
+	assert.Equal(t, models.OutboxStatusDispatched, stored.Status)
+	assert.Equal(t, 2, stored.Attempts)
+	assert.Len(t, publisher.Messages(EXCHANGE_TOPIC_ROUTER, outboxTestRoute), 1)
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, outboxMinBackoff, outboxBackoff(0))
+	assert.Equal(t, 4*time.Second, outboxBackoff(1))
+	assert.Equal(t, outboxMaxBackoff, outboxBackoff(20))
+}
@@ -0,0 +1,70 @@
+package mq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"platform_api/configs"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// policyRequest is the body of PUT /api/policies/{vhost}/{name}
+type policyRequest struct {
+	Pattern    string                 `json:"pattern"`
+	ApplyTo    string                 `json:"apply-to,omitempty"`
+	Priority   int                    `json:"priority"`
+	Definition map[string]interface{} `json:"definition"`
+}
+
+// managementURL returns the URL of a management API path on the broker
+func managementURL(path string) string {
+	return fmt.Sprintf("http://%s:%s%s", configs.AMQP_HOSTNAME, configs.AMQP_MANAGEMENT_PORT, path)
+}
+
+// applyPolicies sets the policies of the topology through the management API.
+// Policies reach queues declared by older versions, which cannot be
+// redeclared with new arguments such as a dead-letter exchange.
+func applyPolicies(conn *amqp.Connection) error {
+	client := http.Client{Timeout: 10 * time.Second}
+
+	for _, p := range TOPOLOGY.Policies {
+		body, err := json.Marshal(policyRequest{
+			Pattern:    p.Pattern,
+			ApplyTo:    p.ApplyTo,
+			Priority:   p.Priority,
+			Definition: p.Definition,
+		})
+		if err != nil {
+			return fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+
+		req, err := http.NewRequest(
+			http.MethodPut,
+			managementURL("/api/policies/"+url.PathEscape(conn.Config.Vhost)+"/"+url.PathEscape(p.Name)),
+			bytes.NewReader(body),
+		)
+		if err != nil {
+			return fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(configs.AMQP_USERNAME, configs.AMQP_PASSWORD)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("setting policy %s: %w", p.Name, err)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("setting policy %s: %s %s", p.Name, resp.Status, msg)
+		}
+	}
+
+	return nil
+}
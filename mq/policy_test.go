@@ -0,0 +1,63 @@
+// +build integration
+
+package mq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"platform_api/configs"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyPolicies(t *testing.T) {
+	var paths []string
+	var policies []policyRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		assert.Equal(t, configs.AMQP_USERNAME, user)
+		assert.Equal(t, http.MethodPut, r.Method)
+
+		var policy policyRequest
+		json.NewDecoder(r.Body).Decode(&policy)
+		paths = append(paths, r.URL.EscapedPath())
+		policies = append(policies, policy)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	defer func(host string, port string) {
+		configs.AMQP_HOSTNAME, configs.AMQP_MANAGEMENT_PORT = host, port
+	}(configs.AMQP_HOSTNAME, configs.AMQP_MANAGEMENT_PORT)
+	configs.AMQP_HOSTNAME, configs.AMQP_MANAGEMENT_PORT = u.Hostname(), u.Port()
+
+	err := applyPolicies(&amqp.Connection{Config: amqp.Config{Vhost: "/"}})
+	assert.NoError(t, err)
+
+	// the built-in topology dead-letters the platform queues by policy
+	assert.Equal(t, []string{"/api/policies/%2F/platform-dead-letter"}, paths)
+	assert.Equal(t, "queues", policies[0].ApplyTo)
+	assert.Equal(t, EXCHANGE_DEAD_LETTER, policies[0].Definition["dead-letter-exchange"])
+	assert.Regexp(t, policies[0].Pattern, QUEUE_PLATFORM_TO)
+}
+
+func TestApplyPolicies_Refused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"not_authorised"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	defer func(host string, port string) {
+		configs.AMQP_HOSTNAME, configs.AMQP_MANAGEMENT_PORT = host, port
+	}(configs.AMQP_HOSTNAME, configs.AMQP_MANAGEMENT_PORT)
+	configs.AMQP_HOSTNAME, configs.AMQP_MANAGEMENT_PORT = u.Hostname(), u.Port()
+
+	err := applyPolicies(&amqp.Connection{Config: amqp.Config{Vhost: "/"}})
+	assert.ErrorContains(t, err, "401")
+}
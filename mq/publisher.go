@@ -2,46 +2,120 @@ package mq
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// Pub (Publishes) a specified message to the AMQP exchange
-func Pub(ex string, key string, body []byte) error {
+var (
+	// ErrNacked is returned when the broker refused to take ownership of a message
+	ErrNacked = errors.New("message was nacked by the broker")
 
-	// open channel
-	c, err := AMQP_CONN.Channel()
-	for err != nil {
-		log.Printf("Failed to connect: %s, retrying in 5 seconds...", err)
-		c, err = ReestablishConnection()
-		time.Sleep(5 * time.Second)
-	}
-	defer c.Close()
+	// ErrUnroutable is returned when no queue is bound for the routing key
+	ErrUnroutable = errors.New("message could not be routed to any queue")
+
+	// ErrConfirmTimeout is returned when the broker did not confirm the message in time
+	ErrConfirmTimeout = errors.New("timed out waiting for publish confirmation")
+)
+
+// Pub (Publishes) a specified message to the AMQP exchange and waits for the
+// broker to confirm it was routed and persisted
+func Pub(ex string, key string, msg Message) error {
 
 	// context
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return PubWithContext(ctx, ex, key, msg)
+}
+
+// PubWithContext publishes like Pub but gives up waiting for a channel or
+// the confirmation once ctx is done
+func PubWithContext(ctx context.Context, ex string, key string, msg Message) error {
+
+	// take a confirm mode channel from the pool
+	pc, err := AMQP_MANAGER.acquire(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("waiting for an AMQP channel: %w", err)
+		}
+		return err
+	}
+
 	// publish message
-	err = c.PublishWithContext(
+	confirmation, err := pc.ch.PublishWithDeferredConfirmWithContext(
 		ctx,
 		ex,
 		key,
-		false,
+		true,
 		false,
 		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
+			DeliveryMode:  amqp.Persistent,
+			ContentType:   "application/json",
+			MessageId:     msg.MessageId,
+			CorrelationId: msg.CorrelationId,
+			Timestamp:     msg.Timestamp,
+			Type:          msg.Type,
+			AppId:         PRODUCER,
+			Headers:       amqp.Table(msg.Headers),
+			Body:          msg.Body,
 		},
 	)
 	if err != nil {
+		AMQP_MANAGER.release(pc, false)
 		return err
 	}
 
-	log.Printf("Sending message: %s -> %s", body, "default")
+	// wait for the broker to ack or nack
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// an outstanding confirmation would be mistaken for the next publish's
+		AMQP_MANAGER.release(pc, false)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrConfirmTimeout
+		}
+		return err
+	}
+	if !acked {
+		// nacks are rare, do not reuse a channel the broker had trouble with
+		AMQP_MANAGER.release(pc, false)
+		return ErrNacked
+	}
+	defer AMQP_MANAGER.release(pc, true)
+
+	// the broker sends basic.return before the ack of an unroutable message
+	select {
+	case ret := <-pc.returns:
+		return fmt.Errorf("%w: %s -> %s (%s)", ErrUnroutable, ret.Exchange, ret.RoutingKey, ret.ReplyText)
+	default:
+	}
+
+	log.Printf("Sending message %s (%s): %s -> %s", msg.MessageId, msg.CorrelationId, msg.Body, key)
 	return nil
 }
 
+// IsRefused reports whether the broker refused a message, publishing it
+// again would fail the same way
+func IsRefused(err error) bool {
+	return errors.Is(err, ErrUnroutable) || errors.Is(err, ErrNacked)
+}
+
+// Publisher publishes messages to an exchange. It lets controllers and
+// workers run against RabbitMQ or an in-memory broker.
+type Publisher interface {
+	Publish(ctx context.Context, ex string, key string, msg Message) error
+}
+
+// AMQPPublisher publishes to RabbitMQ through the connection manager
+type AMQPPublisher struct{}
+
+func NewAMQPPublisher() *AMQPPublisher {
+	return &AMQPPublisher{}
+}
+
+func (t *AMQPPublisher) Publish(ctx context.Context, ex string, key string, msg Message) error {
+	return PubWithContext(ctx, ex, key, msg)
+}
@@ -4,47 +4,56 @@ import (
 	"fmt"
 	"log"
 	"platform_api/configs"
+	"strconv"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 var (
-	AMQP_CONN *amqp.Connection
-)
+	AMQP_MANAGER *ConnectionManager
 
-const (
-	ROUTE_IMAGE_BUILD      = "platform.fromService.imageCreate"
-	ROUTE_CHALLENGE_CREATE = "platform.fromService.challengeCreate"
-	ROUTE_CHALLENGE_START  = "platform.fromService.challengeStart"
-	QUEUE_PLATFORM_FROM     = "queue.platform.fromService"
-	EXCHANGE_TOPIC_ROUTER = "topic.router"
-	EXCHANGE_DEFAULT       = "/"
+	// TOPOLOGY is declared on every (re)connect, see UseTopology
+	TOPOLOGY = configs.TOPOLOGY
 )
 
-func ReestablishConnection() (*amqp.Channel, error) {
-
-	// connect to mq
-	connStr := fmt.Sprintf(
-		"amqp://%s:%s@%s:%s/",
-		configs.AMQP_USERNAME,
-		configs.AMQP_PASSWORD,
-		configs.AMQP_HOSTNAME,
-		configs.AMQP_PORT,
-	)
-
-	// setup rabbitmq connection
-	conn, err := amqp.Dial(connStr)
-	if err != nil {
-		return nil, err
-	}
-	log.Println("Connected to MQ!")
-
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, err
-	}
+// names taken from the topology
+var (
+	ROUTE_IMAGE_BUILD       = TOPOLOGY.Events[EVENT_IMAGE_CREATE].Request
+	ROUTE_CHALLENGE_CREATE  = TOPOLOGY.Events[EVENT_CHALLENGE_CREATE].Request
+	ROUTE_CHALLENGE_START   = TOPOLOGY.Events[EVENT_CHALLENGE_START].Request
+	ROUTE_PROCESS_CANCEL    = TOPOLOGY.Events[EVENT_PROCESS_CANCEL].Request
+	ROUTE_PROCESS_TIMEOUT   = TOPOLOGY.Events[EVENT_PROCESS_TIMEOUT].Request
+	ROUTE_IMAGE_DELETE      = TOPOLOGY.Events[EVENT_IMAGE_DELETE].Request
+	ROUTE_IMAGE_BUILT       = TOPOLOGY.Events[EVENT_IMAGE_CREATE].Reply
+	ROUTE_CHALLENGE_CREATED = TOPOLOGY.Events[EVENT_CHALLENGE_CREATE].Reply
+	ROUTE_CHALLENGE_STARTED = TOPOLOGY.Events[EVENT_CHALLENGE_START].Reply
+	ROUTE_PROCESS_CANCELLED = TOPOLOGY.Events[EVENT_PROCESS_CANCEL].Reply
+	QUEUE_PLATFORM_TO       = TOPOLOGY.Roles.ReplyQueue
+	QUEUE_PARKING_LOT       = TOPOLOGY.Roles.ParkingLotQueue
+	EXCHANGE_TOPIC_ROUTER   = TOPOLOGY.Roles.Router
+	EXCHANGE_DEAD_LETTER    = TOPOLOGY.Roles.DeadLetter
+	EXCHANGE_RETRY          = TOPOLOGY.Roles.Retry
+)
 
-	return ch, nil
+// UseTopology switches to a validated topology. It must be called before Init.
+func UseTopology(topology *configs.TopologyConfig) {
+	TOPOLOGY = topology
+
+	ROUTE_IMAGE_BUILD = topology.Events[EVENT_IMAGE_CREATE].Request
+	ROUTE_CHALLENGE_CREATE = topology.Events[EVENT_CHALLENGE_CREATE].Request
+	ROUTE_CHALLENGE_START = topology.Events[EVENT_CHALLENGE_START].Request
+	ROUTE_PROCESS_CANCEL = topology.Events[EVENT_PROCESS_CANCEL].Request
+	ROUTE_PROCESS_TIMEOUT = topology.Events[EVENT_PROCESS_TIMEOUT].Request
+	ROUTE_IMAGE_DELETE = topology.Events[EVENT_IMAGE_DELETE].Request
+	ROUTE_IMAGE_BUILT = topology.Events[EVENT_IMAGE_CREATE].Reply
+	ROUTE_CHALLENGE_CREATED = topology.Events[EVENT_CHALLENGE_CREATE].Reply
+	ROUTE_CHALLENGE_STARTED = topology.Events[EVENT_CHALLENGE_START].Reply
+	ROUTE_PROCESS_CANCELLED = topology.Events[EVENT_PROCESS_CANCEL].Reply
+	QUEUE_PLATFORM_TO = topology.Roles.ReplyQueue
+	QUEUE_PARKING_LOT = topology.Roles.ParkingLotQueue
+	EXCHANGE_TOPIC_ROUTER = topology.Roles.Router
+	EXCHANGE_DEAD_LETTER = topology.Roles.DeadLetter
+	EXCHANGE_RETRY = topology.Roles.Retry
 }
 
 // Init rabbitmq connection
@@ -59,56 +68,141 @@ func Init() {
 		configs.AMQP_PORT,
 	)
 
+	// pool size for publishing channels
+	poolSize, err := strconv.Atoi(configs.AMQP_CHANNEL_POOL_SIZE)
+	if err != nil {
+		log.Panic("Invalid AMQP_CHANNEL_POOL_SIZE", err)
+	}
+
 	// setup rabbitmq connection
-	var err error
-	AMQP_CONN, err = amqp.Dial(connStr)
+	AMQP_MANAGER = NewConnectionManager(connStr, poolSize)
 	log.Printf(
 		"Connecting to host with %s",
 		connStr,
 	)
+
+	// declare all queues on every (re)connect
+	err = AMQP_MANAGER.OnConnect(declareTopology)
 	if err != nil {
-		log.Panic("Failed to connect to RabbitMQ", err)
+		log.Panic("Failed to register topology", err)
+	}
+
+	// policies change queues that already exist, e.g. to dead-letter them
+	err = AMQP_MANAGER.OnConnect(applyPolicies)
+	if err != nil {
+		log.Panic("Failed to register topology policies", err)
+	}
+
+	err = AMQP_MANAGER.Connect()
+	if err != nil {
+		// publishes are spooled until the manager reconnects
+		log.Printf("Failed to connect to RabbitMQ, retrying in the background: %s", err)
+		return
 	}
 	log.Printf(
 		"Successfully connected to RabbitMQ: %s:%s",
 		configs.AMQP_HOSTNAME,
 		configs.AMQP_PORT,
 	)
+}
+
+// declareTopology declares the exchanges, queues and bindings of the
+// topology. Declarations are idempotent as long as their arguments match.
+func declareTopology(conn *amqp.Connection) error {
 
 	// conn to channel
-	channel, err := AMQP_CONN.Channel()
+	channel, err := conn.Channel()
 	if err != nil {
-		log.Panic("Failed to create channel for RabbitMQ", err)
+		return err
+	}
+	defer channel.Close()
+
+	for _, ex := range TOPOLOGY.Exchanges {
+		err = ExchangeDeclare(channel, ex)
+		if err != nil {
+			return fmt.Errorf("declaring exchange %s: %w", ex.Name, err)
+		}
 	}
 
-	// declare all queues
-	QueueDeclare(channel)
-	ExchangeDeclare(channel)
-	channel.Close()
+	for _, q := range TOPOLOGY.Queues {
+		_, err = QueueDeclare(channel, q)
+		if err != nil {
+			return fmt.Errorf("declaring queue %s: %w", q.Name, err)
+		}
+	}
+
+	for _, b := range TOPOLOGY.Bindings {
+		err = QueueBind(channel, b)
+		if err != nil {
+			return fmt.Errorf("binding %s to %s: %w", b.Queue, b.Exchange, err)
+		}
+	}
 
+	return RetryDeclare(channel)
 }
 
 // Declares an exchange to Pub/Sub to
-func ExchangeDeclare(ch *amqp.Channel) error {
+func ExchangeDeclare(ch *amqp.Channel, ex configs.ExchangeConfig) error {
 	return ch.ExchangeDeclare(
-		EXCHANGE_TOPIC_ROUTER, // name
-		"topic",                // type
-		true,                   // durable
-		false,                  // auto-deleted
-		false,                  // internal
-		false,                  // no-wait
-		nil,                    // arguments
+		ex.Name,               // name
+		ex.Type,               // type
+		ex.Durable,            // durable
+		ex.AutoDelete,         // auto-deleted
+		ex.Internal,           // internal
+		false,                 // no-wait
+		toTable(ex.Arguments), // arguments
 	)
 }
 
 // Declares a queue to Pub/Sub to
-func QueueDeclare(ch *amqp.Channel) (amqp.Queue, error) {
+func QueueDeclare(ch *amqp.Channel, q configs.QueueConfig) (amqp.Queue, error) {
 	return ch.QueueDeclare(
-		QUEUE_PLATFORM_FROM, // name
-		true,               // durable
-		false,              // delete when unused
-		false,              // exclusive
-		false,              // no-wait
-		nil,                // arguments
+		q.Name,               // name
+		q.Durable,            // durable
+		q.AutoDelete,         // delete when unused
+		q.Exclusive,          // exclusive
+		false,                // no-wait
+		toTable(q.Arguments), // arguments
 	)
 }
+
+// Binds a queue to an exchange
+func QueueBind(ch *amqp.Channel, b configs.BindingConfig) error {
+	return ch.QueueBind(
+		b.Queue,              // queue
+		b.RoutingKey,         // routing key
+		b.Exchange,           // exchange
+		false,                // no-wait
+		toTable(b.Arguments), // arguments
+	)
+}
+
+// toTable converts decoded configuration arguments to AMQP field values
+func toTable(args map[string]interface{}) amqp.Table {
+	if len(args) == 0 {
+		return nil
+	}
+
+	table := amqp.Table{}
+	for k, v := range args {
+		table[k] = toField(v)
+	}
+	return table
+}
+
+func toField(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case map[string]interface{}:
+		return toTable(v)
+	case []interface{}:
+		fields := make([]interface{}, len(v))
+		for i, f := range v {
+			fields[i] = toField(f)
+		}
+		return fields
+	default:
+		return v
+	}
+}
@@ -0,0 +1,595 @@
+package mq
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"platform_api/collections"
+	"platform_api/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	spoolSegmentPrefix = "segment-"
+	spoolSegmentSuffix = ".log"
+	spoolCursorFile    = "cursor"
+
+	// a segment is sealed once it grows past this size
+	spoolMaxSegmentSize = 16 << 20
+
+	// reason of the dead letters of spooled messages the broker refused
+	spoolRefusedReason = "refused"
+)
+
+// ErrSpooled is returned when a message could not reach the broker and was
+// written to the local spool instead. It will be replayed in order.
+var ErrSpooled = errors.New("broker unavailable, message spooled to disk")
+
+// spoolRecord is a single publish stored in a segment
+type spoolRecord struct {
+	Exchange   string    `json:"exchange"`
+	RoutingKey string    `json:"routingKey"`
+	Message    Message   `json:"message"`
+	SpooledAt  time.Time `json:"spooledAt"`
+}
+
+// SpoolStats describes the publishes waiting in the spool
+type SpoolStats struct {
+	Enabled        bool       `json:"enabled"`
+	Entries        int        `json:"entries"`
+	Bytes          int64      `json:"bytes"`
+	OldestEntryAt  *time.Time `json:"oldestEntryAt,omitempty"`
+	OldestEntryAge float64    `json:"oldestEntryAgeSeconds"`
+}
+
+// Spool is an append-only write-ahead log of publishes, split into numbered
+// segment files. A cursor file records how far the log has been replayed.
+// Every record is framed as a 4 byte big-endian length followed by JSON.
+type Spool struct {
+	dir            string
+	maxSegmentSize int64
+
+	mu         sync.Mutex
+	active     *os.File
+	activeSeq  int
+	activeSize int64
+	readSeq    int
+	readOffset int64
+
+	// backlog between the cursor and the end of the log
+	entries int
+	bytes   int64
+	oldest  time.Time
+
+	// message ids of the backlog, so a retried publish is spooled only once
+	spooled map[string]int
+}
+
+// ReplayHandler is told about the records a replay moves past. A refused
+// record is only dropped from the spool once Refused returns nil.
+type ReplayHandler interface {
+	Published(ex string, key string, msg Message)
+	Refused(ex string, key string, msg Message, cause error) error
+}
+
+// OpenSpool opens or creates the spool in dir, dropping any torn write at the
+// end of the log
+func OpenSpool(dir string) (*Spool, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:            dir,
+		maxSegmentSize: spoolMaxSegmentSize,
+		activeSeq:      1,
+		readSeq:        1,
+		spooled:        map[string]int{},
+	}
+
+	seqs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(seqs) > 0 {
+		s.activeSeq = seqs[len(seqs)-1]
+		s.readSeq = seqs[0]
+	}
+
+	err = s.readCursor()
+	if err != nil {
+		return nil, err
+	}
+	if len(seqs) > 0 && s.readSeq < seqs[0] {
+		// the cursor points at a segment removed after it was replayed
+		s.readSeq = seqs[0]
+		s.readOffset = 0
+	}
+
+	// scan the backlog to rebuild the stats and find the end of the log
+	for _, seq := range seqs {
+		if seq < s.readSeq {
+			os.Remove(s.segmentPath(seq))
+			continue
+		}
+
+		offset := int64(0)
+		if seq == s.readSeq {
+			offset = s.readOffset
+		}
+
+		end, err := s.scan(seq, offset)
+		if err != nil {
+			return nil, err
+		}
+		if seq == s.activeSeq {
+			err = os.Truncate(s.segmentPath(seq), end)
+			if err != nil {
+				return nil, err
+			}
+			s.activeSize = end
+		}
+	}
+
+	s.active, err = os.OpenFile(s.segmentPath(s.activeSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append durably writes a publish to the end of the log
+func (s *Spool) Append(ex string, key string, msg Message) error {
+	now := time.Now().UTC()
+	data, err := json.Marshal(spoolRecord{
+		Exchange:   ex,
+		RoutingKey: key,
+		Message:    msg,
+		SpooledAt:  now,
+	})
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.activeSize >= s.maxSegmentSize {
+		err = s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.active.Write(frame)
+	if err != nil {
+		return err
+	}
+	err = s.active.Sync()
+	if err != nil {
+		return err
+	}
+
+	s.activeSize += int64(len(frame))
+	if s.entries == 0 {
+		s.oldest = now
+	}
+	s.entries++
+	s.bytes += int64(len(frame))
+	s.spooled[msg.MessageId]++
+	return nil
+}
+
+// Contains reports whether a message is waiting in the spool
+func (s *Spool) Contains(messageId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.spooled[messageId] > 0
+}
+
+// Close closes the active segment
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.active.Close()
+}
+
+// Empty reports whether every spooled publish was replayed
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entries == 0
+}
+
+// Stats reports the backlog of the spool. A nil spool reports it is disabled.
+func (s *Spool) Stats() SpoolStats {
+	if s == nil {
+		return SpoolStats{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SpoolStats{Enabled: true, Entries: s.entries, Bytes: s.bytes}
+	if s.entries > 0 {
+		oldest := s.oldest
+		stats.OldestEntryAt = &oldest
+		stats.OldestEntryAge = time.Since(oldest).Seconds()
+	}
+	return stats
+}
+
+// Replay publishes spooled records in order until the spool is empty or a
+// publish fails. Records the broker refused would never go through, they are
+// handed to the handler and skipped instead of blocking the records behind.
+func (s *Spool) Replay(publisher Publisher, handler ReplayHandler) error {
+	for {
+		record, size, err := s.peek()
+		if err != nil || record == nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = publisher.Publish(ctx, record.Exchange, record.RoutingKey, record.Message)
+		cancel()
+		refused := IsRefused(err)
+		if refused {
+			refusedErr := handler.Refused(record.Exchange, record.RoutingKey, record.Message, err)
+			if refusedErr != nil {
+				return fmt.Errorf("keeping refused message %s: %w", record.Message.MessageId, refusedErr)
+			}
+			log.Printf("Dropped spooled message %s refused by the broker: %s", record.Message.MessageId, err)
+		} else if err != nil {
+			return err
+		}
+
+		err = s.advance(record.Message.MessageId, size)
+		if err != nil {
+			return err
+		}
+
+		if !refused {
+			handler.Published(record.Exchange, record.RoutingKey, record.Message)
+		}
+	}
+}
+
+// peek reads the record at the cursor, moving past finished segments
+func (s *Spool) peek() (*spoolRecord, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.entries > 0 {
+		f, err := os.Open(s.segmentPath(s.readSeq))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		_, err = f.Seek(s.readOffset, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		record, size, err := readRecord(bufio.NewReader(f))
+		f.Close()
+
+		if err == io.EOF && s.readSeq < s.activeSeq {
+			// segment fully replayed
+			done := s.segmentPath(s.readSeq)
+			s.readSeq++
+			s.readOffset = 0
+			err = s.writeCursor()
+			if err != nil {
+				return nil, 0, err
+			}
+			os.Remove(done)
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return record, size, nil
+	}
+
+	return nil, 0, nil
+}
+
+// advance moves the cursor past a replayed record
+func (s *Spool) advance(messageId string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readOffset += size
+	s.entries--
+	s.bytes -= size
+	s.spooled[messageId]--
+	if s.spooled[messageId] <= 0 {
+		delete(s.spooled, messageId)
+	}
+
+	err := s.writeCursor()
+	if err != nil {
+		return err
+	}
+
+	// remember when the next record was spooled
+	if s.entries > 0 {
+		f, err := os.Open(s.segmentPath(s.readSeq))
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+
+		_, err = f.Seek(s.readOffset, io.SeekStart)
+		if err == nil {
+			record, _, err := readRecord(bufio.NewReader(f))
+			if err == nil {
+				s.oldest = record.SpooledAt
+			}
+		}
+	}
+	return nil
+}
+
+// rotate seals the active segment and starts the next one
+func (s *Spool) rotate() error {
+	err := s.active.Close()
+	if err != nil {
+		return err
+	}
+
+	s.activeSeq++
+	s.activeSize = 0
+	s.active, err = os.OpenFile(s.segmentPath(s.activeSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// scan counts the records of a segment from offset and returns the offset
+// of the end of the last complete record
+func (s *Spool) scan(seq int, offset int64) (int64, error) {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		record, size, err := readRecord(r)
+		if err != nil {
+			// io.EOF or a torn write, the log ends here
+			return offset, nil
+		}
+
+		if s.entries == 0 {
+			s.oldest = record.SpooledAt
+		}
+		s.entries++
+		s.bytes += size
+		s.spooled[record.Message.MessageId]++
+		offset += size
+	}
+}
+
+// segments lists the sequence numbers of the segment files in order
+func (s *Spool) segments() ([]int, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []int
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasPrefix(name, spoolSegmentPrefix) || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, spoolSegmentPrefix), spoolSegmentSuffix))
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	return seqs, nil
+}
+
+func (s *Spool) segmentPath(seq int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%010d%s", spoolSegmentPrefix, seq, spoolSegmentSuffix))
+}
+
+// readCursor loads the replay position, if any
+func (s *Spool) readCursor() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Sscanf(string(data), "%d %d", &s.readSeq, &s.readOffset)
+	return err
+}
+
+// writeCursor atomically persists the replay position
+func (s *Spool) writeCursor() error {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d %d", s.readSeq, s.readOffset)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readRecord reads one framed record and returns it with its framed size
+func readRecord(r io.Reader) (*spoolRecord, int64, error) {
+	var header [4]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[:]))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, 0, io.EOF
+	}
+
+	var record spoolRecord
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &record, int64(4 + len(data)), nil
+}
+
+// SpoolingPublisher publishes through another Publisher while the broker is
+// ready, and spools publishes to disk while it is not
+type SpoolingPublisher struct {
+	OutboxCollection     collections.OutboxCollection
+	DeadLetterCollection collections.DeadLetterCollection
+	Publisher            Publisher
+	Spool                *Spool
+	Ready                func() bool
+}
+
+func NewSpoolingPublisher(client *mongo.Client, publisher Publisher, spool *Spool, ready func() bool) *SpoolingPublisher {
+	return &SpoolingPublisher{
+		OutboxCollection:     *collections.NewOutboxCollection(client),
+		DeadLetterCollection: *collections.NewDeadLetterCollection(client),
+		Publisher:            publisher,
+		Spool:                spool,
+		Ready:                ready,
+	}
+}
+
+// Publish sends the message straight to the broker unless it is unavailable
+// or older messages are still spooled, in which case ErrSpooled is returned.
+// A message already waiting in the spool is not spooled again.
+func (t *SpoolingPublisher) Publish(ctx context.Context, ex string, key string, msg Message) error {
+	if t.Spool.Contains(msg.MessageId) {
+		return ErrSpooled
+	}
+
+	if t.Ready() && t.Spool.Empty() {
+		err := t.Publisher.Publish(ctx, ex, key, msg)
+		if err == nil || !brokerUnavailable(err) {
+			return err
+		}
+		log.Printf("Broker unavailable, spooling %s: %s", msg.MessageId, err)
+	}
+
+	err := t.Spool.Append(ex, key, msg)
+	if err != nil {
+		return fmt.Errorf("spooling message: %w", err)
+	}
+	return ErrSpooled
+}
+
+// Start replays the spool in the background whenever the broker is ready
+func (t *SpoolingPublisher) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !t.Ready() || t.Spool.Empty() {
+				continue
+			}
+
+			err := t.Spool.Replay(t.Publisher, t)
+			if err != nil {
+				log.Printf("Failed to replay spool: %s", err)
+			}
+		}
+	}()
+}
+
+// Published marks the outbox message of a replayed publish as dispatched
+func (t *SpoolingPublisher) Published(ex string, key string, msg Message) {
+	_, err := t.OutboxCollection.MarkReplayed(msg.MessageId)
+	if err != nil {
+		// the relay publishes it again, consumers drop the duplicate
+		log.Printf("Failed to mark outbox message %s as dispatched: %s", msg.MessageId, err)
+	}
+}
+
+// Refused parks a spooled publish the broker refused as a dead letter, so it
+// can be inspected and re-driven
+func (t *SpoolingPublisher) Refused(ex string, key string, msg Message, cause error) error {
+	_, err := t.DeadLetterCollection.InsertDeadLetter(&models.DeadLetter{
+		MessageId:      msg.MessageId,
+		CorId:          msg.CorrelationId,
+		Type:           msg.Type,
+		Exchange:       ex,
+		RoutingKey:     key,
+		Reason:         spoolRefusedReason,
+		Body:           string(msg.Body),
+		DeadLetteredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.OutboxCollection.MarkReplayRefused(msg.MessageId, cause)
+	if err != nil {
+		log.Printf("Failed to record outbox refusal for %s: %s", msg.MessageId, err)
+	}
+	return nil
+}
+
+// brokerUnavailable reports whether a publish failed because the broker
+// could not be reached, as opposed to being refused by it
+func brokerUnavailable(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrConnectionClosed) ||
+		errors.Is(err, amqp.ErrClosed)
+}
@@ -0,0 +1,217 @@
+// +build integration
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"os"
+	"platform_api/configs"
+	"platform_api/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const spoolTestRoute = "platform.fromService.spoolTest"
+
+// replayRecorder is a ReplayHandler remembering what it was told
+type replayRecorder struct {
+	published []string
+	refused   []string
+	err       error
+}
+
+func (t *replayRecorder) Published(ex string, key string, msg Message) {
+	t.published = append(t.published, msg.MessageId)
+}
+
+func (t *replayRecorder) Refused(ex string, key string, msg Message, cause error) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.refused = append(t.refused, msg.MessageId)
+	return nil
+}
+
+func open_test_spool(t *testing.T, dir string) *Spool {
+	spool, err := OpenSpool(dir)
+	assert.NoError(t, err)
+	t.Cleanup(func() { spool.Close() })
+	return spool
+}
+
+func append_spool_messages(t *testing.T, spool *Spool, key string, ids ...string) {
+	for _, id := range ids {
+		err := spool.Append(EXCHANGE_TOPIC_ROUTER, key, Message{MessageId: id, Body: []byte(`{"id":"` + id + `"}`)})
+		assert.NoError(t, err)
+	}
+}
+
+func published_ids(publisher *MemoryPublisher) []string {
+	var ids []string
+	for _, msg := range publisher.All() {
+		ids = append(ids, msg.MessageId)
+	}
+	return ids
+}
+
+func TestSpool_Replay(t *testing.T) {
+	spool := open_test_spool(t, t.TempDir())
+	append_spool_messages(t, spool, spoolTestRoute, "a", "b", "c")
+	assert.Equal(t, 3, spool.Stats().Entries)
+	assert.True(t, spool.Contains("b"))
+
+	publisher := NewMemoryPublisher()
+	handler := &replayRecorder{}
+	err := spool.Replay(publisher, handler)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b", "c"}, published_ids(publisher))
+	assert.Equal(t, []string{"a", "b", "c"}, handler.published)
+	assert.True(t, spool.Empty())
+	assert.False(t, spool.Contains("b"))
+}
+
+func TestSpool_SegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	spool := open_test_spool(t, dir)
+	spool.maxSegmentSize = 1
+
+	// every record seals the segment it was written to
+	append_spool_messages(t, spool, spoolTestRoute, "a", "b", "c")
+	seqs, err := spool.segments()
+	assert.NoError(t, err)
+	assert.Len(t, seqs, 3)
+
+	err = spool.Replay(NewMemoryPublisher(), &replayRecorder{})
+	assert.NoError(t, err)
+
+	// replayed segments are removed, the active one is kept
+	seqs, err = spool.segments()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{spool.activeSeq}, seqs)
+}
+
+func TestSpool_CursorPersistence(t *testing.T) {
+	dir := t.TempDir()
+	spool := open_test_spool(t, dir)
+	spool.maxSegmentSize = 1
+	append_spool_messages(t, spool, spoolTestRoute, "a")
+	append_spool_messages(t, spool, "platform.fromService.spoolDown", "b")
+	append_spool_messages(t, spool, spoolTestRoute, "c")
+
+	// the broker becomes unreachable after the first record
+	publisher := NewMemoryPublisher()
+	publisher.FailRoute("platform.fromService.spoolDown", ErrConnectionClosed)
+	err := spool.Replay(publisher, &replayRecorder{})
+	assert.True(t, errors.Is(err, ErrConnectionClosed))
+	assert.Equal(t, []string{"a"}, published_ids(publisher))
+	spool.Close()
+
+	// a restart resumes at the record that failed
+	spool = open_test_spool(t, dir)
+	assert.Equal(t, 2, spool.Stats().Entries)
+	assert.False(t, spool.Contains("a"))
+
+	publisher.Reset()
+	err = spool.Replay(publisher, &replayRecorder{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, published_ids(publisher))
+}
+
+func TestSpool_TornWrite(t *testing.T) {
+	dir := t.TempDir()
+	spool := open_test_spool(t, dir)
+	append_spool_messages(t, spool, spoolTestRoute, "a", "b")
+	spool.Close()
+
+	// a crash left half a record at the end of the log
+	f, err := os.OpenFile(spool.segmentPath(spool.activeSeq), os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, '{', '"'})
+	assert.NoError(t, err)
+	f.Close()
+
+	spool = open_test_spool(t, dir)
+	assert.Equal(t, 2, spool.Stats().Entries)
+
+	// records appended after the recovery are not glued to the torn one
+	append_spool_messages(t, spool, spoolTestRoute, "c")
+
+	publisher := NewMemoryPublisher()
+	err = spool.Replay(publisher, &replayRecorder{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, published_ids(publisher))
+}
+
+func TestSpool_ReplayRefused(t *testing.T) {
+	spool := open_test_spool(t, t.TempDir())
+	append_spool_messages(t, spool, spoolTestRoute, "a")
+	append_spool_messages(t, spool, "platform.fromService.spoolUnroutable", "b")
+	append_spool_messages(t, spool, spoolTestRoute, "c")
+
+	publisher := NewMemoryPublisher()
+	publisher.FailRoute("platform.fromService.spoolUnroutable", ErrUnroutable)
+
+	// kept while the refusal cannot be recorded
+	handler := &replayRecorder{err: errors.New("mongo unavailable")}
+	err := spool.Replay(publisher, handler)
+	assert.ErrorContains(t, err, "mongo unavailable")
+	assert.Equal(t, 2, spool.Stats().Entries)
+
+	// then skipped instead of blocking the records behind it
+	handler.err = nil
+	err = spool.Replay(publisher, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, handler.refused)
+	assert.Equal(t, []string{"a", "c"}, handler.published)
+	assert.True(t, spool.Empty())
+}
+
+func TestSpoolingPublisher(t *testing.T) {
+	ready := false
+	publisher := NewMemoryPublisher()
+	spooling := NewSpoolingPublisher(configs.Client, publisher, open_test_spool(t, t.TempDir()), func() bool { return ready })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	defer clear_outbox_messages()
+	defer spooling.DeadLetterCollection.Collection.DeleteMany(ctx, bson.D{{Key: "routingKey", Value: outboxTestRoute}})
+
+	msg := insert_outbox_message(t)
+	err := Dispatch(spooling, testOutbox, msg)
+	assert.True(t, errors.Is(err, ErrSpooled))
+
+	// left pending, a retry by the relay is not spooled twice
+	assert.Equal(t, models.OutboxStatusPending, get_outbox_message(t, msg).Status)
+	err = Dispatch(spooling, testOutbox, msg)
+	assert.True(t, errors.Is(err, ErrSpooled))
+	assert.Equal(t, 1, spooling.Spool.Stats().Entries)
+
+	// dispatched once the replay published it
+	ready = true
+	err = spooling.Spool.Replay(publisher, spooling)
+	assert.NoError(t, err)
+	assert.Len(t, publisher.Messages(EXCHANGE_TOPIC_ROUTER, outboxTestRoute), 1)
+	assert.Equal(t, models.OutboxStatusDispatched, get_outbox_message(t, msg).Status)
+
+	// a refused replay is parked as a dead letter
+	publisher.FailWith(ErrUnroutable)
+	ready = false
+	refused := insert_outbox_message(t)
+	err = Dispatch(spooling, testOutbox, refused)
+	assert.True(t, errors.Is(err, ErrSpooled))
+
+	err = spooling.Spool.Replay(publisher, spooling)
+	assert.NoError(t, err)
+	assert.Equal(t, models.OutboxStatusRefused, get_outbox_message(t, refused).Status)
+
+	var dl models.DeadLetter
+	err = spooling.DeadLetterCollection.Collection.FindOne(ctx, bson.D{{Key: "messageId", Value: refused.MessageId}}).Decode(&dl)
+	assert.NoError(t, err)
+	assert.Equal(t, spoolRefusedReason, dl.Reason)
+	assert.Equal(t, models.DeadLetterStatusParked, dl.Status)
+}
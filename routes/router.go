@@ -3,6 +3,7 @@ package routes
 import (
 	"platform_api/configs"
 	"platform_api/controllers"
+	"platform_api/mq"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -29,12 +30,26 @@ import (
 
 // @externalDocs.description	OpenAPI
 // @externalDocs.url			https://swagger.io/resources/open-api/
-func InitRoutes() {
-
-	challenge := controllers.NewChallengeController(configs.Client)
-	image := controllers.NewImageController(configs.Client)
-	process := controllers.NewProcessController(configs.Client)
-	attempt := controllers.NewAttemptController(configs.Client)
+func InitRoutes(publisher mq.Publisher, spool *mq.Spool) {
+
+	challenge := controllers.NewChallengeController(configs.Client, publisher)
+	image := controllers.NewImageController(configs.Client, publisher)
+	process := controllers.NewProcessController(configs.Client, publisher)
+	attempt := controllers.NewAttemptController(configs.Client, publisher)
+	outbox := controllers.NewOutboxController(configs.Client)
+	mqSpool := controllers.NewSpoolController(spool)
+	deadLetter := controllers.NewDeadLetterController(configs.Client, publisher)
+	webhook := controllers.NewWebhookController(configs.Client)
+	sweep := controllers.NewSweepController(configs.Client)
+	migration := controllers.NewMigrationController(configs.Client)
+	upload := controllers.NewUploadController(configs.Client, publisher)
+
+	// the broker is only checked when publishing to RabbitMQ
+	var mqHealth func() error
+	if mq.AMQP_MANAGER != nil {
+		mqHealth = mq.AMQP_MANAGER.Err
+	}
+	health := controllers.NewHealthController(mqHealth)
 
 	router := gin.Default()
 
@@ -44,11 +59,15 @@ func InitRoutes() {
 	// enabling cors
 	config := cors.DefaultConfig()
 	config.AllowHeaders = append(config.AllowHeaders, "Authorization")
+	config.AllowHeaders = append(config.AllowHeaders, controllers.TUS_HEADERS...)
+	config.ExposeHeaders = append(config.ExposeHeaders, controllers.TUS_HEADERS...)
 	config.AllowAllOrigins = true
 	router.Use(cors.New(config))
 
 	v1 := router.Group("/api/v1")
 
+	v1.GET("/health", health.HealthCheck)
+
 	// platform api
 	platform := v1.Group("/platform")
 
@@ -58,6 +77,22 @@ func InitRoutes() {
 	platformImage.GET("/name/:creatorName", image.GetImageByCreatorName)
 	platformImage.GET("/status/:corId", process.GetProcessStatusByCorId)
 	platformImage.POST("", image.UploadImage)
+	platformImage.DELETE("/:corId", image.DeleteImage)
+	platformImage.GET("/name/:creatorName/:imageName/tags", image.GetImageTags)
+	platformImage.PUT("/name/:creatorName/:imageName/alias/:alias", image.SetImageAlias)
+	platformImage.DELETE("/name/:creatorName/:imageName/alias/:alias", image.DeleteImageAlias)
+
+	// resumable uploads with the tus protocol
+	platformImage.OPTIONS("/uploads", upload.GetUploadOptions)
+	platformImage.POST("/uploads", upload.CreateUpload)
+	platformImage.HEAD("/uploads/:id", upload.GetUploadOffset)
+	platformImage.GET("/uploads/:id", upload.GetUpload)
+	platformImage.PATCH("/uploads/:id", upload.PatchUpload)
+	platformImage.DELETE("/uploads/:id", upload.DeleteUpload)
+
+	// uploads straight to the bucket with signed URLs
+	platformImage.POST("/upload-url", upload.CreateUploadURL)
+	platformImage.POST("/:corId/confirm", upload.ConfirmUpload)
 
 	platformChallenge := platform.Group("/challenge")
 	platformChallenge.GET("", challenge.GetAllChallenges)
@@ -70,8 +105,14 @@ func InitRoutes() {
 
 	platformProcess := platform.Group("/process")
 	platformProcess.GET("", process.GetAllProcesses)
+	platformProcess.GET("/states", process.GetProcessStates)
+	platformProcess.GET("/stats", process.GetProcessStats)
 	platformProcess.GET("/:corId", process.GetProcessStatusByCorId)
 	platformProcess.GET("/name/:creatorName", process.GetProcessByCreatorName)
+	platformProcess.GET("/name/:creatorName/feed", process.FeedProcessesByCreatorName)
+	platformProcess.POST("/:corId/cancel", process.CancelProcess)
+	platformProcess.GET("/:corId/stream", process.StreamProcess)
+	platformProcess.GET("/:corId/timeline", process.GetProcessTimeline)
 
 	platformAttempt := platform.Group("/attempt")
 	platformAttempt.POST("", attempt.StartAttempt)
@@ -80,6 +121,35 @@ func InitRoutes() {
 	platformAttempt.POST("/submit", attempt.SubmitAttemptByToken)
 	platformAttempt.GET("", attempt.GetAllAttempt)
 
+	platformWebhook := platform.Group("/webhook")
+	platformWebhook.POST("", webhook.CreateWebhook)
+	platformWebhook.GET("/name/:creatorName", webhook.GetWebhooksByCreatorName)
+	platformWebhook.GET("/:id", webhook.GetWebhookById)
+	platformWebhook.DELETE("/:id", webhook.DeleteWebhook)
+	platformWebhook.GET("/:id/delivery", webhook.GetWebhookDeliveries)
+	platformWebhook.POST("/:id/delivery/:deliveryId/redeliver", webhook.RedeliverWebhook)
+
+
+	// admin api
+	admin := v1.Group("/admin")
+
+	adminOutbox := admin.Group("/outbox")
+	adminOutbox.GET("", outbox.GetOutboxStats)
+
+	adminSpool := admin.Group("/spool")
+	adminSpool.GET("", mqSpool.GetSpoolStats)
+
+	adminDeadLetter := admin.Group("/deadletter")
+	adminDeadLetter.GET("", deadLetter.GetAllDeadLetters)
+	adminDeadLetter.GET("/:id", deadLetter.GetDeadLetterById)
+	adminDeadLetter.POST("/:id/redrive", deadLetter.RedriveDeadLetter)
+
+	adminSweeper := admin.Group("/sweeper")
+	adminSweeper.GET("", sweep.GetSweptProcesses)
+
+	adminMigration := admin.Group("/migration")
+	adminMigration.GET("/:name", migration.GetMigration)
+	adminMigration.POST("/:name", migration.StartMigration)
 
 	platformResult := platform.Group("/result")
 	platformResult.POST("/:token", )
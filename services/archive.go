@@ -0,0 +1,189 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"platform_api/configs"
+	"platform_api/models"
+	"strings"
+)
+
+// the Dockerfile the image is built from, at the root of the archive
+const archiveDockerfile = "Dockerfile"
+
+// ValidateArchive checks an uploaded challenge archive before it is stored
+// and returns every rule it breaks. The entries are decompressed to count
+// their real size, the sizes declared in the archive can lie.
+func ValidateArchive(r io.ReaderAt, size int64, limits configs.ArchiveLimits) []models.ArchiveViolation {
+	if size > limits.MaxSize {
+		return []models.ArchiveViolation{{
+			Rule:    models.ArchiveRuleSize,
+			Message: fmt.Sprintf("archive is %d bytes, at most %d are allowed", size, limits.MaxSize),
+		}}
+	}
+
+	// local file header, or the end of central directory of an empty archive
+	magic := make([]byte, 4)
+	_, err := r.ReadAt(magic, 0)
+	if err != nil || !(bytes.Equal(magic, []byte("PK\x03\x04")) || bytes.Equal(magic, []byte("PK\x05\x06"))) {
+		return []models.ArchiveViolation{{
+			Rule:    models.ArchiveRuleFormat,
+			Message: "file is not a zip archive",
+		}}
+	}
+
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return []models.ArchiveViolation{{
+			Rule:    models.ArchiveRuleFormat,
+			Message: fmt.Sprintf("file is not a valid zip archive: %s", err),
+		}}
+	}
+
+	violations := []models.ArchiveViolation{}
+
+	files := 0
+	hasDockerfile := false
+	for _, f := range archive.File {
+		if message := unsafeArchivePath(f); message != "" {
+			violations = append(violations, models.ArchiveViolation{
+				Rule:    models.ArchiveRulePath,
+				Path:    f.Name,
+				Message: message,
+			})
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files++
+		if path.Clean(strings.ReplaceAll(f.Name, "\\", "/")) == archiveDockerfile {
+			hasDockerfile = true
+		}
+	}
+
+	if files > limits.MaxFiles {
+		violations = append(violations, models.ArchiveViolation{
+			Rule:    models.ArchiveRuleFileCount,
+			Message: fmt.Sprintf("archive has %d files, at most %d are allowed", files, limits.MaxFiles),
+		})
+	}
+	if !hasDockerfile {
+		violations = append(violations, models.ArchiveViolation{
+			Rule:    models.ArchiveRuleDockerfile,
+			Path:    archiveDockerfile,
+			Message: "archive has no Dockerfile at its root",
+		})
+	}
+
+	// decompressing too many files is a violation already, skip the work
+	if files > limits.MaxFiles {
+		return violations
+	}
+	if violation := checkUncompressedSize(archive, limits.MaxUncompressedSize); violation != nil {
+		violations = append(violations, *violation)
+	}
+
+	return violations
+}
+
+// unsafeArchivePath explains why an entry could be extracted outside of the
+// directory it is extracted to, or returns an empty string
+func unsafeArchivePath(f *zip.File) string {
+	name := strings.ReplaceAll(f.Name, "\\", "/")
+
+	switch {
+	case strings.ContainsRune(name, 0):
+		return "entry name contains a NUL byte"
+	case strings.HasPrefix(name, "/"):
+		return "entry has an absolute path"
+	case len(name) >= 2 && name[1] == ':':
+		return "entry has a drive letter"
+	case f.Mode()&fs.ModeSymlink != 0:
+		return "entry is a symbolic link"
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "entry path leaves the archive root"
+		}
+	}
+	return ""
+}
+
+// checkUncompressedSize decompresses the archive until it exceeds the limit
+func checkUncompressedSize(archive *zip.Reader, limit int64) *models.ArchiveViolation {
+	// reject what the archive declares before decompressing anything
+	var declared uint64
+	for _, f := range archive.File {
+		declared += f.UncompressedSize64
+	}
+	if declared > uint64(limit) {
+		return &models.ArchiveViolation{
+			Rule:    models.ArchiveRuleUncompressedSize,
+			Message: fmt.Sprintf("archive expands to %d bytes, at most %d are allowed", declared, limit),
+		}
+	}
+
+	remaining := limit
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return &models.ArchiveViolation{
+				Rule:    models.ArchiveRuleFormat,
+				Path:    f.Name,
+				Message: fmt.Sprintf("entry cannot be read: %s", err),
+			}
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(rc, remaining+1))
+		rc.Close()
+		if err != nil {
+			return &models.ArchiveViolation{
+				Rule:    models.ArchiveRuleFormat,
+				Path:    f.Name,
+				Message: fmt.Sprintf("entry cannot be read: %s", err),
+			}
+		}
+
+		remaining -= n
+		if remaining < 0 {
+			return &models.ArchiveViolation{
+				Rule:    models.ArchiveRuleUncompressedSize,
+				Path:    f.Name,
+				Message: fmt.Sprintf("archive expands to more than %d bytes", limit),
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateArchiveObject downloads a stored archive to a temporary file and
+// validates it
+func ValidateArchiveObject(c Uploader, objectPrefix string, limits configs.ArchiveLimits) ([]models.ArchiveViolation, error) {
+	tmp, err := os.CreateTemp("", "archive-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = c.DownloadFile(objectPrefix, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return ValidateArchive(tmp, info.Size(), limits), nil
+}
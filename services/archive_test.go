@@ -0,0 +1,103 @@
+// +build integration
+
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"platform_api/configs"
+	"platform_api/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testArchiveLimits = configs.ArchiveLimits{
+	MaxSize:             1 << 20,
+	MaxUncompressedSize: 64 << 10,
+	MaxFiles:            4,
+}
+
+// archiveEntry is a file of a test archive
+type archiveEntry struct {
+	name    string
+	content string
+	mode    fs.FileMode
+}
+
+// build_test_archive zips the entries, with a Dockerfile at the root
+func build_test_archive(t *testing.T, entries ...archiveEntry) []byte {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+
+	entries = append([]archiveEntry{{name: archiveDockerfile, content: "FROM scratch"}}, entries...)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		f, err := zw.CreateHeader(header)
+		assert.NoError(t, err)
+		f.Write([]byte(entry.content))
+	}
+
+	assert.NoError(t, zw.Close())
+	return archive.Bytes()
+}
+
+func validate_test_archive(archive []byte) []models.ArchiveViolation {
+	return ValidateArchive(bytes.NewReader(archive), int64(len(archive)), testArchiveLimits)
+}
+
+func TestValidateArchive(t *testing.T) {
+	archive := build_test_archive(t, archiveEntry{name: "src/main.py", content: "print()"})
+	assert.Empty(t, validate_test_archive(archive))
+}
+
+func TestValidateArchive_UncompressedSize(t *testing.T) {
+	// compresses to a few hundred bytes
+	archive := build_test_archive(t, archiveEntry{name: "zeros", content: strings.Repeat("0", 128<<10)})
+	assert.Less(t, len(archive), 1<<10)
+
+	violations := validate_test_archive(archive)
+	assert.Len(t, violations, 1)
+	assert.Equal(t, models.ArchiveRuleUncompressedSize, violations[0].Rule)
+}
+
+func TestValidateArchive_FileCount(t *testing.T) {
+	var entries []archiveEntry
+	for _, name := range []string{"a", "b", "c", "d"} {
+		entries = append(entries, archiveEntry{name: name, content: name})
+	}
+	// directories are not counted
+	entries = append(entries, archiveEntry{name: "dir/", mode: fs.ModeDir | 0o755})
+
+	violations := validate_test_archive(build_test_archive(t, entries...))
+	assert.Len(t, violations, 1)
+	assert.Equal(t, models.ArchiveRuleFileCount, violations[0].Rule)
+	assert.Contains(t, violations[0].Message, "5 files")
+}
+
+func TestValidateArchive_Symlink(t *testing.T) {
+	archive := build_test_archive(t, archiveEntry{name: "passwd", content: "/etc/passwd", mode: fs.ModeSymlink | 0o777})
+
+	violations := validate_test_archive(archive)
+	assert.Equal(t, []models.ArchiveViolation{{
+		Rule:    models.ArchiveRulePath,
+		Path:    "passwd",
+		Message: "entry is a symbolic link",
+	}}, violations)
+}
+
+func TestValidateArchive_NulName(t *testing.T) {
+	archive := build_test_archive(t, archiveEntry{name: "run.sh\x00.txt", content: "echo"})
+
+	violations := validate_test_archive(archive)
+	assert.Equal(t, []models.ArchiveViolation{{
+		Rule:    models.ArchiveRulePath,
+		Path:    "run.sh\x00.txt",
+		Message: "entry name contains a NUL byte",
+	}}, violations)
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUploader is an in-memory Uploader for tests. It keeps every object
+// it stores and can be told to fail.
+type MemoryUploader struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	err     error
+}
+
+func NewMemoryUploader() *MemoryUploader {
+	return &MemoryUploader{objects: map[string][]byte{}}
+}
+
+func (t *MemoryUploader) UploadFile(file multipart.File, objectPrefix string) error {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	return t.PutObject(objectPrefix, data)
+}
+
+func (t *MemoryUploader) DeleteFile(objectPrefix string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	delete(t.objects, objectPrefix)
+	return nil
+}
+
+func (t *MemoryUploader) WriteObject(ctx context.Context, r io.Reader, objectPrefix string) (int64, error) {
+	data, readErr := io.ReadAll(r)
+
+	err := t.PutObject(objectPrefix, data)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), readErr
+}
+
+func (t *MemoryUploader) ComposeFiles(dst string, srcs []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+
+	var composed bytes.Buffer
+	for _, src := range srcs {
+		data, ok := t.objects[src]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, src)
+		}
+		composed.Write(data)
+	}
+	t.objects[dst] = composed.Bytes()
+	return nil
+}
+
+func (t *MemoryUploader) DownloadFile(objectPrefix string, w io.Writer) error {
+	data, ok := t.Object(objectPrefix)
+	if !ok {
+		return ErrFileNotFound
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+func (t *MemoryUploader) SignedUploadURL(objectPrefix string, contentType string, maxSize int64, expires time.Time) (string, map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return "", nil, t.err
+	}
+
+	url := fmt.Sprintf("https://storage.invalid/%s?expires=%d", objectPrefix, expires.Unix())
+	headers := map[string]string{
+		"Content-Type":                contentType,
+		"X-Goog-Content-Length-Range": fmt.Sprintf("0,%d", maxSize),
+		"X-Goog-If-Generation-Match":  "0",
+	}
+	return url, headers, nil
+}
+
+func (t *MemoryUploader) GetFileSize(objectPrefix string) (int64, error) {
+	data, ok := t.Object(objectPrefix)
+	if !ok {
+		return 0, ErrFileNotFound
+	}
+	return int64(len(data)), nil
+}
+
+// PutObject stores an object, as a client PUTting to a signed URL would
+func (t *MemoryUploader) PutObject(objectPrefix string, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	t.objects[objectPrefix] = append([]byte(nil), data...)
+	return nil
+}
+
+// Object returns a stored object
+func (t *MemoryUploader) Object(objectPrefix string) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, ok := t.objects[objectPrefix]
+	return data, ok
+}
+
+// Objects lists the stored objects under a prefix in order
+func (t *MemoryUploader) Objects(prefix string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var names []string
+	for name := range t.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FailWith makes every following call return err, nil restores it
+func (t *MemoryUploader) FailWith(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.err = err
+}
+
+// Reset forgets stored objects and simulated failures
+func (t *MemoryUploader) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.objects = map[string][]byte{}
+	t.err = nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"log"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/models"
+	"platform_api/mq"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// processes timed out per sweep, the rest wait for the next one
+const sweepBatchSize = 100
+
+// ProcessTimeoutMessage is published when a process is timed out so the
+// downstream services can clean up after it
+type ProcessTimeoutMessage struct {
+	CorId          string  `json:"corId"`
+	Event          string  `json:"event"`
+	EventStatus    string  `json:"eventStatus"`
+	PreviousStatus string  `json:"previousStatus"`
+	CreatorName    *string `json:"creatorName,omitempty"`
+	ChallengeName  *string `json:"challengeName,omitempty"`
+	ImageName      *string `json:"imageName,omitempty"`
+	ImageTag       *string `json:"imageTag,omitempty"`
+	Participant    *string `json:"participant,omitempty"`
+}
+
+// ProcessSweeper times out processes whose status has not changed for longer
+// than Timeout, so their creators are not left waiting forever
+type ProcessSweeper struct {
+	ProcessCollection      collections.ProcessCollection
+	SweptProcessCollection collections.SweptProcessCollection
+	OutboxCollection       collections.OutboxCollection
+	Publisher              mq.Publisher
+	Timeout                time.Duration
+	Interval               time.Duration
+}
+
+func NewProcessSweeper(client *mongo.Client, publisher mq.Publisher, timeout time.Duration, interval time.Duration) *ProcessSweeper {
+	return &ProcessSweeper{
+		ProcessCollection:      *collections.NewProcessCollection(client),
+		SweptProcessCollection: *collections.NewSweptProcessCollection(client),
+		OutboxCollection:       *collections.NewOutboxCollection(client),
+		Publisher:              publisher,
+		Timeout:                timeout,
+		Interval:               interval,
+	}
+}
+
+// Start sweeps in the background
+func (t *ProcessSweeper) Start() {
+	go func() {
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			t.sweep()
+		}
+	}()
+}
+
+// sweep times out every stale process
+func (t *ProcessSweeper) sweep() {
+	stale, _, err := t.ProcessCollection.GetStaleProcesses(time.Now().Add(-t.Timeout), sweepBatchSize)
+	if err != nil {
+		log.Printf("Failed to find stale processes: %s", err)
+		return
+	}
+
+	for _, event := range *stale {
+		t.timeOut(event)
+	}
+}
+
+// timeOut records the timeout of a process and tells the downstream services
+func (t *ProcessSweeper) timeOut(latest models.ProcessEvent) {
+	if latest.CorId == nil || latest.Event == nil || latest.EventStatus == nil {
+		return
+	}
+	corId := *latest.CorId
+
+	// processes outside the state machines cannot be timed out
+	err := models.ValidateTransition(*latest.Event, *latest.EventStatus, models.EventStatusTimedOut)
+	if err != nil {
+		return
+	}
+
+	// claim the process, another replica may be sweeping it too
+	swept := models.SweptProcess{
+		CorId:          corId,
+		Event:          *latest.Event,
+		PreviousStatus: *latest.EventStatus,
+		LastUpdatedAt:  latest.Time(),
+	}
+	if latest.CreatorName != nil {
+		swept.CreatorName = *latest.CreatorName
+	}
+	statusCode, err := t.SweptProcessCollection.InsertSweptProcess(&swept)
+	if err != nil {
+		if statusCode != http.StatusConflict {
+			log.Printf("Failed to record timed out process %s: %s", corId, err)
+		}
+		return
+	}
+
+	eventStatus := models.EventStatusTimedOut
+	process := latest.Process
+	process.Timestamp = models.NewProcessTimestamp(time.Now())
+	process.EventStatus = &eventStatus
+
+	_, err = t.ProcessCollection.InsertProcess(&process)
+	if err != nil {
+		log.Printf("Failed to record timeout of %s: %s", corId, err)
+
+		// let the next sweep try again
+		_, err = t.SweptProcessCollection.DeleteSweptProcess(swept.Id)
+		if err != nil {
+			log.Printf("Failed to release timed out process %s: %s", corId, err)
+		}
+		return
+	}
+	log.Printf("Timed out %s after %s in %s", corId, time.Since(swept.LastUpdatedAt).Round(time.Second), swept.PreviousStatus)
+
+	env, err := mq.NewEnvelope(mq.EVENT_PROCESS_TIMEOUT, corId, ProcessTimeoutMessage{
+		CorId:          corId,
+		Event:          swept.Event,
+		EventStatus:    eventStatus,
+		PreviousStatus: swept.PreviousStatus,
+		CreatorName:    latest.CreatorName,
+		ChallengeName:  latest.ChallengeName,
+		ImageName:      latest.ImageName,
+		ImageTag:       latest.ImageTag,
+		Participant:    latest.Participant,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal timeout of %s: %s", corId, err)
+		return
+	}
+
+	// the outbox relay retries the publish if it fails now
+	msg, err := env.OutboxMessage(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_PROCESS_TIMEOUT)
+	if err != nil {
+		log.Printf("Failed to marshal timeout of %s: %s", corId, err)
+		return
+	}
+	_, err = t.OutboxCollection.InsertMessage(msg)
+	if err != nil {
+		log.Printf("Failed to queue timeout of %s: %s", corId, err)
+		return
+	}
+	err = mq.Dispatch(t.Publisher, t.OutboxCollection, msg)
+	if err != nil {
+		log.Printf("Queued timeout of %s for retry: %s", corId, err)
+	}
+}
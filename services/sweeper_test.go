@@ -0,0 +1,114 @@
+// +build integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"platform_api/configs"
+	"platform_api/models"
+	"platform_api/mq"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const sweeperTestCreator = "sweeper-test"
+
+var testSweeper = NewProcessSweeper(configs.Client, mq.NewMemoryPublisher(), time.Hour, time.Minute)
+
+// insert_stale_process records a process last updated two hours ago
+func insert_stale_process(t *testing.T, event *string, eventStatus *string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	corId := uuid.New().String()
+	creatorName := sweeperTestCreator
+	updatedAt := time.Now().Add(-2 * time.Hour)
+
+	_, err := testSweeper.ProcessCollection.Collection.InsertOne(ctx, models.ProcessEvent{
+		Id: primitive.NewObjectIDFromTimestamp(updatedAt),
+		Process: models.Process{
+			Timestamp:   models.NewProcessTimestamp(updatedAt),
+			CorId:       &corId,
+			Event:       event,
+			EventStatus: eventStatus,
+			CreatorName: &creatorName,
+		},
+	})
+	assert.NoError(t, err)
+	return corId
+}
+
+func clear_swept_processes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: sweeperTestCreator}}
+	testSweeper.ProcessCollection.Collection.DeleteMany(ctx, filter)
+	testSweeper.SweptProcessCollection.Collection.DeleteMany(ctx, filter)
+	testSweeper.OutboxCollection.Collection.DeleteMany(ctx, bson.D{{Key: "routingKey", Value: mq.ROUTE_PROCESS_TIMEOUT}})
+}
+
+func TestSweep(t *testing.T) {
+	clear_swept_processes()
+	defer clear_swept_processes()
+
+	publisher := testSweeper.Publisher.(*mq.MemoryPublisher)
+	publisher.Reset()
+
+	// records outside the state machines are not picked up, nor are
+	// processes another sweep claimed already
+	event := models.EventImageCreate
+	creating := models.EventStatusImageCreating
+	challengeCreating := models.EventStatusChallengeCreating
+	legacy := insert_stale_process(t, nil, nil)
+	noEvent := insert_stale_process(t, nil, &creating)
+	mismatched := insert_stale_process(t, &event, &challengeCreating)
+	claimed := insert_stale_process(t, &event, &creating)
+	_, err := testSweeper.SweptProcessCollection.InsertSweptProcess(&models.SweptProcess{
+		CorId:       claimed,
+		Event:       event,
+		CreatorName: sweeperTestCreator,
+	})
+	assert.NoError(t, err)
+	corId := insert_stale_process(t, &event, &creating)
+
+	stale, _, err := testSweeper.ProcessCollection.GetStaleProcesses(time.Now().Add(-time.Hour), sweepBatchSize)
+	assert.NoError(t, err)
+	var corIds []string
+	for _, event := range *stale {
+		corIds = append(corIds, *event.CorId)
+	}
+	assert.Contains(t, corIds, corId)
+	for _, skipped := range []string{legacy, noEvent, mismatched, claimed} {
+		assert.NotContains(t, corIds, skipped)
+	}
+
+	testSweeper.sweep()
+
+	latest, _, err := testSweeper.ProcessCollection.GetLatestStatusByCorId(corId)
+	assert.NoError(t, err)
+	assert.Equal(t, models.EventStatusTimedOut, *latest.EventStatus)
+
+	// Check the message that was published
+	var published ProcessTimeoutMessage
+	for _, msg := range publisher.Messages(mq.EXCHANGE_TOPIC_ROUTER, mq.ROUTE_PROCESS_TIMEOUT) {
+		if msg.CorrelationId == corId {
+			json.Unmarshal(mq.UnwrapPayload(msg.Body), &published)
+		}
+	}
+	assert.Equal(t, corId, published.CorId)
+	assert.Equal(t, models.EventStatusImageCreating, published.PreviousStatus)
+
+	// a process is only timed out once
+	testSweeper.sweep()
+
+	events, _, err := testSweeper.ProcessCollection.GetProcessEventsByCorId(corId)
+	assert.NoError(t, err)
+	assert.Len(t, *events, 2)
+}
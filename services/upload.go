@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -16,8 +17,20 @@ const (
 	bucketName = "cob-bucket-challenge"
 )
 
-var uploader *ClientUploader
+var uploader Uploader
 
+// Uploader keeps the uploaded archives in the object store
+type Uploader interface {
+	UploadFile(file multipart.File, objectPrefix string) error
+	DeleteFile(objectPrefix string) error
+	WriteObject(ctx context.Context, r io.Reader, objectPrefix string) (int64, error)
+	ComposeFiles(dst string, srcs []string) error
+	DownloadFile(objectPrefix string, w io.Writer) error
+	SignedUploadURL(objectPrefix string, contentType string, maxSize int64, expires time.Time) (string, map[string]string, error)
+	GetFileSize(objectPrefix string) (int64, error)
+}
+
+// ClientUploader is the Uploader of the Cloud Storage bucket
 type ClientUploader struct {
 	cl         *storage.Client
 	projectID  string
@@ -37,10 +50,15 @@ func Init() {
 	}
 }
 
-func GetUploader() *ClientUploader {
+func GetUploader() Uploader {
 	return uploader
 }
 
+// SetUploader replaces the Uploader, such as with a MemoryUploader in tests
+func SetUploader(u Uploader) {
+	uploader = u
+}
+
 // UploadFile uploads an object
 func (c *ClientUploader) UploadFile(file multipart.File, objectPrefix string) error {
 	ctx := context.Background()
@@ -60,3 +78,137 @@ func (c *ClientUploader) UploadFile(file multipart.File, objectPrefix string) er
 
 	return nil
 }
+
+// DeleteFile deletes an object, an object that does not exist counts as deleted
+func (c *ClientUploader) DeleteFile(objectPrefix string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	err := c.cl.Bucket(c.bucketName).Object(objectPrefix).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("Object.Delete: %v", err)
+	}
+
+	return nil
+}
+
+// WriteObject stores everything read from r as an object, even when reading
+// fails part way, and returns how many bytes were stored
+func (c *ClientUploader) WriteObject(ctx context.Context, r io.Reader, objectPrefix string) (int64, error) {
+	wc := c.cl.Bucket(c.bucketName).Object(objectPrefix).NewWriter(ctx)
+	n, copyErr := io.Copy(wc, r)
+
+	// commit what arrived, the rest can be sent again from the new offset
+	if err := wc.Close(); err != nil {
+		return 0, fmt.Errorf("Writer.Close: %v", err)
+	}
+
+	return n, copyErr
+}
+
+// composeLimit is the most sources a single compose request accepts
+const composeLimit = 32
+
+// ComposeFiles concatenates objects into dst, in order
+func (c *ClientUploader) ComposeFiles(dst string, srcs []string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	bucket := c.cl.Bucket(c.bucketName)
+	target := bucket.Object(dst)
+
+	// dst holds what was composed so far and is the first source of the next round
+	for composed := false; len(srcs) > 0; composed = true {
+		objects := []*storage.ObjectHandle{}
+		if composed {
+			objects = append(objects, target)
+		}
+		for len(objects) < composeLimit && len(srcs) > 0 {
+			objects = append(objects, bucket.Object(srcs[0]))
+			srcs = srcs[1:]
+		}
+
+		_, err := target.ComposerFrom(objects...).Run(ctx)
+		if err != nil {
+			return fmt.Errorf("Composer.Run: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// DownloadFile copies an object to w
+func (c *ClientUploader) DownloadFile(objectPrefix string, w io.Writer) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	rc, err := c.cl.Bucket(c.bucketName).Object(objectPrefix).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("Object.NewReader: %v", err)
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(w, rc); err != nil {
+		return fmt.Errorf("io.Copy: %v", err)
+	}
+
+	return nil
+}
+
+// ErrFileNotFound is returned for objects that do not exist
+var ErrFileNotFound = storage.ErrObjectNotExist
+
+// SignedUploadURL returns a URL that lets a client PUT an object directly to
+// the bucket until it expires. The headers must be sent with the PUT. The
+// object can only be created, not written over once it exists.
+func (c *ClientUploader) SignedUploadURL(objectPrefix string, contentType string, maxSize int64, expires time.Time) (string, map[string]string, error) {
+	headers := map[string]string{
+		"Content-Type": contentType,
+
+		// the bucket rejects larger bodies
+		"X-Goog-Content-Length-Range": fmt.Sprintf("0,%d", maxSize),
+
+		// and any object that exists already
+		"X-Goog-If-Generation-Match": "0",
+	}
+
+	url, err := c.cl.Bucket(c.bucketName).SignedURL(objectPrefix, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      "PUT",
+		ContentType: contentType,
+		Headers: []string{
+			fmt.Sprintf("x-goog-content-length-range:0,%d", maxSize),
+			"x-goog-if-generation-match:0",
+		},
+		Expires: expires,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("Bucket.SignedURL: %v", err)
+	}
+
+	return url, headers, nil
+}
+
+// GetFileSize returns the size of an object, or ErrFileNotFound
+func (c *ClientUploader) GetFileSize(objectPrefix string) (int64, error) {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	attrs, err := c.cl.Bucket(c.bucketName).Object(objectPrefix).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return 0, ErrFileNotFound
+		}
+		return 0, fmt.Errorf("Object.Attrs: %v", err)
+	}
+
+	return attrs.Size, nil
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"platform_api/collections"
+	"platform_api/models"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	WEBHOOK_HEADER_ID        = "X-Webhook-Id"
+	WEBHOOK_HEADER_EVENT     = "X-Webhook-Event"
+	WEBHOOK_HEADER_TIMESTAMP = "X-Webhook-Timestamp"
+	WEBHOOK_HEADER_SIGNATURE = "X-Webhook-Signature"
+
+	// attempts before a delivery is given up
+	webhookMaxAttempts = 8
+	webhookMinBackoff  = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+
+	webhookTimeout = 10 * time.Second
+
+	// terminal statuses recorded while the API was down are still delivered
+	webhookCatchUp = time.Hour
+)
+
+// ErrWebhookAddress is returned when a webhook URL resolves to an address
+// inside the platform's network
+var ErrWebhookAddress = errors.New("webhook address is not allowed")
+
+// newWebhookClient returns a client that only connects to public addresses
+// and does not follow redirects, so subscribers cannot make the API reach
+// internal services such as the cloud metadata endpoint
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+
+		// checked on the resolved address being connected to, so a host
+		// name cannot resolve to a public address first and a private one later
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !webhookAddressAllowed(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookAddressAllowed reports whether an address is on the public internet
+func webhookAddressAllowed(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() ||
+		webhookSharedAddressSpace.Contains(ip))
+}
+
+// carrier-grade NAT range, also used for internal addresses by some clouds
+var webhookSharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// SignWebhook returns the signature of a payload sent at the given unix
+// time, which receivers compare against the X-Webhook-Signature header
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher turns terminal process records into webhook deliveries
+// and POSTs them to the subscribers
+type WebhookDispatcher struct {
+	ProcessCollection      collections.ProcessCollection
+	SubscriptionCollection collections.WebhookSubscriptionCollection
+	DeliveryCollection     collections.WebhookDeliveryCollection
+	HTTPClient             *http.Client
+	Interval               time.Duration
+}
+
+func NewWebhookDispatcher(client *mongo.Client, interval time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		ProcessCollection:      *collections.NewProcessCollection(client),
+		SubscriptionCollection: *collections.NewWebhookSubscriptionCollection(client),
+		DeliveryCollection:     *collections.NewWebhookDeliveryCollection(client),
+		HTTPClient:             newWebhookClient(),
+		Interval:               interval,
+	}
+}
+
+// Start schedules and sends deliveries in the background
+func (t *WebhookDispatcher) Start() {
+	go func() {
+		filter := bson.M{"eventStatus": bson.M{"$in": models.TerminalStatuses()}}
+		after := primitive.NewObjectIDFromTimestamp(time.Now().Add(-webhookCatchUp))
+
+		// should the records stop coming, resume after the last one scheduled
+		for {
+			for event := range t.ProcessCollection.Follow(context.Background(), filter, after, t.Interval) {
+				t.schedule(event)
+				after = event.Id
+			}
+			time.Sleep(t.Interval)
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			t.drain()
+		}
+	}()
+}
+
+// schedule creates a delivery for every subscription interested in the event
+func (t *WebhookDispatcher) schedule(event models.ProcessEvent) {
+	if event.CreatorName == nil || event.Event == nil || event.CorId == nil {
+		return
+	}
+
+	subs, _, err := t.SubscriptionCollection.GetMatchingSubscriptions(*event.CreatorName, *event.Event)
+	if err != nil {
+		log.Printf("Failed to retrieve webhooks for %s: %s", *event.CreatorName, err)
+		return
+	}
+
+	for _, sub := range *subs {
+		delivery := models.WebhookDelivery{
+			Id:             primitive.NewObjectID(),
+			SubscriptionId: sub.Id,
+			ProcessId:      event.Id,
+			CorId:          *event.CorId,
+			Event:          *event.Event,
+			EventStatus:    *event.EventStatus,
+			URL:            sub.URL,
+		}
+
+		payload, err := json.Marshal(models.WebhookPayload{
+			DeliveryId:  delivery.Id.Hex(),
+			CorId:       delivery.CorId,
+			Event:       delivery.Event,
+			EventStatus: delivery.EventStatus,
+			CreatorName: sub.CreatorName,
+			Process:     event,
+		})
+		if err != nil {
+			log.Printf("Failed to marshal webhook payload for %s: %s", delivery.CorId, err)
+			continue
+		}
+		delivery.Payload = string(payload)
+
+		statusCode, err := t.DeliveryCollection.InsertDelivery(&delivery)
+		if err != nil && statusCode != http.StatusConflict {
+			log.Printf("Failed to schedule webhook %s for %s: %s", sub.Id.Hex(), delivery.CorId, err)
+		}
+	}
+}
+
+// drain sends due deliveries until none are left
+func (t *WebhookDispatcher) drain() {
+	for {
+		delivery, statusCode, err := t.DeliveryCollection.ClaimNextPending()
+		if err != nil {
+			if statusCode != http.StatusNotFound {
+				log.Printf("Failed to claim webhook delivery: %s", err)
+			}
+			return
+		}
+
+		t.deliver(delivery)
+	}
+}
+
+// deliver makes one attempt and records its outcome
+func (t *WebhookDispatcher) deliver(delivery *models.WebhookDelivery) {
+	attempt := models.WebhookAttempt{At: time.Now().UTC()}
+
+	sub, statusCode, err := t.SubscriptionCollection.GetSubscriptionById(delivery.SubscriptionId.Hex())
+	if err != nil {
+		attempt.Error = err.Error()
+		var retryAt *time.Time
+		if statusCode != http.StatusNotFound {
+			retryAt = t.retryAt(delivery)
+		}
+		t.record(delivery, attempt, false, retryAt)
+		return
+	}
+
+	attempt.StatusCode, err = t.post(sub, delivery)
+	attempt.DurationMs = time.Since(attempt.At).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		t.record(delivery, attempt, false, t.retryAt(delivery))
+		return
+	}
+
+	t.record(delivery, attempt, true, nil)
+}
+
+// post sends the signed payload, any 2xx response counts as delivered
+func (t *WebhookDispatcher) post(sub *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	payload := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "platform-api-webhook")
+	req.Header.Set(WEBHOOK_HEADER_ID, delivery.Id.Hex())
+	req.Header.Set(WEBHOOK_HEADER_EVENT, delivery.Event)
+	req.Header.Set(WEBHOOK_HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WEBHOOK_HEADER_SIGNATURE, SignWebhook(sub.Secret, timestamp, payload))
+
+	res, err := t.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func (t *WebhookDispatcher) record(delivery *models.WebhookDelivery, attempt models.WebhookAttempt, delivered bool, retryAt *time.Time) {
+	if !delivered {
+		log.Printf("Webhook delivery %s for %s failed (attempt %d): %s", delivery.Id.Hex(), delivery.CorId, delivery.Attempts+1, attempt.Error)
+	}
+
+	_, err := t.DeliveryCollection.RecordAttempt(delivery, attempt, delivered, retryAt)
+	if err != nil {
+		log.Printf("Failed to record webhook delivery %s: %s", delivery.Id.Hex(), err)
+	}
+}
+
+// retryAt returns when to attempt the delivery next, doubling the delay with
+// every failed attempt, or nil once it ran out of attempts
+func (t *WebhookDispatcher) retryAt(delivery *models.WebhookDelivery) *time.Time {
+	attempts := delivery.Attempts + 1
+	if attempts >= webhookMaxAttempts {
+		return nil
+	}
+
+	backoff := webhookMinBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+
+	retryAt := time.Now().Add(backoff)
+	return &retryAt
+}
@@ -0,0 +1,121 @@
+// +build integration
+
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"platform_api/models"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWebhookAddressAllowed(t *testing.T) {
+	for _, address := range []string{"93.184.216.34", "2606:2800:220:1::1"} {
+		assert.True(t, webhookAddressAllowed(net.ParseIP(address)), address)
+	}
+
+	for _, address := range []string{
+		"127.0.0.1",
+		"::1",
+		"10.1.2.3",
+		"172.16.0.1",
+		"192.168.1.1",
+		"169.254.169.254",
+		"100.100.100.200",
+		"fd00:ec2::254",
+		"::ffff:127.0.0.1",
+		"0.0.0.0",
+	} {
+		assert.False(t, webhookAddressAllowed(net.ParseIP(address)), address)
+	}
+}
+
+func TestWebhookDispatcher_PostInternal(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	dispatcher := WebhookDispatcher{HTTPClient: newWebhookClient()}
+	sub := &models.WebhookSubscription{URL: server.URL, Secret: "secret"}
+	delivery := &models.WebhookDelivery{Id: primitive.NewObjectID(), Event: "imageCreate", Payload: "{}"}
+
+	// the test server listens on loopback
+	_, err := dispatcher.post(sub, delivery)
+	assert.True(t, errors.Is(err, ErrWebhookAddress))
+	assert.False(t, called)
+}
+
+func TestWebhookDispatcher_PostRedirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// redirects are not followed, whatever the address
+	client := newWebhookClient()
+	client.Transport = http.DefaultTransport
+	dispatcher := WebhookDispatcher{HTTPClient: client}
+	sub := &models.WebhookSubscription{URL: server.URL, Secret: "secret"}
+	delivery := &models.WebhookDelivery{Id: primitive.NewObjectID(), Event: "imageCreate", Payload: "{}"}
+
+	statusCode, err := dispatcher.post(sub, delivery)
+	assert.Equal(t, http.StatusFound, statusCode)
+	assert.ErrorContains(t, err, "302")
+}
+
+// roundTripFunc lets a test answer the requests of an http.Client
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWebhookDispatcher_PostSigned(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		received = req
+		body, _ = io.ReadAll(req.Body)
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: req}, nil
+	})}
+
+	dispatcher := WebhookDispatcher{HTTPClient: client}
+	sub := &models.WebhookSubscription{URL: "https://ci.example.com/hook", Secret: "secret"}
+	delivery := &models.WebhookDelivery{Id: primitive.NewObjectID(), Event: "imageCreate", Payload: `{"corId":"1a"}`}
+
+	statusCode, err := dispatcher.post(sub, delivery)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, statusCode)
+
+	// the body is sent as is, with the delivery headers
+	assert.Equal(t, delivery.Payload, string(body))
+	assert.Equal(t, delivery.Id.Hex(), received.Header.Get(WEBHOOK_HEADER_ID))
+	assert.Equal(t, "imageCreate", received.Header.Get(WEBHOOK_HEADER_EVENT))
+
+	timestamp, err := strconv.ParseInt(received.Header.Get(WEBHOOK_HEADER_TIMESTAMP), 10, 64)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+
+	// a subscriber verifies the HMAC over the timestamp and the body
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write([]byte(received.Header.Get(WEBHOOK_HEADER_TIMESTAMP) + "." + string(body)))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	assert.Equal(t, expected, received.Header.Get(WEBHOOK_HEADER_SIGNATURE))
+	assert.Equal(t, expected, SignWebhook(sub.Secret, timestamp, body))
+
+	// and another secret does not match
+	assert.NotEqual(t, expected, SignWebhook("other", timestamp, body))
+}